@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"ToDoIt/resources"
+)
+
+// Collector reads metrics from a procfs/sysfs tree rooted at Root, so it can
+// be pointed at a fake tree instead of "/".
+type Collector struct {
+	Root         string
+	StoragePaths []string
+
+	lastIdle, lastTotal uint64
+}
+
+func (c *Collector) path(parts ...string) string {
+	return filepath.Join(append([]string{c.Root}, parts...)...)
+}
+
+func (c *Collector) readString(parts ...string) (string, error) {
+	b, err := os.ReadFile(c.path(parts...))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (c *Collector) Collect() (resources.Metrics, error) {
+	m := resources.Metrics{CollectedAt: time.Now()}
+	var err error
+
+	if m.Hostname, err = c.readString("proc", "sys", "kernel", "hostname"); err != nil {
+		m.Hostname, _ = os.Hostname()
+	}
+	if m.CPUUsage, err = c.cpuUsage(); err != nil {
+		return m, err
+	}
+	if m.Load, err = c.load(); err != nil {
+		return m, err
+	}
+	if m.Memory, err = c.memory(); err != nil {
+		return m, err
+	}
+	for _, p := range c.StoragePaths {
+		m.Storage = append(m.Storage, c.storage(p))
+	}
+	m.Battery = c.battery()
+	m.Thermal = c.thermal()
+	return m, nil
+}
+
+// storage reports a mount point, or why it couldn't, so one missing mount
+// doesn't hide every other metric.
+func (c *Collector) storage(p string) resources.Storage {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(c.path(p), &st); err != nil {
+		return resources.Storage{Path: p, Error: fmt.Sprintf("statfs %s: %v", p, err)}
+	}
+	return resources.Storage{
+		Path:       p,
+		TotalBytes: st.Blocks * uint64(st.Bsize),
+		FreeBytes:  st.Bavail * uint64(st.Bsize),
+	}
+}
+
+// cpuUsage compares the aggregate line of /proc/stat with the previous call.
+// The first call reports usage since boot.
+func (c *Collector) cpuUsage() (float64, error) {
+	line, err := c.readString("proc", "stat")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(strings.SplitN(line, "\n", 2)[0])
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, fmt.Errorf("unexpected /proc/stat format")
+	}
+	var total, idle uint64
+	for i, f := range fields[1:] {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing /proc/stat: %w", err)
+		}
+		total += v
+		if i == 3 || i == 4 { // idle and iowait
+			idle += v
+		}
+	}
+	dTotal, dIdle := total-c.lastTotal, idle-c.lastIdle
+	c.lastTotal, c.lastIdle = total, idle
+	if dTotal == 0 {
+		return 0, nil
+	}
+	return 100 * float64(dTotal-dIdle) / float64(dTotal), nil
+}
+
+func (c *Collector) load() ([3]float64, error) {
+	var load [3]float64
+	s, err := c.readString("proc", "loadavg")
+	if err != nil {
+		return load, err
+	}
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return load, fmt.Errorf("unexpected /proc/loadavg format")
+	}
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return load, fmt.Errorf("parsing /proc/loadavg: %w", err)
+		}
+	}
+	return load, nil
+}
+
+func (c *Collector) memory() (resources.Memory, error) {
+	var mem resources.Memory
+	f, err := os.Open(c.path("proc", "meminfo"))
+	if err != nil {
+		return mem, err
+	}
+	defer f.Close()
+	// kernels before 3.14 have no MemAvailable, estimate it the old way
+	var free uint64
+	hasAvailable := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			mem.TotalBytes = kb * 1024
+		case "MemAvailable:":
+			mem.AvailableBytes = kb * 1024
+			hasAvailable = true
+		case "MemFree:", "Buffers:", "Cached:":
+			free += kb * 1024
+		}
+	}
+	if !hasAvailable {
+		mem.AvailableBytes = free
+	}
+	return mem, scanner.Err()
+}
+
+// battery returns the first power supply of type "Battery", or nil.
+func (c *Collector) battery() *resources.Battery {
+	supplies, _ := filepath.Glob(c.path("sys", "class", "power_supply", "*"))
+	for _, dir := range supplies {
+		rel, _ := filepath.Rel(c.Root, dir)
+		if t, _ := c.readString(rel, "type"); t != "Battery" {
+			continue
+		}
+		capacity, err := c.readString(rel, "capacity")
+		if err != nil {
+			continue
+		}
+		level, err := strconv.Atoi(capacity)
+		if err != nil {
+			continue
+		}
+		status, _ := c.readString(rel, "status")
+		return &resources.Battery{
+			Level:    level,
+			Charging: status == "Charging",
+			Status:   status,
+		}
+	}
+	return nil
+}
+
+func (c *Collector) thermal() []resources.ThermalZone {
+	var zones []resources.ThermalZone
+	dirs, _ := filepath.Glob(c.path("sys", "class", "thermal", "thermal_zone*"))
+	for _, dir := range dirs {
+		rel, _ := filepath.Rel(c.Root, dir)
+		raw, err := c.readString(rel, "temp")
+		if err != nil {
+			continue
+		}
+		milli, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		name, err := c.readString(rel, "type")
+		if err != nil {
+			name = filepath.Base(dir)
+		}
+		zones = append(zones, resources.ThermalZone{Name: name, TempC: milli / 1000})
+	}
+	return zones
+}
+
+// Info reads the static hardware description of the phone.
+func (c *Collector) Info() (resources.Info, error) {
+	var info resources.Info
+	var err error
+	if info.Hostname, err = c.readString("proc", "sys", "kernel", "hostname"); err != nil {
+		info.Hostname, _ = os.Hostname()
+	}
+	// device tree on ARM phones, DMI elsewhere
+	if model, err := c.readString("proc", "device-tree", "model"); err == nil {
+		info.Model = strings.TrimRight(model, "\x00")
+	} else if model, err := c.readString("sys", "class", "dmi", "id", "product_name"); err == nil {
+		info.Model = model
+	}
+	cpus, _ := filepath.Glob(c.path("sys", "devices", "system", "cpu", "cpu[0-9]*"))
+	info.CPUCores = len(cpus)
+	for _, dir := range cpus {
+		rel, _ := filepath.Rel(c.Root, dir)
+		raw, err := c.readString(rel, "cpufreq", "cpuinfo_max_freq")
+		if err != nil {
+			continue
+		}
+		if khz, err := strconv.ParseFloat(raw, 64); err == nil && khz/1e6 > info.CPUMaxGHz {
+			info.CPUMaxGHz = khz / 1e6
+		}
+	}
+	mem, err := c.memory()
+	if err != nil {
+		return info, err
+	}
+	info.MemoryBytes = mem.TotalBytes
+
+	if osRelease, err := c.readString("etc", "os-release"); err == nil {
+		info.OSImage = resources.OSImage(osRelease)
+	}
+	info.KernelVersion, _ = c.readString("proc", "sys", "kernel", "osrelease")
+	procVersion, _ := c.readString("proc", "version")
+	info.KernelBuild = resources.KernelBuildHash(info.KernelVersion, procVersion)
+	return info, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeTree writes files under a temporary root, keyed by their path in it.
+func fakeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestCollect(t *testing.T) {
+	root := fakeTree(t, map[string]string{
+		"proc/sys/kernel/hostname":                "phone-1\n",
+		"proc/stat":                               "cpu  100 0 100 700 100 0 0 0 0 0\ncpu0 100 0 100 700 100 0 0 0 0 0\n",
+		"proc/loadavg":                            "0.50 0.25 0.10 1/123 4567\n",
+		"proc/meminfo":                            "MemTotal:        4000 kB\nMemFree:         1000 kB\nMemAvailable:    3000 kB\n",
+		"sys/class/power_supply/usb/type":         "USB\n",
+		"sys/class/power_supply/battery/type":     "Battery\n",
+		"sys/class/power_supply/battery/status":   "Charging\n",
+		"sys/class/power_supply/battery/capacity": "42\n",
+		"sys/class/thermal/thermal_zone0/type":    "cpu-thermal\n",
+		"sys/class/thermal/thermal_zone0/temp":    "45500\n",
+		"data/.keep":                              "",
+	})
+	c := &Collector{Root: root, StoragePaths: []string{"/data", "/sdcard"}}
+	m, err := c.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Hostname != "phone-1" {
+		t.Errorf("hostname = %q", m.Hostname)
+	}
+	if m.CPUUsage != 20 {
+		t.Errorf("cpu usage = %v, want 20", m.CPUUsage)
+	}
+	if m.Load != [3]float64{0.5, 0.25, 0.1} {
+		t.Errorf("load = %v", m.Load)
+	}
+	if m.Memory.TotalBytes != 4000*1024 || m.Memory.AvailableBytes != 3000*1024 {
+		t.Errorf("memory = %+v", m.Memory)
+	}
+	if m.Battery == nil || m.Battery.Level != 42 || !m.Battery.Charging {
+		t.Errorf("battery = %+v", m.Battery)
+	}
+	if len(m.Thermal) != 1 || m.Thermal[0].Name != "cpu-thermal" || m.Thermal[0].TempC != 45.5 {
+		t.Errorf("thermal = %+v", m.Thermal)
+	}
+
+	if len(m.Storage) != 2 {
+		t.Fatalf("storage = %+v", m.Storage)
+	}
+	if st := m.Storage[0]; st.Error != "" || st.TotalBytes == 0 {
+		t.Errorf("existing mount = %+v", st)
+	}
+	if st := m.Storage[1]; st.Path != "/sdcard" || !strings.Contains(st.Error, "statfs /sdcard") {
+		t.Errorf("missing mount = %+v", st)
+	}
+}
+
+func TestMemoryWithoutMemAvailable(t *testing.T) {
+	root := fakeTree(t, map[string]string{
+		"proc/meminfo": "MemTotal:        4000 kB\nMemFree:         500 kB\nBuffers:         100 kB\nCached:          1400 kB\nSwapCached:      9999 kB\n",
+	})
+	mem, err := (&Collector{Root: root}).memory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem.AvailableBytes != 2000*1024 {
+		t.Errorf("available = %d, want %d", mem.AvailableBytes, 2000*1024)
+	}
+	if used := mem.UsedPercent(); used != 50 {
+		t.Errorf("used = %v%%, want 50", used)
+	}
+}
+
+func TestCollectMissingProcfs(t *testing.T) {
+	if _, err := (&Collector{Root: t.TempDir()}).Collect(); err == nil {
+		t.Error("collected without /proc")
+	}
+}
@@ -0,0 +1,86 @@
+// Command agent runs on each phone and serves its metrics as JSON.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ToDoIt/resources"
+)
+
+const DEFAULT_PORT = "9101"
+
+type agent struct {
+	collector *Collector
+
+	mu      sync.RWMutex
+	latest  resources.Metrics
+	lastErr error
+}
+
+func (a *agent) sample() {
+	m, err := a.collector.Collect()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastErr = err
+	if err == nil {
+		a.latest = m
+	}
+}
+
+func (a *agent) run(interval time.Duration) {
+	a.sample()
+	for range time.Tick(interval) {
+		a.sample()
+	}
+}
+
+func (a *agent) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	m, err := a.latest, a.lastErr
+	a.mu.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+func (a *agent) handleInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := a.collector.Info()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func handlePing(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("pong\n"))
+}
+
+func main() {
+	var listen, root, storage string
+	var interval time.Duration
+	flag.StringVar(&listen, "listen", ":"+DEFAULT_PORT, "address to serve metrics on")
+	flag.StringVar(&root, "root", "/", "root of the procfs/sysfs tree to read")
+	flag.StringVar(&storage, "storage", "/", "comma separated mount points to report")
+	flag.DurationVar(&interval, "interval", 5*time.Second, "sampling interval")
+	flag.Parse()
+
+	a := &agent{collector: &Collector{Root: root, StoragePaths: strings.Split(storage, ",")}}
+	go a.run(interval)
+
+	http.HandleFunc("/api/ping", handlePing)
+	http.HandleFunc("/api/metrics", a.handleMetrics)
+	http.HandleFunc("/api/info", a.handleInfo)
+	log.Printf("agent listening on %s", listen)
+	log.Fatal(http.ListenAndServe(listen, nil))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"ToDoIt/resources"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const AGENT_PORT = "9101"
+
+var agentHTTP = &http.Client{Timeout: 5 * time.Second}
+
+// agentURL turns a phone address ("host" or "host:port") into an agent URL.
+func agentURL(addr, path string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, AGENT_PORT)
+	}
+	return "http://" + addr + path
+}
+
+func agentGet(ctx context.Context, addr, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, agentURL(addr, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := agentHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent %s: %s", addr, resp.Status)
+	}
+	return body, nil
+}
+
+func agentPing(ctx context.Context, addr string) error {
+	_, err := agentGet(ctx, addr, "/api/ping")
+	return err
+}
+
+func agentMetrics(ctx context.Context, addr string) (resources.Metrics, error) {
+	var m resources.Metrics
+	body, err := agentGet(ctx, addr, "/api/metrics")
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return m, fmt.Errorf("agent %s: %w", addr, err)
+	}
+	return m, nil
+}
+
+func agentInfo(ctx context.Context, addr string) (resources.Info, error) {
+	var info resources.Info
+	body, err := agentGet(ctx, addr, "/api/info")
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return info, fmt.Errorf("agent %s: %w", addr, err)
+	}
+	return info, nil
+}
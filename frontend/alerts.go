@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"go.dalton.dog/bubbleup"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AlertRule fires when Metric compared with Threshold holds for at least For.
+// Rules on a cluster apply to every phone below it.
+type AlertRule struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"` // battery, temp, ram, cpu, offline_seconds
+	Op        string  `json:"op"`     // "<" or ">"
+	Threshold float64 `json:"threshold"`
+	For       string  `json:"for,omitempty"`      // e.g. "30s"
+	Severity  string  `json:"severity,omitempty"` // "warn" (default) or "error"
+}
+
+// AlertSinkConfig forwards alerts somewhere outside the TUI. Only sinks on
+// the root cluster are used.
+type AlertSinkConfig struct {
+	Type string `json:"type"` // "webhook" or "log"
+	URL  string `json:"url,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type Alert struct {
+	Rule     AlertRule `json:"rule"`
+	Phone    string    `json:"phone"`
+	Value    float64   `json:"value"`
+	FiredAt  time.Time `json:"fired_at"`
+	Resolved bool      `json:"resolved"`
+	Acked    bool      `json:"acked"`
+}
+
+func (a *Alert) print() string {
+	s := fmt.Sprintf("[%s] %s on %s: %s %s %.1f (is %.1f) since %s",
+		a.Rule.severity(), a.Rule.Name, a.Phone, a.Rule.Metric, a.Rule.Op, a.Rule.Threshold, a.Value, a.FiredAt.Format(time.TimeOnly))
+	if a.Acked {
+		s += " (acked)"
+	}
+	return s
+}
+
+// alertKey names a rule by where it's set rather than by name, since rules
+// with the same name may sit on several clusters above a phone.
+type alertKey struct {
+	cluster string // ID of the cluster holding the rule
+	rule    int    // index in its AlertRules
+	phone   *Phone
+}
+
+type alertEngine struct {
+	pending map[alertKey]time.Time
+	active  map[alertKey]*Alert
+	sinks   []alertSink
+}
+
+func newAlertEngine(sinks []AlertSinkConfig) *alertEngine {
+	e := &alertEngine{pending: map[alertKey]time.Time{}, active: map[alertKey]*Alert{}}
+	for _, cfg := range sinks {
+		switch cfg.Type {
+		case "webhook":
+			e.sinks = append(e.sinks, webhookSink{url: cfg.URL})
+		case "log":
+			e.sinks = append(e.sinks, logSink{path: cfg.Path})
+		}
+	}
+	return e
+}
+
+func (r AlertRule) severity() string {
+	if r.Severity == "" {
+		return "warn"
+	}
+	return r.Severity
+}
+
+// alertMetrics are the metrics a rule can watch.
+func alertMetrics() []string {
+	metrics := []string{"offline_seconds"}
+	for _, def := range historySeries {
+		metrics = append(metrics, def.key)
+	}
+	return metrics
+}
+
+// check turns down rules that could never fire.
+func (r AlertRule) check() error {
+	if !slices.Contains(alertMetrics(), r.Metric) {
+		return fmt.Errorf("alert rule %q: unknown metric %q, use one of %s", r.Name, r.Metric, strings.Join(alertMetrics(), ", "))
+	}
+	if r.Op != "<" && r.Op != ">" {
+		return fmt.Errorf("alert rule %q: op must be < or >, not %q", r.Name, r.Op)
+	}
+	if r.For != "" {
+		if d, err := time.ParseDuration(r.For); err != nil || d < 0 {
+			return fmt.Errorf("alert rule %q: bad duration %q in for", r.Name, r.For)
+		}
+	}
+	if r.Severity != "" && r.Severity != "warn" && r.Severity != "error" {
+		return fmt.Errorf("alert rule %q: severity must be warn or error, not %q", r.Name, r.Severity)
+	}
+	return nil
+}
+
+// checkAlertRules reports the first rule in the tree that could never fire.
+func checkAlertRules(c *Cluster) error {
+	for _, rule := range c.AlertRules {
+		if err := rule.check(); err != nil {
+			return fmt.Errorf("%s: %w", c.labelPath(), err)
+		}
+	}
+	for _, child := range c.ChildrenClusters {
+		if err := checkAlertRules(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r AlertRule) holdFor() time.Duration {
+	d, _ := time.ParseDuration(r.For)
+	return d
+}
+
+func (r AlertRule) matches(v float64) bool {
+	switch r.Op {
+	case "<":
+		return v < r.Threshold
+	case ">":
+		return v > r.Threshold
+	}
+	return false
+}
+
+// metricValue returns the phone's current value for metric, or NaN if the
+// phone hasn't reported it.
+func metricValue(p *Phone, metric string, now time.Time) float64 {
+	if metric == "offline_seconds" {
+		if p.Health.Status != HealthOffline && p.Health.Status != HealthDegraded {
+			return 0
+		}
+		if p.Health.LastSeen.IsZero() {
+			return math.Inf(1)
+		}
+		return now.Sub(p.Health.LastSeen).Seconds()
+	}
+	last, ok := p.History.last()
+	if !ok {
+		return math.NaN()
+	}
+	for _, def := range historySeries {
+		if def.key == metric {
+			return def.value(last)
+		}
+	}
+	return math.NaN()
+}
+
+type ownedRule struct {
+	AlertRule
+	key alertKey
+}
+
+func (t *Phone) alertRules() []ownedRule {
+	var rules []ownedRule
+	for c := t.ParentCluster; c != nil; c = c.Parent {
+		for i, rule := range c.AlertRules {
+			rules = append(rules, ownedRule{rule, alertKey{c.ID, i, t}})
+		}
+	}
+	return rules
+}
+
+func (t *Phone) path() string {
+	return t.ParentCluster.returnPath() + " > " + t.Name
+}
+
+// evaluate checks every rule against every phone and returns the alerts that
+// fired or resolved on this pass.
+func (e *alertEngine) evaluate(root *Cluster, now time.Time) []*Alert {
+	var changed []*Alert
+	seen := map[alertKey]bool{}
+	root.walkPhones(func(p *Phone) {
+		for _, owned := range p.alertRules() {
+			rule, key := owned.AlertRule, owned.key
+			seen[key] = true
+			v := metricValue(p, rule.Metric, now)
+			if math.IsNaN(v) || !rule.matches(v) {
+				delete(e.pending, key)
+				if a, ok := e.active[key]; ok {
+					a.Resolved = true
+					delete(e.active, key)
+					changed = append(changed, a)
+				}
+				continue
+			}
+			if a, ok := e.active[key]; ok {
+				a.Rule, a.Value = rule, v
+				continue
+			}
+			since, ok := e.pending[key]
+			if !ok {
+				since = now
+				e.pending[key] = now
+			}
+			if now.Sub(since) < rule.holdFor() {
+				continue
+			}
+			a := &Alert{Rule: rule, Phone: p.path(), Value: v, FiredAt: now}
+			e.active[key] = a
+			changed = append(changed, a)
+		}
+	})
+	// drop alerts for phones or rules that no longer exist
+	for key := range e.active {
+		if !seen[key] {
+			delete(e.active, key)
+		}
+	}
+	for key := range e.pending {
+		if !seen[key] {
+			delete(e.pending, key)
+		}
+	}
+	return changed
+}
+
+// rekey follows phones to their copies in a reloaded tree, so their alerts
+// don't resolve and fire again.
+func (e *alertEngine) rekey(phones map[*Phone]*Phone) {
+	for key, a := range e.active {
+		if p, ok := phones[key.phone]; ok {
+			delete(e.active, key)
+			key.phone = p
+			e.active[key] = a
+		}
+	}
+	for key, since := range e.pending {
+		if p, ok := phones[key.phone]; ok {
+			delete(e.pending, key)
+			key.phone = p
+			e.pending[key] = since
+		}
+	}
+}
+
+// list returns active alerts, unacknowledged first, newest first.
+func (e *alertEngine) list() []*Alert {
+	var alerts []*Alert
+	for _, a := range e.active {
+		alerts = append(alerts, a)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Acked != alerts[j].Acked {
+			return !alerts[i].Acked
+		}
+		return alerts[i].FiredAt.After(alerts[j].FiredAt)
+	})
+	return alerts
+}
+
+func (e *alertEngine) unacked() int {
+	var n int
+	for _, a := range e.active {
+		if !a.Acked {
+			n++
+		}
+	}
+	return n
+}
+
+type alertSink interface {
+	send(a Alert) error
+}
+
+var webhookHTTP = &http.Client{Timeout: 10 * time.Second}
+
+type webhookSink struct{ url string }
+
+func (s webhookSink) send(a Alert) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookHTTP.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+type logSink struct{ path string }
+
+func (s logSink) send(a Alert) error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("alert log: %w", err)
+	}
+	defer f.Close()
+	state := "FIRING"
+	if a.Resolved {
+		state = "RESOLVED"
+	}
+	_, err = fmt.Fprintf(f, "%s %s %s\n", time.Now().Format(time.RFC3339), state, a.print())
+	return err
+}
+
+type sinkErrMsg struct{ err error }
+
+func (e *alertEngine) forward(a Alert) tea.Cmd {
+	var cmds []tea.Cmd
+	for _, sink := range e.sinks {
+		sink := sink
+		cmds = append(cmds, func() tea.Msg {
+			if err := sink.send(a); err != nil {
+				return sinkErrMsg{err}
+			}
+			return nil
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// evaluateAlerts runs the engine and turns new alerts into TUI popups and
+// sink deliveries.
+func (m *model) evaluateAlerts(now time.Time) tea.Cmd {
+	var cmds []tea.Cmd
+	for _, a := range m.alerts.evaluate(m.rootCluster, now) {
+		cmds = append(cmds, m.alerts.forward(*a))
+		if a.Resolved {
+			continue
+		}
+		level := bubbleup.WarnKey
+		if a.Rule.severity() == "error" {
+			level = bubbleup.ErrorKey
+		}
+		cmds = append(cmds, m.alert.NewAlertCmd(level, a.Rule.Name+": "+a.Phone))
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *model) alertsView() string {
+	alerts := m.alerts.list()
+	s := fmt.Sprintf("Alerts (%d active, %d unacknowledged)\n\n", len(alerts), m.alerts.unacked())
+	if len(alerts) == 0 {
+		s += "Nothing firing.\n"
+	}
+	for i, a := range alerts {
+		line := a.print()
+		if !a.Acked {
+			line = renderWarning(line)
+		}
+		if i == m.alertIndex {
+			line = "> " + line
+		} else {
+			line = "  " + line
+		}
+		s += line + "\n"
+	}
+	return s + "\nup/down: select • a/enter: acknowledge • esc: back"
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSameNamedRulesAtTwoLevels(t *testing.T) {
+	p := &Phone{ID: "p1", Name: "p1"}
+	shelf := &Cluster{Name: "Shelf", ChildrenPhones: []*Phone{p},
+		AlertRules: []AlertRule{{Name: "hot", Metric: "temp", Op: ">", Threshold: 80}}}
+	root := &Cluster{ChildrenClusters: []*Cluster{shelf},
+		AlertRules: []AlertRule{{Name: "hot", Metric: "temp", Op: ">", Threshold: 50}}}
+	reconstructClusterFromJSON(root)
+	p.History.add(Sample{CPU: 10, TempC: 60})
+
+	e := newAlertEngine(nil)
+	now := time.Now()
+	if changed := e.evaluate(root, now); len(changed) != 1 || changed[0].Rule.Threshold != 50 {
+		t.Fatalf("first pass changed %+v", changed)
+	}
+	for i := 1; i <= 3; i++ {
+		if changed := e.evaluate(root, now.Add(time.Duration(i)*time.Second)); len(changed) != 0 {
+			t.Fatalf("pass %d changed %+v, want the alert to stay firing", i, changed)
+		}
+	}
+	if len(e.list()) != 1 {
+		t.Errorf("active = %+v", e.list())
+	}
+}
+
+func TestCheckAlertRules(t *testing.T) {
+	for _, tt := range []struct {
+		rule AlertRule
+		err  string
+	}{
+		{AlertRule{Name: "ok", Metric: "battery", Op: "<", Threshold: 20, For: "30s", Severity: "error"}, ""},
+		{AlertRule{Name: "ok", Metric: "offline_seconds", Op: ">", Threshold: 60}, ""},
+		{AlertRule{Name: "typo", Metric: "batery", Op: "<"}, `unknown metric "batery"`},
+		{AlertRule{Name: "op", Metric: "cpu", Op: ">="}, "op must be < or >"},
+		{AlertRule{Name: "for", Metric: "cpu", Op: ">", For: "5 minutes"}, `bad duration "5 minutes"`},
+		{AlertRule{Name: "sev", Metric: "cpu", Op: ">", Severity: "page"}, "severity must be"},
+	} {
+		root := &Cluster{ChildrenClusters: []*Cluster{{Name: "Lab", AlertRules: []AlertRule{tt.rule}}}}
+		reconstructClusterFromJSON(root)
+		err := checkAlertRules(root)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.rule.Name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err) || !strings.HasPrefix(err.Error(), "Root/Lab: ")):
+			t.Errorf("%s: got %v, want %q", tt.rule.Name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// apiError carries the HTTP status an API failure should be reported with.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string { return e.err.Error() }
+
+func notFound(err error) error   { return &apiError{http.StatusNotFound, err} }
+func badRequest(err error) error { return &apiError{http.StatusBadRequest, err} }
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		status = apiErr.status
+	} else if errors.Is(err, errConflict) {
+		status = http.StatusConflict
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// etag is a digest of what a GET of the resource returns, so any change to
+// it, from the API, the TUI or a health check, invalidates old copies.
+func etag(v any) string {
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(v))
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// checkIfMatch makes writes conditional on the client having seen the
+// current version. "If-Match: *" overwrites whatever is there.
+func checkIfMatch(r *http.Request, current any) error {
+	match := r.Header.Get("If-Match")
+	switch {
+	case match == "":
+		return &apiError{http.StatusPreconditionRequired, errors.New("send If-Match with the ETag you last read, or * to overwrite")}
+	case match != "*" && match != etag(current):
+		return &apiError{http.StatusPreconditionFailed, errors.New("changed since you read it, fetch it again")}
+	}
+	return nil
+}
+
+func decodeBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest(fmt.Errorf("reading body: %w", err))
+	}
+	return nil
+}
+
+type jobJSON struct {
+	Path    string  `json:"path"`
+	State   string  `json:"state"`
+	Percent float64 `json:"percent"`
+}
+
+func newJobJSON(c *Cluster) jobJSON {
+	return jobJSON{c.labelPath(), c.JobState, c.JobPercentage * 100}
+}
+
+// clusterFields are what POST and PATCH on a cluster may set.
+type clusterFields struct {
+	Name           *string `json:"name"`
+	Desc           *string `json:"desc"`
+	RequiredKernel *string `json:"required_kernel"`
+	Parent         *string `json:"parent"` // PATCH only, moves the cluster
+}
+
+// phoneFields are what POST and PATCH on a phone may set, named as in the
+// config file.
+type phoneFields struct {
+	Cluster  *string   `json:"cluster"` // PATCH moves the phone
+	Name     *string   `json:"Name"`
+	Desc     *string   `json:"Desc"`
+	RAM      *string   `json:"RAM"`
+	CPU      *string   `json:"CPU"`
+	CPUSpeed *string   `json:"CPUSpeed"`
+	Address  *string   `json:"address"`
+	Check    *string   `json:"check"`
+	Serial   *string   `json:"serial"`
+	Hostname *string   `json:"hostname"`
+	Role     *string   `json:"role"`
+	Tags     *[]string `json:"tags"`
+}
+
+func (f phoneFields) apply(p *Phone) {
+	for field, value := range map[*string]*string{
+		&p.Name: f.Name, &p.Desc: f.Desc, &p.RAM: f.RAM, &p.CPU: f.CPU, &p.CPUSpeed: f.CPUSpeed,
+		&p.Address: f.Address, &p.Check: f.Check, &p.Serial: f.Serial, &p.Hostname: f.Hostname, &p.Role: f.Role,
+	} {
+		if value != nil {
+			*field = *value
+		}
+	}
+	if f.Tags != nil {
+		p.Tags = *f.Tags
+	}
+}
+
+// apiCluster resolves the {path...} of a request in root; empty is the root.
+func apiCluster(root *Cluster, r *http.Request) (*Cluster, error) {
+	c, err := findCluster(root, r.PathValue("path"))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return c, nil
+}
+
+func apiPhone(root *Cluster, r *http.Request) (*Phone, error) {
+	p, err := findPhone(root, r.PathValue("ref"))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return p, nil
+}
+
+// apiHandle wraps an API handler with the lock and error reporting. Handlers
+// return the status and body to send; a nil body sends no content.
+func (m *model) apiHandle(fn func(r *http.Request) (int, any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		status, body, err := fn(r)
+		m.mu.Unlock()
+		switch {
+		case err != nil:
+			writeAPIError(w, err)
+		case body == nil:
+			w.WriteHeader(status)
+		default:
+			if status == http.StatusCreated {
+				w.Header().Set("Location", apiLocation(body))
+			}
+			writeAPIJSON(w, status, body)
+		}
+	}
+}
+
+func apiLocation(body any) string {
+	switch v := body.(type) {
+	case phoneJSON:
+		return "/api/phones/" + url.PathEscape(v.ID)
+	case clusterJSON:
+		return "/api/clusters/" + (&url.URL{Path: v.Path}).EscapedPath()
+	}
+	return ""
+}
+
+// apiUser is who API changes are stamped with: what the client says, or
+// where it connected from.
+func apiUser(r *http.Request) string {
+	if u := r.Header.Get(userHeader); u != "" {
+		return u
+	}
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return "api@" + host
+}
+
+// redactedToken stands in for k3s join tokens in what the API sends, as
+// anyone holding one can join a node to the cluster. A tree PUT back with it
+// keeps the token the daemon has.
+const redactedToken = "<redacted>"
+
+// apiConfig is the tree as the config file stores it, tokens redacted.
+func apiConfig(root *Cluster) *Cluster {
+	tree := root.DeepCopy()
+	var walk func(c *Cluster)
+	walk = func(c *Cluster) {
+		if c.K3s != nil && c.K3s.Token != "" {
+			c.K3s.Token = redactedToken
+		}
+		for _, child := range c.ChildrenClusters {
+			walk(child)
+		}
+	}
+	walk(tree)
+	return tree
+}
+
+// restoreTokens puts back the tokens apiConfig redacted, from the clusters
+// with the same IDs in current.
+func restoreTokens(root, current *Cluster) error {
+	if root.K3s != nil && root.K3s.Token == redactedToken {
+		c, _ := current.findByID(root.ID)
+		if c == nil || c.K3s == nil {
+			return fmt.Errorf("%s: k3s token is redacted, send the token itself", root.labelPath())
+		}
+		root.K3s.Token = c.K3s.Token
+	}
+	for _, child := range root.ChildrenClusters {
+		if err := restoreTokens(child, current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apiGetConfig returns the tree as the config file stores it, for TUIs
+// running against the daemon.
+func (m *model) apiGetConfig(r *http.Request) (int, any, error) {
+	return http.StatusOK, apiConfig(m.rootCluster), nil
+}
+
+// apiPutConfig replaces the tree with one a TUI edited. The TUI stamps its
+// own changes; the daemon works out which items they were for the event.
+func (m *model) apiPutConfig(r *http.Request) (int, any, error) {
+	if err := checkIfMatch(r, apiConfig(m.rootCluster)); err != nil {
+		return 0, nil, err
+	}
+	var root Cluster
+	if err := decodeBody(r, &root); err != nil {
+		return 0, nil, err
+	}
+	reconstructClusterFromJSON(&root)
+	if err := checkAlertRules(&root); err != nil {
+		return 0, nil, badRequest(err)
+	}
+	if err := restoreTokens(&root, m.rootCluster); err != nil {
+		return 0, nil, badRequest(err)
+	}
+	paths := changedPaths(&root, changedItems(m.fingerprints, fingerprints(&root)))
+	if err := m.write(&root, m.diskHash); err != nil {
+		return 0, nil, err
+	}
+	m.replaceTree(&root)
+	tree := apiConfig(m.rootCluster)
+	if len(paths) > 0 {
+		m.events.publish(changeEvent{By: apiUser(r), Paths: paths, ETag: etag(tree)})
+	}
+	return http.StatusOK, tree, nil
+}
+
+func (m *model) apiGetTree(r *http.Request) (int, any, error) {
+	return http.StatusOK, newClusterJSON(m.rootCluster, 1<<30), nil
+}
+
+func (m *model) apiGetStats(r *http.Request) (int, any, error) {
+	c, err := apiCluster(m.rootCluster, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, clusterStats(c), nil
+}
+
+func (m *model) apiGetCluster(r *http.Request) (int, any, error) {
+	c, err := apiCluster(m.rootCluster, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newClusterJSON(c, 0), nil
+}
+
+// apiCreateCluster adds a cluster under the one in the path.
+func (m *model) apiCreateCluster(r *http.Request) (int, any, error) {
+	root := m.workingCopy()
+	parent, err := apiCluster(root, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var f clusterFields
+	if err := decodeBody(r, &f); err != nil {
+		return 0, nil, err
+	}
+	if f.Name == nil || f.Parent != nil {
+		return 0, nil, badRequest(errors.New("send the new cluster's name, and post it to its parent's path"))
+	}
+	if err := checkName(parent, *f.Name, nil); err != nil {
+		return 0, nil, badRequest(err)
+	}
+	c := &Cluster{ID: newItemID(), Name: *f.Name, Parent: parent, JobState: "stopped"}
+	if f.Desc != nil {
+		c.Desc = *f.Desc
+	}
+	if f.RequiredKernel != nil {
+		c.RequiredKernel = *f.RequiredKernel
+	}
+	parent.ChildrenClusters = append(parent.ChildrenClusters, c)
+	if err := m.saveTree(root, apiUser(r)); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, newClusterJSON(c, 0), nil
+}
+
+func (m *model) apiUpdateCluster(r *http.Request) (int, any, error) {
+	root := m.workingCopy()
+	c, err := apiCluster(root, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := checkIfMatch(r, newClusterJSON(c, 0)); err != nil {
+		return 0, nil, err
+	}
+	var f clusterFields
+	if err := decodeBody(r, &f); err != nil {
+		return 0, nil, err
+	}
+	if c.Parent == nil && (f.Name != nil || f.Parent != nil) {
+		return 0, nil, badRequest(errors.New("the root can't be renamed or moved"))
+	}
+	target := c.Parent
+	if f.Parent != nil {
+		if target, err = findCluster(root, *f.Parent); err != nil {
+			return 0, nil, badRequest(err)
+		}
+	}
+	name := c.plainName()
+	if f.Name != nil {
+		name = *f.Name
+	}
+	if target != nil {
+		if err := checkName(target, name, c); err != nil {
+			return 0, nil, badRequest(err)
+		}
+	}
+	if target != c.Parent {
+		if _, err := moveInto(c, target); err != nil {
+			return 0, nil, badRequest(err)
+		}
+	}
+	if f.Name != nil {
+		c.Name = *f.Name
+	}
+	if f.Desc != nil {
+		c.Desc = *f.Desc
+	}
+	if f.RequiredKernel != nil {
+		c.RequiredKernel = *f.RequiredKernel
+	}
+	if err := m.saveTree(root, apiUser(r)); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newClusterJSON(c, 0), nil
+}
+
+func (m *model) apiDeleteCluster(r *http.Request) (int, any, error) {
+	root := m.workingCopy()
+	c, err := apiCluster(root, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if c.Parent == nil {
+		return 0, nil, badRequest(errors.New("the root can't be removed"))
+	}
+	if err := checkIfMatch(r, newClusterJSON(c, 0)); err != nil {
+		return 0, nil, err
+	}
+	if r.URL.Query().Get("recursive") != "true" && (len(c.ChildrenClusters) > 0 || len(c.ChildrenPhones) > 0) {
+		return 0, nil, &apiError{http.StatusConflict, fmt.Errorf("%s isn't empty, add ?recursive=true to remove everything in it", c.labelPath())}
+	}
+	c.Parent.ChildrenClusters = slices.DeleteFunc(c.Parent.ChildrenClusters, func(child *Cluster) bool { return child == c })
+	if err := m.saveTree(root, apiUser(r)); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusNoContent, nil, nil
+}
+
+// apiListPhones lists every phone, or those under ?cluster=.
+func (m *model) apiListPhones(r *http.Request) (int, any, error) {
+	c, err := findCluster(m.rootCluster, r.URL.Query().Get("cluster"))
+	if err != nil {
+		return 0, nil, notFound(err)
+	}
+	q := &phoneQuery{}
+	if expr := r.URL.Query().Get("q"); expr != "" {
+		if q, err = parseQuery(expr); err != nil {
+			return 0, nil, badRequest(err)
+		}
+	}
+	phones := []phoneJSON{}
+	now := time.Now()
+	c.walkPhones(func(p *Phone) {
+		if q.match(p, now) {
+			phones = append(phones, newPhoneJSON(p))
+		}
+	})
+	return http.StatusOK, phones, nil
+}
+
+func (m *model) apiGetPhone(r *http.Request) (int, any, error) {
+	p, err := apiPhone(m.rootCluster, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newPhoneJSON(p), nil
+}
+
+func (m *model) apiCreatePhone(r *http.Request) (int, any, error) {
+	root := m.workingCopy()
+	var f phoneFields
+	if err := decodeBody(r, &f); err != nil {
+		return 0, nil, err
+	}
+	if f.Cluster == nil || f.Name == nil {
+		return 0, nil, badRequest(errors.New("send at least the phone's cluster and Name"))
+	}
+	c, err := findCluster(root, *f.Cluster)
+	if err != nil {
+		return 0, nil, badRequest(err)
+	}
+	if err := checkName(c, *f.Name, nil); err != nil {
+		return 0, nil, badRequest(err)
+	}
+	p := &Phone{ID: newItemID(), ParentCluster: c}
+	f.apply(p)
+	if err := checkPhoneFields(p.Check, p.Role); err != nil {
+		return 0, nil, badRequest(err)
+	}
+	if err := checkSerial(root, p); err != nil {
+		return 0, nil, badRequest(err)
+	}
+	c.ChildrenPhones = append(c.ChildrenPhones, p)
+	if err := m.saveTree(root, apiUser(r)); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, newPhoneJSON(p), nil
+}
+
+func (m *model) apiUpdatePhone(r *http.Request) (int, any, error) {
+	root := m.workingCopy()
+	p, err := apiPhone(root, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := checkIfMatch(r, newPhoneJSON(p)); err != nil {
+		return 0, nil, err
+	}
+	var f phoneFields
+	if err := decodeBody(r, &f); err != nil {
+		return 0, nil, err
+	}
+	target := p.ParentCluster
+	if f.Cluster != nil {
+		if target, err = findCluster(root, *f.Cluster); err != nil {
+			return 0, nil, badRequest(err)
+		}
+	}
+	// validate on a copy so a bad request changes nothing
+	edited := *p
+	f.apply(&edited)
+	if err := checkName(target, edited.Name, p); err != nil {
+		return 0, nil, badRequest(err)
+	}
+	if err := checkPhoneFields(edited.Check, edited.Role); err != nil {
+		return 0, nil, badRequest(err)
+	}
+	if other := root.phoneBySerial(edited.Serial); other != nil && other != p {
+		return 0, nil, badRequest(fmt.Errorf("serial %s already belongs to %s", edited.Serial, other.labelPath()))
+	}
+	if target != p.ParentCluster {
+		if _, err := moveInto(p, target); err != nil {
+			return 0, nil, badRequest(err)
+		}
+	}
+	f.apply(p)
+	if err := m.saveTree(root, apiUser(r)); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newPhoneJSON(p), nil
+}
+
+func (m *model) apiDeletePhone(r *http.Request) (int, any, error) {
+	root := m.workingCopy()
+	p, err := apiPhone(root, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := checkIfMatch(r, newPhoneJSON(p)); err != nil {
+		return 0, nil, err
+	}
+	p.ParentCluster.ChildrenPhones = slices.DeleteFunc(p.ParentCluster.ChildrenPhones, func(other *Phone) bool { return other == p })
+	if err := m.saveTree(root, apiUser(r)); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusNoContent, nil, nil
+}
+
+// apiListJobs reports every cluster's job, root first.
+func (m *model) apiListJobs(r *http.Request) (int, any, error) {
+	var jobs []jobJSON
+	var walk func(c *Cluster)
+	walk = func(c *Cluster) {
+		jobs = append(jobs, newJobJSON(c))
+		for _, child := range c.ChildrenClusters {
+			walk(child)
+		}
+	}
+	walk(m.rootCluster)
+	return http.StatusOK, jobs, nil
+}
+
+func (m *model) apiGetJob(r *http.Request) (int, any, error) {
+	c, err := apiCluster(m.rootCluster, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newJobJSON(c), nil
+}
+
+// apiControlJob starts, stops or restarts a cluster's job, as s, x and r
+// do in the TUI.
+func (m *model) apiControlJob(r *http.Request) (int, any, error) {
+	c, err := apiCluster(m.rootCluster, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	var body struct {
+		Action string `json:"action"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		return 0, nil, err
+	}
+	if err := m.sim.controlJob(c, body.Action); err != nil {
+		return 0, nil, badRequest(err)
+	}
+	return http.StatusOK, newJobJSON(c), nil
+}
+
+// apiControlQueryJobs starts, stops or restarts the jobs of the clusters
+// holding the phones ?q= selects.
+func (m *model) apiControlQueryJobs(r *http.Request) (int, any, error) {
+	expr := r.URL.Query().Get("q")
+	if expr == "" {
+		return 0, nil, badRequest(errors.New("send ?q=<query>, or post to a cluster's job"))
+	}
+	q, err := parseQuery(expr)
+	if err != nil {
+		return 0, nil, badRequest(err)
+	}
+	var body struct {
+		Action string `json:"action"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		return 0, nil, err
+	}
+	clusters := jobClusters(q.selectPhones(m.rootCluster, time.Now()))
+	if len(clusters) == 0 {
+		return 0, nil, notFound(fmt.Errorf("no phones match %q", expr))
+	}
+	jobs := []jobJSON{}
+	for _, c := range clusters {
+		if err := m.sim.controlJob(c, body.Action); err != nil {
+			return 0, nil, badRequest(err)
+		}
+		jobs = append(jobs, newJobJSON(c))
+	}
+	return http.StatusOK, jobs, nil
+}
+
+func (m *model) apiHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/tree", m.apiHandle(m.apiGetTree))
+	mux.HandleFunc("GET /api/config", m.apiHandle(m.apiGetConfig))
+	mux.HandleFunc("PUT /api/config", m.apiHandle(m.apiPutConfig))
+	mux.HandleFunc("GET /api/events", m.handleEvents)
+	mux.HandleFunc("GET /api/stats/{path...}", m.apiHandle(m.apiGetStats))
+	mux.HandleFunc("GET /api/clusters/{path...}", m.apiHandle(m.apiGetCluster))
+	mux.HandleFunc("POST /api/clusters/{path...}", m.apiHandle(m.apiCreateCluster))
+	mux.HandleFunc("PATCH /api/clusters/{path...}", m.apiHandle(m.apiUpdateCluster))
+	mux.HandleFunc("DELETE /api/clusters/{path...}", m.apiHandle(m.apiDeleteCluster))
+	mux.HandleFunc("GET /api/phones", m.apiHandle(m.apiListPhones))
+	mux.HandleFunc("POST /api/phones", m.apiHandle(m.apiCreatePhone))
+	mux.HandleFunc("GET /api/phones/{ref...}", m.apiHandle(m.apiGetPhone))
+	mux.HandleFunc("PATCH /api/phones/{ref...}", m.apiHandle(m.apiUpdatePhone))
+	mux.HandleFunc("DELETE /api/phones/{ref...}", m.apiHandle(m.apiDeletePhone))
+	mux.HandleFunc("GET /api/jobs", m.apiHandle(m.apiListJobs))
+	mux.HandleFunc("POST /api/jobs", m.apiHandle(m.apiControlQueryJobs))
+	mux.HandleFunc("GET /api/jobs/{path...}", m.apiHandle(m.apiGetJob))
+	mux.HandleFunc("POST /api/jobs/{path...}", m.apiHandle(m.apiControlJob))
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(token)) != 1 {
+			writeAPIError(w, &apiError{http.StatusUnauthorized, errors.New("missing or wrong API token")})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func readAPIToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading API token: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("API token file %s is empty", path)
+	}
+	return token, nil
+}
+
+func (m *model) serveAPI(addr, token string) {
+	go func() {
+		if err := http.ListenAndServe(addr, m.apiHandler(token)); err != nil {
+			fmt.Println("Error serving the API:", err)
+			os.Exit(1)
+		}
+	}()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ToDoIt/resources"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// newAPITest serves the API for root, saved to a config file of its own.
+func newAPITest(t *testing.T, root *Cluster) (*model, *httptest.Server) {
+	t.Helper()
+	old := config_path
+	config_path = filepath.Join(t.TempDir(), "config.json")
+	t.Cleanup(func() { config_path = old })
+	reconstructClusterFromJSON(root)
+	if err := MarshalToFile(config_path, root.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := fileHash(config_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &model{
+		list:         list.New(nil, itemDelegate{}, 80, 24),
+		rootCluster:  root,
+		fingerprints: fingerprints(root),
+		diskHash:     hash,
+		alerts:       newAlertEngine(nil),
+		sim:          newSimulator(1),
+		headless:     true,
+	}
+	m.recreateList(root, 0)
+	srv := httptest.NewServer(m.apiHandler(""))
+	t.Cleanup(srv.Close)
+	return m, srv
+}
+
+func apiCall(t *testing.T, srv *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodGet {
+		req.Header.Set("If-Match", "*")
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func apiTestTree() *Cluster {
+	return &Cluster{ID: "root", ChildrenClusters: []*Cluster{
+		{ID: "lab", Name: "Lab", K3s: &K3sConfig{ServerURL: "https://10.0.0.1:6443", Token: "K10secret"},
+			ChildrenPhones: []*Phone{{ID: "p1", Name: "pixel", RAM: "8GB"}}},
+		{ID: "shelf", Name: "Shelf"},
+	}}
+}
+
+func TestAPIFailedSaveChangesNothing(t *testing.T) {
+	m, srv := newAPITest(t, apiTestTree())
+	_, before := apiCall(t, srv, "GET", "/api/config", "")
+
+	// someone else edits the file, so every save conflicts
+	os.WriteFile(config_path, []byte(`{"Name":"theirs"}`), 0644)
+	for _, tt := range []struct{ method, path, body string }{
+		{"POST", "/api/clusters/Root/Lab", `{"name":"Rack"}`},
+		{"PATCH", "/api/clusters/Root/Lab", `{"name":"Lab2","parent":"Root/Shelf"}`},
+		{"DELETE", "/api/clusters/Root/Shelf", ""},
+		{"POST", "/api/phones", `{"cluster":"Root/Shelf","Name":"new"}`},
+		{"PATCH", "/api/phones/p1", `{"Name":"renamed","cluster":"Root/Shelf"}`},
+		{"DELETE", "/api/phones/p1", ""},
+	} {
+		if status, body := apiCall(t, srv, tt.method, tt.path, tt.body); status != http.StatusConflict {
+			t.Errorf("%s %s = %d %s, want a conflict", tt.method, tt.path, status, body)
+		}
+		if _, after := apiCall(t, srv, "GET", "/api/config", ""); after != before {
+			t.Errorf("%s %s changed the tree:\n%s", tt.method, tt.path, after)
+		}
+	}
+	if _, p := m.rootCluster.findByID("p1"); p == nil || p.ParentCluster.Name != "Lab" {
+		t.Errorf("p1 = %+v", p)
+	}
+}
+
+func TestAPISavedEditKeepsState(t *testing.T) {
+	m, srv := newAPITest(t, apiTestTree())
+	_, p := m.rootCluster.findByID("p1")
+	metrics := &resources.Metrics{Hostname: "pixel"}
+	p.Metrics = metrics
+
+	if status, body := apiCall(t, srv, "PATCH", "/api/phones/p1", `{"Name":"renamed","cluster":"Root/Shelf"}`); status != http.StatusOK {
+		t.Fatalf("PATCH = %d %s", status, body)
+	}
+	c, p := m.rootCluster.findByID("p1")
+	if c != nil || p == nil || p.Name != "renamed" || p.ParentCluster.Name != "Shelf" || p.Metrics != metrics {
+		t.Errorf("p1 after the edit = %+v", p)
+	}
+	root, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, p := root.findByID("p1"); p == nil || p.Name != "renamed" {
+		t.Errorf("saved p1 = %+v", p)
+	}
+}
+
+func TestAPIRedactsK3sToken(t *testing.T) {
+	m, srv := newAPITest(t, apiTestTree())
+	status, body := apiCall(t, srv, "GET", "/api/config", "")
+	var tree Cluster
+	if err := json.Unmarshal([]byte(body), &tree); err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK || strings.Contains(body, "K10secret") || tree.ChildrenClusters[0].K3s.Token != redactedToken {
+		t.Fatalf("GET /api/config = %d %s", status, body)
+	}
+
+	// a TUI sends back what it got, the token stays as it was
+	tree.ChildrenClusters[1].Desc = "edited"
+	b, _ := json.Marshal(&tree)
+	req, _ := http.NewRequest("PUT", srv.URL+"/api/config", strings.NewReader(string(b)))
+	req.Header.Set("If-Match", etag(apiConfig(m.rootCluster)))
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || strings.Contains(string(out), "K10secret") {
+		t.Fatalf("PUT /api/config = %d %s", resp.StatusCode, out)
+	}
+	if c, _ := m.rootCluster.findByID("lab"); c == nil || c.K3s.Token != "K10secret" {
+		t.Errorf("token after PUT = %+v", c.K3s)
+	}
+	saved, _ := os.ReadFile(config_path)
+	if !strings.Contains(string(saved), "K10secret") {
+		t.Errorf("saved config lost the token:\n%s", saved)
+	}
+}
+
+// TestAPIPutWhileRendering replaces the tree while the UI redraws; run it
+// with -race.
+func TestAPIPutWhileRendering(t *testing.T) {
+	m, srv := newAPITest(t, apiTestTree())
+	renderable(m)
+	var bodies []string
+	for i := range 10 {
+		tree := apiConfig(m.rootCluster)
+		tree.ChildrenClusters[1].Desc = fmt.Sprintf("edit %d", i)
+		b, _ := json.Marshal(tree)
+		bodies = append(bodies, string(b))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, body := range bodies {
+			if status, out := apiCall(t, srv, "PUT", "/api/config", body); status != http.StatusOK {
+				t.Errorf("PUT = %d %s", status, out)
+			}
+		}
+	}()
+	for rendering := true; rendering; {
+		select {
+		case <-done:
+			rendering = false
+		default:
+			m.View()
+		}
+	}
+	if c, _ := m.rootCluster.findByID("shelf"); c == nil || c.Desc != "edit 9" {
+		t.Errorf("shelf after the PUTs = %+v", c)
+	}
+}
+
+func TestReloadDiskETag(t *testing.T) {
+	m, srv := newAPITest(t, apiTestTree())
+	m.events = newEventHub()
+	events := m.events.subscribe()
+
+	// someone else edits the file, keeping the token
+	theirs := m.rootCluster.DeepCopy()
+	theirs.ChildrenClusters[1].Desc = "theirs"
+	if err := MarshalToFile(config_path, theirs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.reloadDisk(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Get(srv.URL + "/api/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// clients compare the event's ETag with the one GET gave them
+	if e := <-events; e.ETag != resp.Header.Get("ETag") {
+		t.Errorf("event ETag = %s, GET ETag = %s", e.ETag, resp.Header.Get("ETag"))
+	}
+}
+
+func TestQueryJobs(t *testing.T) {
+	root := apiTestTree()
+	root.ChildrenClusters[1].ChildrenPhones = []*Phone{{ID: "p2", Name: "small", RAM: "4GB"}}
+	root.ChildrenClusters = append(root.ChildrenClusters, &Cluster{ID: "big", Name: "Big", ChildrenPhones: []*Phone{{ID: "p3", Name: "big", RAM: "12GB"}}})
+	m, srv := newAPITest(t, root)
+
+	var status int
+	out := captureStdout(t, func() { status = runJob([]string{"-server", srv.URL, "-where", "ram>=6", "start"}) })
+	if status != 0 || out != "Root/Lab  running  0%\nRoot/Big  running  0%\n" {
+		t.Errorf("job -where = %d %q", status, out)
+	}
+	for id, want := range map[string]string{"lab": "running", "shelf": "stopped", "big": "running"} {
+		if c, _ := m.rootCluster.findByID(id); c.JobState != want {
+			t.Errorf("%s job %s, want %s", id, c.JobState, want)
+		}
+	}
+
+	out = captureStdout(t, func() { status = runJob([]string{"-server", srv.URL, "Root/Big", "stop"}) })
+	if c, _ := m.rootCluster.findByID("big"); status != 0 || c.JobState != "stopped" || out != "Root/Big  stopped  0%\n" {
+		t.Errorf("job Root/Big stop = %d %q, job %s", status, out, c.JobState)
+	}
+
+	if status, body := apiCall(t, srv, "POST", "/api/jobs?q="+url.QueryEscape("ram>=64"), `{"action":"start"}`); status != http.StatusNotFound {
+		t.Errorf("jobs for no phones = %d %s", status, body)
+	}
+	if status, body := apiCall(t, srv, "POST", "/api/jobs?q="+url.QueryEscape("ram>=6"), `{"action":"pause"}`); status != http.StatusBadRequest {
+		t.Errorf("unknown action = %d %s", status, body)
+	}
+	if status := runJob([]string{"-where", "ram>=6", "start"}); status != 2 {
+		t.Errorf("job without -server exited %d", status)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// K3sConfig holds the join settings handed to phones by the bootstrap
+// server. Like probes, a cluster without one inherits its parent's.
+type K3sConfig struct {
+	ServerURL string   `json:"server_url,omitempty"` // e.g. https://192.168.0.1:6443
+	Token     string   `json:"token,omitempty"`
+	TokenFile string   `json:"token_file,omitempty"` // read on every fetch, takes precedence over token
+	Args      []string `json:"args,omitempty"`       // extra K3S_OPTS
+}
+
+func (c *K3sConfig) deepCopy() *K3sConfig {
+	if c == nil {
+		return nil
+	}
+	newC := *c
+	newC.Args = slices.Clone(c.Args)
+	return &newC
+}
+
+func (c *K3sConfig) token() (string, error) {
+	if c.TokenFile == "" {
+		return c.Token, nil
+	}
+	b, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("reading k3s token: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (i *Cluster) effectiveK3s() *K3sConfig {
+	for current := i; current != nil; current = current.Parent {
+		if current.K3s != nil {
+			return current.K3s
+		}
+	}
+	return nil
+}
+
+var nodeNameUnsafe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// nodeName is the k3s node name the phone registers with.
+func (t *Phone) nodeName() string {
+	if t.Hostname != "" {
+		return t.Hostname
+	}
+	return strings.Trim(nodeNameUnsafe.ReplaceAllString(strings.ToLower(t.Name), "-"), "-")
+}
+
+// k3sConfFile renders /etc/conf.d/k3s for the OpenRC service.
+func (t *Phone) k3sConfFile(cfg *K3sConfig) string {
+	s := "# generated for " + t.labelPath() + "\n"
+	s += "export PATH=\"/usr/libexec/cni/:$PATH\"\n"
+	s += fmt.Sprintf("K3S_EXEC=%q\n", t.role())
+	s += fmt.Sprintf("K3S_OPTS=%q\n", strings.Join(cfg.Args, " "))
+	return s
+}
+
+// rancherConfig renders /etc/rancher/k3s/config.yaml. Values are written as
+// double-quoted strings, which YAML reads the same way Go quotes them.
+func (t *Phone) rancherConfig(cfg *K3sConfig) (string, error) {
+	token, err := cfg.token()
+	if err != nil {
+		return "", err
+	}
+	s := "# generated for " + t.labelPath() + "\n"
+	if cfg.ServerURL != "" {
+		s += "server: " + strconv.Quote(cfg.ServerURL) + "\n"
+	}
+	if token != "" {
+		s += "token: " + strconv.Quote(token) + "\n"
+	}
+	s += "node-name: " + strconv.Quote(t.nodeName()) + "\n"
+	labels := t.desiredLabels()
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	s += "node-label:\n"
+	for _, k := range keys {
+		s += "  - " + strconv.Quote(k+"="+labels[k]) + "\n"
+	}
+	if taints := t.desiredTaints(); len(taints) > 0 {
+		s += "node-taint:\n"
+		for _, taint := range taints {
+			s += "  - " + strconv.Quote(fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect)) + "\n"
+		}
+	}
+	return s, nil
+}
+
+func (m *model) handleBootstrap(w http.ResponseWriter, r *http.Request) {
+	file := strings.TrimPrefix(r.URL.Path, "/")
+	if file != "k3s_config" && file != "rancher_config" {
+		http.NotFound(w, r)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	p := m.enrollingPhone(requestToken(r), now)
+	if p == nil {
+		m.bootstrapLog.Printf("%s %s: missing, unknown, used or expired enrollment token", r.RemoteAddr, file)
+		http.Error(w, "invalid enrollment token", http.StatusUnauthorized)
+		return
+	}
+	cfg := p.ParentCluster.effectiveK3s()
+	if cfg == nil {
+		m.bootstrapLog.Printf("%s %s: %s has no k3s settings", r.RemoteAddr, file, p.labelPath())
+		http.Error(w, "no k3s settings for this phone's cluster", http.StatusInternalServerError)
+		return
+	}
+	body := p.k3sConfFile(cfg)
+	if file == "rancher_config" {
+		// config.yaml carries the cluster token, so fetching it uses up the
+		// enrollment token; k3s_config can be fetched until then.
+		var err error
+		if body, err = p.rancherConfig(cfg); err != nil {
+			m.bootstrapLog.Printf("%s %s: %s: %v", r.RemoteAddr, file, p.labelPath(), err)
+			http.Error(w, "rendering config failed", http.StatusInternalServerError)
+			return
+		}
+		p.consumeEnrollment(now, r.RemoteAddr)
+		p.advance(StageK3s, now)
+		if err := m.saveAs(p.labelPath()); err != nil {
+			m.bootstrapLog.Printf("saving after enrolling %s: %v", p.labelPath(), err)
+		}
+	}
+	m.bootstrapLog.Printf("%s fetched %s for %s (%s)", r.RemoteAddr, file, p.labelPath(), p.ID)
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, body)
+}
+
+// bootstrapHosts is every address this machine might be reached on, for the
+// server certificate.
+func bootstrapHosts() []string {
+	hosts := []string{"localhost"}
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			hosts = append(hosts, n.IP.String())
+		}
+	}
+	return hosts
+}
+
+func writePEM(path, kind string, der []byte, mode os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), mode)
+}
+
+func readPEM(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block.Bytes, nil
+}
+
+func newCertificate(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 120))
+	if err != nil {
+		return nil, nil, err
+	}
+	template.SerialNumber = serial
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	return der, key, err
+}
+
+// loadOrCreateCA keeps a self-signed CA in dir, so phones only need to be
+// given ca.crt once.
+func loadOrCreateCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if certDER, err := readPEM(certPath); err == nil {
+		keyDER, err := readPEM(keyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := x509.ParseECPrivateKey(keyDER)
+		return cert, key, err
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	der, key, err := newCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "powercluster bootstrap CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating CA: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(keyPath, "EC PRIVATE KEY", keyDER, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certPath, "CERTIFICATE", der, 0644); err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// bootstrapTLS signs a fresh server certificate for hosts on every start; the
+// CA is the only thing that has to stay put.
+func bootstrapTLS(dir string, hosts []string) (*tls.Config, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	ca, caKey, err := loadOrCreateCA(dir)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "powercluster bootstrap"},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().AddDate(1, 0, 0),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, key, err := newCertificate(template, ca, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating server certificate: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der, ca.Raw}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func (m *model) serveBootstrap(addr, tlsDir string, hosts []string) error {
+	tlsConfig, err := bootstrapTLS(tlsDir, hosts)
+	if err != nil {
+		return fmt.Errorf("bootstrap TLS: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", m.handleBootstrap)
+	// bind here so a port in use is reported before the UI starts
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("bootstrap server: %w", err)
+	}
+	server := &http.Server{Handler: mux, TLSConfig: tlsConfig, ErrorLog: m.bootstrapLog}
+	go func() {
+		if err := server.ServeTLS(ln, "", ""); err != nil {
+			fmt.Println("Error serving bootstrap configs:", err)
+			os.Exit(1)
+		}
+	}()
+	return nil
+}
+
+func openBootstrapLog(path string) (*log.Logger, error) {
+	if path == "" {
+		return log.New(os.Stderr, "bootstrap: ", log.LstdFlags), nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening bootstrap log: %w", err)
+	}
+	return log.New(f, "", log.LstdFlags), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/help"
+	"go.dalton.dog/bubbleup"
+)
+
+// renderable fills in what View needs beyond the tree.
+func renderable(m *model) {
+	m.createNewUI = &CreateNewUI{}
+	m.help = help.New()
+	m.alert = *bubbleup.NewAlertModel(20, true)
+}
+
+// TestBootstrapWhileRendering enrolls phones while the UI redraws; run it
+// with -race.
+func TestBootstrapWhileRendering(t *testing.T) {
+	root := apiTestTree()
+	lab := root.ChildrenClusters[0]
+	for i := range 10 {
+		lab.ChildrenPhones = append(lab.ChildrenPhones, &Phone{ID: fmt.Sprintf("e%d", i), Name: fmt.Sprintf("enrolling-%d", i)})
+	}
+	m, _ := newAPITest(t, root)
+	renderable(m)
+	m.recreateList(lab, 0)
+	m.showPipeline = true // draws the stages the fetches advance
+	m.bootstrapLog = log.New(io.Discard, "", 0)
+	var tokens []string
+	for _, p := range lab.ChildrenPhones[1:] {
+		tokens = append(tokens, p.newEnrollmentToken(time.Now(), time.Hour))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, token := range tokens {
+			w := httptest.NewRecorder()
+			m.handleBootstrap(w, httptest.NewRequest("GET", "/rancher_config?token="+token, nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("fetch = %d %s", w.Code, w.Body)
+			}
+		}
+	}()
+	for rendering := true; rendering; {
+		select {
+		case <-done:
+			rendering = false
+		default:
+			m.View()
+		}
+	}
+	for _, p := range lab.ChildrenPhones[1:] {
+		if p.Enrollment.UsedAt == nil {
+			t.Errorf("%s wasn't enrolled", p.Name)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/charmbracelet/bubbles/progress"
+	tea "github.com/charmbracelet/bubbletea"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unlockJob is one device in a bulk run. record is set up front for devices
+// that were skipped, and by the worker otherwise.
+type unlockJob struct {
+	device  AndroidDevice
+	vendor  Vendor
+	code    string // for vendors that need one
+	record  *UnlockRecord
+	logPath string
+}
+
+// loggedTools writes every adb/fastboot call and its output to a device log.
+// Device listing is left out, waitForFastboot polls it every second.
+type loggedTools struct {
+	DeviceTools
+	log *log.Logger
+}
+
+func (t loggedTools) Getprop(ctx context.Context, serial, prop string) (string, error) {
+	out, err := t.DeviceTools.Getprop(ctx, serial, prop)
+	t.log.Printf("getprop %s = %q (err %v)", prop, out, err)
+	return out, err
+}
+
+func (t loggedTools) RebootBootloader(ctx context.Context, serial string) error {
+	err := t.DeviceTools.RebootBootloader(ctx, serial)
+	t.log.Printf("reboot bootloader (err %v)", err)
+	return err
+}
+
+func (t loggedTools) Fastboot(ctx context.Context, serial string, args ...string) (string, error) {
+	out, err := t.DeviceTools.Fastboot(ctx, serial, args...)
+	t.log.Printf("fastboot %s (err %v)\n%s", strings.Join(args, " "), err, out)
+	return out, err
+}
+
+// unlockReporter hears about progress from the workers.
+type unlockReporter interface {
+	step(i int, step string)
+	done(i int, record UnlockRecord)
+}
+
+type plainReporter struct {
+	jobs []*unlockJob
+	mu   sync.Mutex
+}
+
+func (r *plainReporter) step(i int, step string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Printf("%s: %s\n", r.jobs[i].device.Serial, step)
+}
+
+func (r *plainReporter) done(i int, record UnlockRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Printf("%s: %s %s\n", r.jobs[i].device.Serial, record.Result, record.Error)
+}
+
+type teaReporter struct{ p *tea.Program }
+
+func (r teaReporter) step(i int, step string)         { r.p.Send(bulkStepMsg{i, step}) }
+func (r teaReporter) done(i int, record UnlockRecord) { r.p.Send(bulkDoneMsg{i, record}) }
+
+// runUnlockJobs unlocks the confirmed devices with at most workers at a
+// time, on a progress screen when stdout is a terminal.
+func runUnlockJobs(ctx context.Context, u *unlocker, jobs []*unlockJob, workers int, logDir string) error {
+	var pending []int
+	for i, job := range jobs {
+		if job.record == nil {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return fmt.Errorf("creating log dir: %w", err)
+	}
+	workers = max(1, min(workers, len(pending)))
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var reporter unlockReporter = &plainReporter{jobs: jobs}
+	var p *tea.Program
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		p = tea.NewProgram(newBulkModel(jobs, workers, logDir), tea.WithInput(nil))
+		reporter = teaReporter{p}
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+				// an unlock cut short by the interrupt didn't fail, it was abandoned
+				if record := unlockOne(ctx, u, jobs[i], i, logDir, reporter); ctx.Err() == nil || record.Result != "failed" {
+					jobs[i].record = record
+				}
+			}
+		}()
+	}
+	go func() {
+		for _, i := range pending {
+			queue <- i
+		}
+		close(queue)
+	}()
+
+	var err error
+	if p != nil {
+		// abandoned devices never report back, so don't wait for them
+		go func() {
+			<-ctx.Done()
+			p.Quit()
+		}()
+		_, err = p.Run()
+		// leaving the screen early (ctrl+c) abandons the devices still running
+		cancel()
+	}
+	// the workers write the records, so they must be done before returning
+	wg.Wait()
+	if err == nil {
+		err = parent.Err()
+	}
+	return err
+}
+
+func unlockOne(ctx context.Context, u *unlocker, job *unlockJob, i int, logDir string, reporter unlockReporter) *UnlockRecord {
+	job.logPath = filepath.Join(logDir, fmt.Sprintf("%s-%s.log", job.device.Serial, time.Now().Format("20060102-150405")))
+	f, err := os.Create(job.logPath)
+	if err != nil {
+		record := UnlockRecord{Result: "failed", Vendor: job.vendor.Name, Error: err.Error(), At: time.Now()}
+		reporter.done(i, record)
+		return &record
+	}
+	defer f.Close()
+	logger := log.New(f, "", log.LstdFlags)
+	logger.Printf("unlocking %s (%s %s) with fastboot %s", job.device.Serial, job.vendor.Name, job.device.Model, strings.Join(job.vendor.UnlockArgs, " "))
+	record := u.run(ctx, loggedTools{u.tools, logger}, job.device, job.vendor, job.code, func(step string) {
+		logger.Print(step)
+		reporter.step(i, step)
+	})
+	logger.Printf("result: %s %s", record.Result, record.Error)
+	reporter.done(i, record)
+	return &record
+}
+
+func unlockSummary(jobs []*unlockJob) string {
+	counts := map[string]int{}
+	var problems []string
+	interrupted := 0
+	for _, job := range jobs {
+		if job.record == nil {
+			interrupted++
+			continue
+		}
+		counts[job.record.Result]++
+		if job.record.Result == "failed" || job.record.Result == "unsupported" {
+			line := fmt.Sprintf("\t%s %s: %s", job.device.Serial, job.record.Result, job.record.Error)
+			if job.logPath != "" {
+				line += " (log: " + job.logPath + ")"
+			}
+			problems = append(problems, line)
+		}
+	}
+	s := fmt.Sprintf("\n%d devices: %d unlocked, %d failed, %d unsupported, %d declined",
+		len(jobs), counts["unlocked"], counts["failed"], counts["unsupported"], counts["declined"])
+	if interrupted > 0 {
+		s += fmt.Sprintf(", %d interrupted", interrupted)
+	}
+	s += "\n"
+
+	for _, line := range problems {
+		s += line + "\n"
+	}
+	return s
+}
+
+type bulkStepMsg struct {
+	i    int
+	step string
+}
+
+type bulkDoneMsg struct {
+	i      int
+	record UnlockRecord
+}
+
+type bulkRow struct {
+	job     *unlockJob
+	step    string
+	steps   int
+	started time.Time
+	record  *UnlockRecord
+}
+
+// bulkModel is the progress screen for a bulk run, one row per device.
+type bulkModel struct {
+	rows     []*bulkRow
+	workers  int
+	logDir   string
+	progress progress.Model
+}
+
+func newBulkModel(jobs []*unlockJob, workers int, logDir string) bulkModel {
+	m := bulkModel{workers: workers, logDir: logDir, progress: progress.New(progress.WithDefaultGradient(), progress.WithWidth(30))}
+	for _, job := range jobs {
+		m.rows = append(m.rows, &bulkRow{job: job, record: job.record, step: "queued"})
+	}
+	return m
+}
+
+func (m bulkModel) Init() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+func (m bulkModel) finished() bool {
+	for _, row := range m.rows {
+		if row.record == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (m bulkModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case bulkStepMsg:
+		row := m.rows[msg.i]
+		if row.started.IsZero() {
+			row.started = time.Now()
+		}
+		row.step = msg.step
+		row.steps++
+	case bulkDoneMsg:
+		m.rows[msg.i].record = &msg.record
+		if m.finished() {
+			return m, tea.Quit
+		}
+	case tickMsg:
+		return m, tea.Tick(time.Second, func(t time.Time) tea.Msg { return tickMsg(t) })
+	}
+	return m, nil
+}
+
+func (m bulkModel) View() string {
+	var done int
+	s := fmt.Sprintf("Unlocking %d devices, %d at a time. Logs in %s\n\n", len(m.rows), m.workers, m.logDir)
+	for _, row := range m.rows {
+		d := row.job.device
+		line := fmt.Sprintf("%-16.16s %-24.24s ", d.Serial, strings.TrimSpace(row.job.vendor.Name+" "+d.Model))
+		switch {
+		case row.record != nil:
+			done++
+			line += m.progress.ViewAs(1) + " " + row.record.Result
+			if row.record.Error != "" {
+				line += " " + renderWarning(row.record.Error)
+			}
+		default:
+			line += m.progress.ViewAs(float64(row.steps) / (unlockSteps + 1))
+			line += " " + row.step
+			if !row.started.IsZero() {
+				line += fmt.Sprintf(" (%s)", time.Since(row.started).Round(time.Second))
+			}
+		}
+		s += line + "\n"
+	}
+	return s + fmt.Sprintf("\n%d/%d done\n", done, len(m.rows))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTools unlocks phones already in fastboot mode, taking a moment each
+// so overlapping unlocks can be counted.
+type fakeTools struct {
+	mu        sync.Mutex
+	running   int
+	maxActive int
+	fail      map[string]bool
+	started   func(serial string)
+}
+
+func (f *fakeTools) Devices(ctx context.Context) ([]AndroidDevice, error) { return nil, nil }
+func (f *fakeTools) Getprop(ctx context.Context, serial, prop string) (string, error) {
+	return "", nil
+}
+func (f *fakeTools) Shell(ctx context.Context, serial string, args ...string) (string, error) {
+	return "", nil
+}
+func (f *fakeTools) RebootBootloader(ctx context.Context, serial string) error { return nil }
+
+func (f *fakeTools) Fastboot(ctx context.Context, serial string, args ...string) (string, error) {
+	f.mu.Lock()
+	f.running++
+	f.maxActive = max(f.maxActive, f.running)
+	f.mu.Unlock()
+	if f.started != nil {
+		f.started(serial)
+	}
+	time.Sleep(20 * time.Millisecond)
+	f.mu.Lock()
+	f.running--
+	f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if f.fail[serial] {
+		return "FAILED (remote: 'not allowed')", nil
+	}
+	return "OKAY", nil
+}
+
+func TestRunUnlockJobs(t *testing.T) {
+	tools := &fakeTools{fail: map[string]bool{"F1": true}}
+	u := &unlocker{tools: tools, timeout: time.Second}
+	google, _ := findVendor("google")
+	var jobs []*unlockJob
+	for i := range 6 {
+		jobs = append(jobs, &unlockJob{device: AndroidDevice{Serial: fmt.Sprintf("S%d", i), Mode: "fastboot"}, vendor: google})
+	}
+	jobs = append(jobs,
+		&unlockJob{device: AndroidDevice{Serial: "F1", Mode: "fastboot"}, vendor: google},
+		&unlockJob{device: AndroidDevice{Serial: "D1", Mode: "adb"}, vendor: google, record: &UnlockRecord{Result: "declined"}},
+		&unlockJob{device: AndroidDevice{Serial: "X1", Mode: "adb"}, record: &UnlockRecord{Result: "unsupported", Error: "unknown vendor"}},
+	)
+	logDir := t.TempDir()
+
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	err := runUnlockJobs(context.Background(), u, jobs, 2, logDir)
+	os.Stdout.Close()
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tools.maxActive != 2 {
+		t.Errorf("%d unlocks ran at once, want 2", tools.maxActive)
+	}
+	for _, job := range jobs[:7] {
+		b, err := os.ReadFile(job.logPath)
+		if err != nil {
+			t.Fatalf("%s: %v", job.device.Serial, err)
+		}
+		if log := string(b); !strings.Contains(log, "fastboot flashing unlock") || !strings.Contains(log, "result: "+job.record.Result) {
+			t.Errorf("%s log:\n%s", job.device.Serial, log)
+		}
+	}
+	if jobs[7].logPath != "" {
+		t.Error("a declined device got a log")
+	}
+
+	summary := unlockSummary(jobs)
+	if !strings.Contains(summary, "9 devices: 6 unlocked, 1 failed, 1 unsupported, 1 declined") {
+		t.Errorf("summary:\n%s", summary)
+	}
+	if !strings.Contains(summary, "F1 failed: fastboot: FAILED (remote: 'not allowed') (log: "+jobs[6].logPath+")") {
+		t.Errorf("summary doesn't point at the failed device's log:\n%s", summary)
+	}
+}
+
+func TestRunUnlockJobsInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// interrupted while S1 unlocks, after S0 is done
+	tools := &fakeTools{started: func(serial string) {
+		if serial == "S1" {
+			cancel()
+		}
+	}}
+	u := &unlocker{tools: tools, timeout: time.Second}
+	google, _ := findVendor("google")
+	var jobs []*unlockJob
+	for i := range 4 {
+		jobs = append(jobs, &unlockJob{device: AndroidDevice{Serial: fmt.Sprintf("S%d", i), Mode: "fastboot"}, vendor: google})
+	}
+	jobs = append(jobs, &unlockJob{device: AndroidDevice{Serial: "D1", Mode: "adb"}, vendor: google, record: &UnlockRecord{Result: "declined"}})
+
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	err := runUnlockJobs(ctx, u, jobs, 1, t.TempDir())
+	os.Stdout.Close()
+	os.Stdout = stdout
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+
+	if jobs[0].record == nil || jobs[0].record.Result != "unlocked" {
+		t.Errorf("S0 record = %+v, want it kept", jobs[0].record)
+	}
+	for _, job := range jobs[1:4] {
+		if job.record != nil {
+			t.Errorf("%s record = %+v, want none", job.device.Serial, job.record)
+		}
+	}
+	if jobs[4].record.Result != "declined" {
+		t.Errorf("D1 record = %+v", jobs[4].record)
+	}
+	if summary := unlockSummary(jobs); !strings.Contains(summary, "5 devices: 1 unlocked, 0 failed, 0 unsupported, 1 declined, 3 interrupted") {
+		t.Errorf("summary:\n%s", summary)
+	}
+
+	root := &Cluster{ID: "root", ChildrenPhones: []*Phone{{ID: "p0", Serial: "S0"}, {ID: "p1", Serial: "S1"}}}
+	for _, job := range jobs {
+		if job.record != nil {
+			recordUnlock(root, job.device.Serial, *job.record)
+		}
+	}
+	if p := root.ChildrenPhones[0]; p.Unlock == nil || p.Unlock.Result != "unlocked" {
+		t.Errorf("S0 unlock = %+v", p.Unlock)
+	}
+	if p := root.ChildrenPhones[1]; p.Unlock != nil {
+		t.Errorf("interrupted S1 recorded as %+v", p.Unlock)
+	}
+}
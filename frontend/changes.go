@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"time"
+)
+
+// ChangeStamp records who last changed an item and when.
+type ChangeStamp struct {
+	By string    `json:"by"`
+	At time.Time `json:"at"`
+}
+
+func (c *ChangeStamp) deepCopy() *ChangeStamp {
+	if c == nil {
+		return nil
+	}
+	newC := *c
+	return &newC
+}
+
+func (c *ChangeStamp) print(now time.Time) string {
+	if c == nil {
+		return ""
+	}
+	when := "on " + c.At.Format("2006-01-02")
+	switch d := now.Sub(c.At); {
+	case d < time.Minute:
+		when = "just now"
+	case d < time.Hour:
+		when = fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		when = fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return "changed by " + c.By + " " + when
+}
+
+// currentUser names whoever is running this process in change stamps.
+func currentUser() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	if host == "" {
+		return name
+	}
+	return name + "@" + host
+}
+
+// itemFingerprint is what an item looked like when the tree was last loaded
+// or saved, keyed by ID in a fingerprint map.
+type itemFingerprint struct {
+	parent string
+	data   string
+}
+
+// fingerprints serializes every item on its own, leaving out children,
+// derived stats and its change stamp, so saving can tell which items were
+// edited.
+func fingerprints(root *Cluster) map[string]itemFingerprint {
+	prints := map[string]itemFingerprint{}
+	var walk func(c *Cluster, parent string)
+	walk = func(c *Cluster, parent string) {
+		own := c.DeepCopy()
+		own.Name = c.plainName()
+		own.ChildrenPhones, own.ChildrenClusters, own.Changed = nil, nil, nil
+		own.Stats = Stats{} // recalculated whenever the list is drawn
+		b, _ := json.Marshal(own)
+		prints[c.ID] = itemFingerprint{parent, string(b)}
+		for _, p := range c.ChildrenPhones {
+			own := p.deepCopy()
+			own.Changed = nil
+			b, _ := json.Marshal(own)
+			prints[p.ID] = itemFingerprint{c.ID, string(b)}
+		}
+		for _, child := range c.ChildrenClusters {
+			walk(child, c.ID)
+		}
+	}
+	walk(root, "")
+	return prints
+}
+
+// changedItems compares two fingerprint maps and returns the IDs of items
+// that are new, edited or moved, plus the clusters something was removed
+// from.
+func changedItems(before, after map[string]itemFingerprint) []string {
+	var ids []string
+	for id, print := range after {
+		if old, ok := before[id]; !ok || old != print {
+			ids = append(ids, id)
+		}
+	}
+	for id, old := range before {
+		if _, ok := after[id]; !ok && old.parent != "" {
+			if _, ok := after[old.parent]; ok {
+				ids = append(ids, old.parent)
+			}
+		}
+	}
+	return ids
+}
+
+func (i *Cluster) findByID(id string) (*Cluster, *Phone) {
+	if i.ID == id {
+		return i, nil
+	}
+	for _, p := range i.ChildrenPhones {
+		if p.ID == id {
+			return nil, p
+		}
+	}
+	for _, child := range i.ChildrenClusters {
+		if c, p := child.findByID(id); c != nil || p != nil {
+			return c, p
+		}
+	}
+	return nil, nil
+}
+
+// stampChanges marks every item that changed since the fingerprints were
+// taken and returns their paths.
+func stampChanges(root *Cluster, before map[string]itemFingerprint, by string, now time.Time) []string {
+	var paths []string
+	for _, id := range changedItems(before, fingerprints(root)) {
+		stamp := &ChangeStamp{By: by, At: now}
+		switch c, p := root.findByID(id); {
+		case c != nil:
+			c.Changed = stamp
+			paths = append(paths, c.labelPath())
+		case p != nil:
+			p.Changed = stamp
+			paths = append(paths, p.labelPath())
+		}
+	}
+	return paths
+}
+
+var errConflict = errors.New("the inventory was changed by someone else")
+
+// saveAs stamps what changed since the last save with by and writes the
+// tree to the daemon, or to the config file when there's no daemon.
+func (m *model) saveAs(by string) error {
+	return m.saveTree(m.rootCluster, by)
+}
+
+// saveTree saves root and makes it the live tree. The API edits a
+// workingCopy and saves that, so a save that fails changes nothing.
+func (m *model) saveTree(root *Cluster, by string) error {
+	if m.readOnly {
+		return errReadOnly
+	}
+	paths := stampChanges(root, m.fingerprints, by, time.Now())
+	version := m.diskHash
+	if m.remote != nil {
+		version = m.remoteETag
+	}
+	if err := m.write(root, version); err != nil {
+		return err
+	}
+	if root != m.rootCluster {
+		m.replaceTree(root)
+	}
+	m.fingerprints = fingerprints(root)
+	if m.events != nil && len(paths) > 0 {
+		m.events.publish(changeEvent{By: by, Paths: paths, ETag: etag(apiConfig(root))})
+	}
+	return nil
+}
+
+// write stores root if what's stored is still at version, the daemon's
+// ETag or the config file's hash, and fails with errConflict otherwise.
+func (m *model) write(root *Cluster, version string) error {
+	if m.remote != nil {
+		etag, err := m.remote.putConfig(root.DeepCopy(), version)
+		if err != nil {
+			return err
+		}
+		m.remoteETag = etag
+		return nil
+	}
+	if hash, err := fileHash(config_path); err != nil {
+		return err
+	} else if hash != version {
+		return errConflict
+	}
+	if err := MarshalToFile(config_path, root.DeepCopy()); err != nil {
+		return err
+	}
+	hash, err := fileHash(config_path)
+	m.diskHash = hash
+	return err
+}
+
+func (m *model) save() error {
+	return m.saveAs(m.user)
+}
+
+// persist saves after an edit in the TUI and reports what went wrong in the
+// status line. A conflict with someone else's changes asks what to do,
+// unless there's nobody to ask.
+func (m *model) persist() {
+	err := m.save()
+	switch {
+	case errors.Is(err, errReadOnly):
+		// the tree follows the file instead
+	case errors.Is(err, errConflict) && m.headless:
+		if _, err := m.reloadDisk(); err != nil {
+			log.Println("Reloading config:", err)
+		}
+	case errors.Is(err, errConflict):
+		m.openConflict()
+	case err != nil:
+		m.statusString = renderWarning("Saving failed: " + err.Error())
+	}
+}
+
+// carryState copies what health checks, metrics and jobs found from the
+// items of old to the same items in root, returning which phone became
+// which.
+func carryState(old, root *Cluster) map[*Phone]*Phone {
+	renamed := map[*Phone]*Phone{}
+	old.walkPhones(func(p *Phone) {
+		if _, n := root.findByID(p.ID); n != nil {
+			n.Health, n.Metrics, n.History = p.Health, p.Metrics, p.History
+			renamed[p] = n
+		}
+	})
+	var walk func(c *Cluster)
+	walk = func(c *Cluster) {
+		if n, _ := root.findByID(c.ID); n != nil {
+			n.JobState, n.JobPercentage, n.jobDelay = c.JobState, c.JobPercentage, c.jobDelay
+			n.History, n.Progress = c.History, c.Progress
+		}
+		for _, child := range c.ChildrenClusters {
+			walk(child)
+		}
+	}
+	walk(old)
+	return renamed
+}
+
+// workingCopy is a copy of the live tree, state included, to make changes
+// on that only replace the tree once saved.
+func (m *model) workingCopy() *Cluster {
+	root := m.rootCluster.DeepCopy()
+	reconstructClusterFromJSON(root)
+	carryState(m.rootCluster, root)
+	return root
+}
+
+// replaceTree swaps in a freshly loaded tree, keeping what only lives in
+// memory (health, metrics, jobs) and the cluster being looked at.
+func (m *model) replaceTree(root *Cluster) {
+	m.alerts.rekey(carryState(m.rootCluster, root))
+
+	current := root
+	if m.currentCluster != nil {
+		if c, _ := root.findByID(m.currentCluster.ID); c != nil {
+			current = c
+		}
+	}
+	m.rootCluster = root
+	m.fingerprints = fingerprints(root)
+	m.navStack = pathFrames(current)
+	m.recreateList(current, m.list.Index())
+}
@@ -0,0 +1,754 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func cliUsage() {
+	fmt.Fprintln(os.Stderr, `usage: frontend [-c config] ls [-json] [cluster]
+       frontend [-c config] tree [-json] [cluster]
+       frontend [-c config] stats [-json] [cluster]
+       frontend [-c config] add-cluster [-desc text] [-json] parent name
+       frontend [-c config] add-phone [-desc text] [-ram 8GB] [-cpu "4 cores"] [-address host] [-check agent|ssh|k3s] [-serial s] [-json] cluster name
+       frontend [-c config] edit [-name n] [-desc text] [phone or cluster flags] [-json] item | -where query
+       frontend [-c config] mv [-json] item | -where query cluster
+       frontend [-c config] rm [-r] [-json] item | -where query
+       frontend [-c config] query [-json] [-server url] query
+       frontend job -server url [-json] cluster | -where query start|stop|restart
+       frontend [-c config] provision ...
+
+Clusters are paths like Root/Lab/ShelfA. Phones are a path, ID, serial or unique name.
+Queries select phones, e.g. 'Root/Lab ram>=6 cores>=8 #gpu !stage=ready'.`)
+}
+
+// parseInterspersed lets flags follow the positional arguments, so both
+// "ls -json Root" and "ls Root --json" work.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// loadConfig reads the config for a command, starting from an empty tree
+// when there's no file yet. A file that doesn't parse is an error rather than
+// an empty tree a save would write over it.
+func loadConfig() (*Cluster, error) {
+	root, _, err := readConfigFile(config_path)
+	if errors.Is(err, os.ErrNotExist) {
+		root = &Cluster{}
+		reconstructClusterFromJSON(root)
+		return root, nil
+	}
+	return root, err
+}
+
+// findItem resolves a cluster path first, then a phone.
+func findItem(root *Cluster, ref string) (any, error) {
+	if cluster, err := findCluster(root, ref); err == nil && ref != "" {
+		return cluster, nil
+	}
+	return findPhone(root, ref)
+}
+
+func printJSON(w io.Writer, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+type phoneJSON struct {
+	Path  string `json:"path"`
+	Stage string `json:"stage"`
+	*Phone
+}
+
+func newPhoneJSON(p *Phone) phoneJSON {
+	return phoneJSON{p.labelPath(), p.stage(), p.deepCopy()}
+}
+
+type clusterJSON struct {
+	Path           string        `json:"path"`
+	Name           string        `json:"name"`
+	Desc           string        `json:"desc,omitempty"`
+	RequiredKernel string        `json:"required_kernel,omitempty"`
+	Changed        *ChangeStamp  `json:"changed,omitempty"`
+	Clusters       []clusterJSON `json:"clusters,omitempty"`
+	Phones         []phoneJSON   `json:"phones,omitempty"`
+}
+
+// newClusterJSON describes a cluster and its direct children, recursing
+// depth levels further down.
+func newClusterJSON(c *Cluster, depth int) clusterJSON {
+	out := clusterJSON{Path: c.labelPath(), Name: c.plainName(), Desc: c.Desc, RequiredKernel: c.RequiredKernel, Changed: c.Changed}
+	if depth < 0 {
+		return out
+	}
+	for _, child := range c.ChildrenClusters {
+		out.Clusters = append(out.Clusters, newClusterJSON(child, depth-1))
+	}
+	for _, p := range c.ChildrenPhones {
+		out.Phones = append(out.Phones, newPhoneJSON(p))
+	}
+	return out
+}
+
+func itemJSON(item any) any {
+	switch v := item.(type) {
+	case *Phone:
+		return newPhoneJSON(v)
+	case *Cluster:
+		return newClusterJSON(v, -1)
+	}
+	return nil
+}
+
+func itemPath(item any) string {
+	switch v := item.(type) {
+	case *Phone:
+		return v.labelPath()
+	case *Cluster:
+		return v.labelPath()
+	}
+	return ""
+}
+
+// checkName keeps names usable in paths and unique among their siblings.
+func checkName(parent *Cluster, name string, self any) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("name can't be empty")
+	}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("name %q can't contain /", name)
+	}
+	for _, c := range parent.ChildrenClusters {
+		if c != self && c.plainName() == name {
+			return fmt.Errorf("%s already has a cluster called %q", parent.labelPath(), name)
+		}
+	}
+	for _, p := range parent.ChildrenPhones {
+		if p != self && p.Name == name {
+			return fmt.Errorf("%s already has a phone called %q", parent.labelPath(), name)
+		}
+	}
+	return nil
+}
+
+func checkPhoneFields(check, role string) error {
+	if !slices.Contains([]string{"", "agent", "ssh", "k3s"}, check) {
+		return fmt.Errorf("unknown check %q, use agent, ssh or k3s", check)
+	}
+	if !slices.Contains([]string{"", "agent", "server"}, role) {
+		return fmt.Errorf("unknown role %q, use agent or server", role)
+	}
+	return nil
+}
+
+// cliCommand runs fn against the loaded config and saves it if fn changed
+// anything, turning errors into exit statuses. What a saving command prints
+// is held back until the save went through.
+func cliCommand(save bool, fn func(root *Cluster, out io.Writer) error) int {
+	var err error
+	if save {
+		var out bytes.Buffer
+		err = updateConfig(func(root *Cluster) error {
+			out.Reset()
+			return fn(root, &out)
+		})
+		if err == nil {
+			_, err = out.WriteTo(os.Stdout)
+		}
+	} else {
+		var root *Cluster
+		if root, err = loadConfig(); err == nil {
+			err = fn(root, os.Stdout)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func optionalArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+func wantArgs(args []string, min, max int) bool {
+	if len(args) < min || len(args) > max {
+		cliUsage()
+		return false
+	}
+	return true
+}
+
+func runLs(args []string) int {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	args = parseInterspersed(fs, args)
+	if !wantArgs(args, 0, 1) {
+		return 2
+	}
+	return cliCommand(false, func(root *Cluster, out io.Writer) error {
+		cluster, err := findCluster(root, optionalArg(args))
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(out, newClusterJSON(cluster, 0))
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for _, c := range cluster.ChildrenClusters {
+			fmt.Fprintf(w, "cluster\t%s\t%d clusters, %d phones\t%s\n", c.labelPath(), len(c.ChildrenClusters), len(c.ChildrenPhones), c.Desc)
+		}
+		for _, p := range cluster.ChildrenPhones {
+			fmt.Fprintf(w, "phone\t%s\t%s\t%s\t%s\n", p.labelPath(), p.ID, p.stage(), p.Address)
+		}
+		return w.Flush()
+	})
+}
+
+func printTree(w io.Writer, c *Cluster, indent string) {
+	fmt.Fprintf(w, "%s🌐 %s\n", indent, c.plainName())
+	for _, child := range c.ChildrenClusters {
+		printTree(w, child, indent+"  ")
+	}
+	for _, p := range c.ChildrenPhones {
+		fmt.Fprintf(w, "%s  📱 %s (%s)\n", indent, p.Name, p.ID)
+	}
+}
+
+func runTree(args []string) int {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	args = parseInterspersed(fs, args)
+	if !wantArgs(args, 0, 1) {
+		return 2
+	}
+	return cliCommand(false, func(root *Cluster, out io.Writer) error {
+		cluster, err := findCluster(root, optionalArg(args))
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(out, newClusterJSON(cluster, 1<<30))
+		}
+		printTree(out, cluster, "")
+		return nil
+	})
+}
+
+type statsJSON struct {
+	Path        string         `json:"path"`
+	Clusters    int            `json:"clusters"`
+	Phones      int            `json:"phones"`
+	TotalRAMGB  float64        `json:"total_ram_gb"`
+	TotalCPU    float64        `json:"total_cpu_cores"`
+	AvgRAMGB    float64        `json:"avg_ram_gb"`
+	AvgCPU      float64        `json:"avg_cpu_cores"`
+	Stages      map[string]int `json:"stages"`
+	KernelDrift int            `json:"kernel_mismatches"`
+}
+
+func clusterStats(cluster *Cluster) statsJSON {
+	s := statsJSON{Path: cluster.labelPath(), Stages: map[string]int{}}
+	s.TotalRAMGB, s.TotalCPU, s.Phones = cluster.calculateStats()
+	s.AvgRAMGB, s.AvgCPU = cluster.Stats.AvgRAM, cluster.Stats.AvgCPU
+	var count func(c *Cluster)
+	count = func(c *Cluster) {
+		s.Clusters += len(c.ChildrenClusters)
+		for _, child := range c.ChildrenClusters {
+			count(child)
+		}
+	}
+	count(cluster)
+	cluster.walkPhones(func(p *Phone) {
+		s.Stages[p.stage()]++
+		if p.kernelMismatch() != "" {
+			s.KernelDrift++
+		}
+	})
+	return s
+}
+
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	args = parseInterspersed(fs, args)
+	if !wantArgs(args, 0, 1) {
+		return 2
+	}
+	return cliCommand(false, func(root *Cluster, out io.Writer) error {
+		cluster, err := findCluster(root, optionalArg(args))
+		if err != nil {
+			return err
+		}
+		s := clusterStats(cluster)
+		if *asJSON {
+			return printJSON(out, s)
+		}
+		fmt.Fprintf(out, "%s: %d clusters, %d phones\n", s.Path, s.Clusters, s.Phones)
+		fmt.Fprintf(out, "RAM: %.2f GB total, %.2f GB average\n", s.TotalRAMGB, s.AvgRAMGB)
+		fmt.Fprintf(out, "CPU: %.2f cores total, %.2f cores average\n", s.TotalCPU, s.AvgCPU)
+		var stages []string
+		for _, stage := range pipelineStages {
+			if s.Stages[stage] > 0 {
+				stages = append(stages, fmt.Sprintf("%s %d", stage, s.Stages[stage]))
+			}
+		}
+		if len(stages) > 0 {
+			fmt.Fprintln(out, "Stages: "+strings.Join(stages, ", "))
+		}
+		if s.KernelDrift > 0 {
+			fmt.Fprintf(out, "Kernel mismatches: %d\n", s.KernelDrift)
+		}
+		return nil
+	})
+}
+
+func runAddCluster(args []string) int {
+	fs := flag.NewFlagSet("add-cluster", flag.ExitOnError)
+	desc := fs.String("desc", "", "description")
+	asJSON := fs.Bool("json", false, "print the new cluster as JSON")
+	args = parseInterspersed(fs, args)
+	if !wantArgs(args, 2, 2) {
+		return 2
+	}
+	return cliCommand(true, func(root *Cluster, out io.Writer) error {
+		parent, err := findCluster(root, args[0])
+		if err != nil {
+			return err
+		}
+		if err := checkName(parent, args[1], nil); err != nil {
+			return err
+		}
+		cluster := &Cluster{ID: newItemID(), Name: args[1], Desc: *desc, Parent: parent}
+		parent.ChildrenClusters = append(parent.ChildrenClusters, cluster)
+		if *asJSON {
+			return printJSON(out, itemJSON(cluster))
+		}
+		fmt.Fprintln(out, cluster.labelPath())
+		return nil
+	})
+}
+
+// phoneFlags are the fields add-phone and edit can set on a phone.
+type phoneFlags struct {
+	desc, ram, cpu, cpuSpeed, address, check, serial, hostname, role, tags *string
+}
+
+func newPhoneFlags(fs *flag.FlagSet) phoneFlags {
+	return phoneFlags{
+		desc:     fs.String("desc", "", "description"),
+		ram:      fs.String("ram", "", "RAM, e.g. 8GB"),
+		cpu:      fs.String("cpu", "", "CPU, e.g. 4 cores"),
+		cpuSpeed: fs.String("cpu-speed", "", "CPU speed, e.g. 2.4GHz"),
+		address:  fs.String("address", "", "agent host[:port], or user@host for ssh"),
+		check:    fs.String("check", "", "health check: agent, ssh or k3s"),
+		serial:   fs.String("serial", "", "adb serial number"),
+		hostname: fs.String("hostname", "", "k3s node name"),
+		role:     fs.String("role", "", "k3s role: agent or server"),
+		tags:     fs.String("tags", "", "comma-separated tags, e.g. gpu,usb-c"),
+	}
+}
+
+// apply copies the flags that were given onto the phone.
+func (f phoneFlags) apply(fs *flag.FlagSet, p *Phone) {
+	fields := map[string]*string{
+		"desc": &p.Desc, "ram": &p.RAM, "cpu": &p.CPU, "cpu-speed": &p.CPUSpeed, "address": &p.Address,
+		"check": &p.Check, "serial": &p.Serial, "hostname": &p.Hostname, "role": &p.Role,
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		if field, ok := fields[fl.Name]; ok {
+			*field = fl.Value.String()
+		} else if fl.Name == "tags" {
+			p.Tags = splitTags(*f.tags)
+		}
+	})
+}
+
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func checkSerial(root *Cluster, p *Phone) error {
+	if other := root.phoneBySerial(p.Serial); other != nil && other != p {
+		return fmt.Errorf("serial %s already belongs to %s", p.Serial, other.labelPath())
+	}
+	return nil
+}
+
+func runAddPhone(args []string) int {
+	fs := flag.NewFlagSet("add-phone", flag.ExitOnError)
+	fields := newPhoneFlags(fs)
+	asJSON := fs.Bool("json", false, "print the new phone as JSON")
+	args = parseInterspersed(fs, args)
+	if !wantArgs(args, 2, 2) {
+		return 2
+	}
+	return cliCommand(true, func(root *Cluster, out io.Writer) error {
+		cluster, err := findCluster(root, args[0])
+		if err != nil {
+			return err
+		}
+		if err := checkName(cluster, args[1], nil); err != nil {
+			return err
+		}
+		phone := &Phone{ID: newItemID(), Name: args[1], ParentCluster: cluster}
+		fields.apply(fs, phone)
+		if err := checkPhoneFields(phone.Check, phone.Role); err != nil {
+			return err
+		}
+		if err := checkSerial(root, phone); err != nil {
+			return err
+		}
+		cluster.ChildrenPhones = append(cluster.ChildrenPhones, phone)
+		if *asJSON {
+			return printJSON(out, itemJSON(phone))
+		}
+		fmt.Fprintf(out, "%s\t%s\n", phone.labelPath(), phone.ID)
+		return nil
+	})
+}
+
+// cliTargets resolves the item a command acts on, or with -where every
+// phone the query selects.
+func cliTargets(root *Cluster, where string, args []string) ([]any, error) {
+	if where == "" {
+		item, err := findItem(root, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return []any{item}, nil
+	}
+	phones, err := queryPhones(root, where)
+	if err != nil {
+		return nil, err
+	}
+	var items []any
+	for _, p := range phones {
+		items = append(items, p)
+	}
+	return items, nil
+}
+
+// queryPhones runs a query against a loaded config, which has no health or
+// metrics to select by.
+func queryPhones(root *Cluster, expr string) ([]*Phone, error) {
+	q, err := parseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	if q.live() {
+		return nil, errors.New("health and metrics are only known to a running frontend, select by them with query -server")
+	}
+	phones := q.selectPhones(root, time.Now())
+	if len(phones) == 0 {
+		return nil, fmt.Errorf("no phones match %q", expr)
+	}
+	return phones, nil
+}
+
+// printItems prints what a command changed, as a list when it ran on a
+// query's phones.
+func printItems(w io.Writer, items []any, asJSON, many bool) error {
+	if asJSON && !many {
+		return printJSON(w, itemJSON(items[0]))
+	}
+	if asJSON {
+		out := []any{}
+		for _, item := range items {
+			out = append(out, itemJSON(item))
+		}
+		return printJSON(w, out)
+	}
+	for _, item := range items {
+		fmt.Fprintln(w, itemPath(item))
+	}
+	return nil
+}
+
+// itemArgs checks the positional arguments of a command that takes an item
+// unless it's given -where.
+func itemArgs(args []string, where string, rest int) bool {
+	if where != "" {
+		return wantArgs(args, rest, rest)
+	}
+	return wantArgs(args, rest+1, rest+1)
+}
+
+func runEdit(args []string) int {
+	fs := flag.NewFlagSet("edit", flag.ExitOnError)
+	name := fs.String("name", "", "new name")
+	fields := newPhoneFlags(fs)
+	requiredKernel := fs.String("required-kernel", "", "kernel version or build hash phones in the cluster must run")
+	where := fs.String("where", "", "edit every phone the query selects instead of one item")
+	asJSON := fs.Bool("json", false, "print the edited item as JSON")
+	args = parseInterspersed(fs, args)
+	if !itemArgs(args, *where, 0) {
+		return 2
+	}
+	given := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { given[fl.Name] = true })
+	delete(given, "json")
+	delete(given, "where")
+	if len(given) == 0 {
+		fmt.Fprintln(os.Stderr, "nothing to change")
+		return 2
+	}
+	if *where != "" && given["name"] {
+		fmt.Fprintln(os.Stderr, "-name renames one item, it can't be used with -where")
+		return 2
+	}
+	return cliCommand(true, func(root *Cluster, out io.Writer) error {
+		items, err := cliTargets(root, *where, args)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			switch v := item.(type) {
+			case *Phone:
+				if given["required-kernel"] {
+					return errors.New("-required-kernel is set on clusters")
+				}
+				if given["name"] {
+					if err := checkName(v.ParentCluster, *name, v); err != nil {
+						return err
+					}
+					v.Name = *name
+				}
+				fields.apply(fs, v)
+				if err := checkPhoneFields(v.Check, v.Role); err != nil {
+					return err
+				}
+				if err := checkSerial(root, v); err != nil {
+					return err
+				}
+			case *Cluster:
+				for flagName := range given {
+					if !slices.Contains([]string{"name", "desc", "required-kernel"}, flagName) {
+						return fmt.Errorf("-%s is set on phones", flagName)
+					}
+				}
+				if given["name"] {
+					if v.Parent == nil {
+						return errors.New("the root can't be renamed")
+					}
+					if err := checkName(v.Parent, *name, v); err != nil {
+						return err
+					}
+					v.Name = *name
+				}
+				if given["desc"] {
+					v.Desc = *fields.desc
+				}
+				if given["required-kernel"] {
+					v.RequiredKernel = *requiredKernel
+				}
+			}
+		}
+		return printItems(out, items, *asJSON, *where != "")
+	})
+}
+
+func runMv(args []string) int {
+	fs := flag.NewFlagSet("mv", flag.ExitOnError)
+	where := fs.String("where", "", "move every phone the query selects instead of one item")
+	asJSON := fs.Bool("json", false, "print the moved item as JSON")
+	args = parseInterspersed(fs, args)
+	if !itemArgs(args, *where, 1) {
+		return 2
+	}
+	return cliCommand(true, func(root *Cluster, out io.Writer) error {
+		items, err := cliTargets(root, *where, args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+		target, err := findCluster(root, args[len(args)-1])
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			name := ""
+			switch v := item.(type) {
+			case *Phone:
+				if *where != "" && v.ParentCluster == target {
+					continue
+				}
+				name = v.Name
+			case *Cluster:
+				name = v.plainName()
+			}
+			if err := checkName(target, name, item); err != nil {
+				return err
+			}
+			if _, err := moveInto(item, target); err != nil {
+				return err
+			}
+		}
+		return printItems(out, items, *asJSON, *where != "")
+	})
+}
+
+func runRm(args []string) int {
+	fs := flag.NewFlagSet("rm", flag.ExitOnError)
+	recursive := fs.Bool("r", false, "remove a cluster with everything in it")
+	where := fs.String("where", "", "remove every phone the query selects instead of one item")
+	asJSON := fs.Bool("json", false, "print what was removed as JSON")
+	args = parseInterspersed(fs, args)
+	if !itemArgs(args, *where, 0) {
+		return 2
+	}
+	return cliCommand(true, func(root *Cluster, out io.Writer) error {
+		items, err := cliTargets(root, *where, args)
+		if err != nil {
+			return err
+		}
+		var removed []any
+		for _, item := range items {
+			gone := itemJSON(item)
+			switch v := item.(type) {
+			case *Phone:
+				from := v.ParentCluster
+				from.ChildrenPhones = slices.DeleteFunc(from.ChildrenPhones, func(p *Phone) bool { return p == v })
+			case *Cluster:
+				if v.Parent == nil {
+					return errors.New("the root can't be removed")
+				}
+				if !*recursive && (len(v.ChildrenClusters) > 0 || len(v.ChildrenPhones) > 0) {
+					return fmt.Errorf("%s isn't empty, use -r to remove it with everything in it", v.labelPath())
+				}
+				gone = newClusterJSON(v, 1<<30)
+				from := v.Parent
+				from.ChildrenClusters = slices.DeleteFunc(from.ChildrenClusters, func(c *Cluster) bool { return c == v })
+			}
+			removed = append(removed, gone)
+			if !*asJSON {
+				fmt.Fprintln(out, "removed "+itemPath(item))
+			}
+		}
+		if !*asJSON {
+			return nil
+		}
+		if *where == "" {
+			return printJSON(out, removed[0])
+		}
+		return printJSON(out, removed)
+	})
+}
+
+func runQuery(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	server := fs.String("server", "", "ask a running serve daemon, which knows health and metrics")
+	tokenFile := fs.String("server-token-file", "", "file holding the daemon's API token")
+	args = parseInterspersed(fs, args)
+	if !wantArgs(args, 1, 1<<30) {
+		return 2
+	}
+	expr := strings.Join(args, " ")
+	var phones []phoneJSON
+	if *server != "" {
+		token, err := readAPIToken(*tokenFile)
+		if err == nil {
+			phones, err = newRemoteClient(*server, token, currentUser()).queryPhones(expr)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else if status := cliCommand(false, func(root *Cluster, out io.Writer) error {
+		matched, err := queryPhones(root, expr)
+		for _, p := range matched {
+			phones = append(phones, newPhoneJSON(p))
+		}
+		return err
+	}); status != 0 {
+		return status
+	}
+	if *asJSON {
+		if err := printJSON(os.Stdout, phones); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, p := range phones {
+		fmt.Fprintf(w, "phone\t%s\t%s\t%s\t%s\n", p.Path, p.ID, p.Stage, p.Address)
+	}
+	w.Flush()
+	return 0
+}
+
+// runJob controls jobs on a daemon, the only place they run outside a TUI.
+func runJob(args []string) int {
+	fs := flag.NewFlagSet("job", flag.ExitOnError)
+	where := fs.String("where", "", "control the jobs of the clusters holding every phone the query selects")
+	asJSON := fs.Bool("json", false, "print JSON")
+	server := fs.String("server", "", "the serve daemon running the jobs")
+	tokenFile := fs.String("server-token-file", "", "file holding the daemon's API token")
+	args = parseInterspersed(fs, args)
+	if !itemArgs(args, *where, 1) {
+		return 2
+	}
+	if *server == "" {
+		fmt.Fprintln(os.Stderr, "jobs run in a frontend, pass -server with the daemon's address")
+		return 2
+	}
+	path := ""
+	if *where == "" {
+		path = args[0]
+	}
+	token, err := readAPIToken(*tokenFile)
+	var jobs []jobJSON
+	if err == nil {
+		jobs, err = newRemoteClient(*server, token, currentUser()).controlJobs(path, *where, args[len(args)-1])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		if err := printJSON(os.Stdout, jobs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, job := range jobs {
+		fmt.Fprintf(w, "%s\t%s\t%.0f%%\n", job.Path, job.State, job.Percent)
+	}
+	w.Flush()
+	return 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func useConfig(t *testing.T, content string) {
+	t.Helper()
+	old := config_path
+	config_path = filepath.Join(t.TempDir(), "config.json")
+	t.Cleanup(func() { config_path = old })
+	if err := os.WriteFile(config_path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// captureStdout runs fn and returns what it printed.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestCorruptConfigNotOverwritten(t *testing.T) {
+	const corrupt = `{"children_phones":[{"id":"p1","Name":"p1"}`
+	useConfig(t, corrupt)
+	var status int
+	out := captureStdout(t, func() { status = runRm([]string{"Root/p1"}) })
+	if status == 0 {
+		t.Error("rm succeeded on a config that doesn't parse")
+	}
+	if out != "" {
+		t.Errorf("rm printed %q", out)
+	}
+	if b, _ := os.ReadFile(config_path); string(b) != corrupt {
+		t.Errorf("config rewritten:\n%s", b)
+	}
+	if status := runAddCluster([]string{"Root", "Lab"}); status == 0 {
+		t.Error("add-cluster succeeded on a config that doesn't parse")
+	}
+}
+
+func TestRmPrintsAfterSave(t *testing.T) {
+	useConfig(t, `{"children_phones":[{"id":"p1","Name":"p1"},{"id":"p2","Name":"p2"}]}`)
+	out := captureStdout(t, func() {
+		if status := runRm([]string{"Root/p1"}); status != 0 {
+			t.Errorf("rm exited %d", status)
+		}
+	})
+	if strings.TrimSpace(out) != "removed Root/p1" {
+		t.Errorf("rm printed %q", out)
+	}
+	root, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.ChildrenPhones) != 1 || root.ChildrenPhones[0].ID != "p2" {
+		t.Errorf("phones after rm = %+v", root.ChildrenPhones)
+	}
+
+	os.Remove(config_path)
+	if root, err := loadConfig(); err != nil || len(root.ChildrenPhones) != 0 {
+		t.Errorf("missing config = %+v, %v", root, err)
+	}
+}
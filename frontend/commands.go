@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runSubcommand handles everything after the global flags when the TUI isn't
+// wanted, returning the exit status.
+func runSubcommand(args []string) int {
+	switch args[0] {
+	case "provision":
+		return runProvision(args[1:])
+	case "ls":
+		return runLs(args[1:])
+	case "tree":
+		return runTree(args[1:])
+	case "stats":
+		return runStats(args[1:])
+	case "add-cluster":
+		return runAddCluster(args[1:])
+	case "add-phone":
+		return runAddPhone(args[1:])
+	case "edit":
+		return runEdit(args[1:])
+	case "mv":
+		return runMv(args[1:])
+	case "rm":
+		return runRm(args[1:])
+	case "query":
+		return runQuery(args[1:])
+	case "job":
+		return runJob(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	cliUsage()
+	return 2
+}
+
+// findCluster resolves a path like Root/Lab/ShelfA; empty is the root.
+func findCluster(root *Cluster, path string) (*Cluster, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return root, nil
+	}
+	parts := strings.Split(path, "/")
+	if parts[0] != root.labelPath() {
+		return nil, fmt.Errorf("%s: path must start at %s", path, root.labelPath())
+	}
+	current := root
+	for _, part := range parts[1:] {
+		var next *Cluster
+		for _, child := range current.ChildrenClusters {
+			if child.plainName() == part {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%s: no cluster %q in %s", path, part, current.labelPath())
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// findPhone resolves a phone by ID, serial, path or, when it's unique, name.
+func findPhone(root *Cluster, ref string) (*Phone, error) {
+	if ref == "" {
+		return nil, errors.New("no phone given")
+	}
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		cluster, err := findCluster(root, ref[:i])
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range cluster.ChildrenPhones {
+			if p.Name == ref[i+1:] {
+				return p, nil
+			}
+		}
+		return nil, fmt.Errorf("no phone %q in %s", ref[i+1:], cluster.labelPath())
+	}
+	var byName []*Phone
+	var found *Phone
+	root.walkPhones(func(p *Phone) {
+		if p.ID == ref || (p.Serial != "" && p.Serial == ref) {
+			found = p
+		}
+		if p.Name == ref {
+			byName = append(byName, p)
+		}
+	})
+	switch {
+	case found != nil:
+		return found, nil
+	case len(byName) == 1:
+		return byName[0], nil
+	case len(byName) > 1:
+		return nil, fmt.Errorf("%d phones are called %q, use the path", len(byName), ref)
+	}
+	return nil, fmt.Errorf("no phone %q", ref)
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/charmbracelet/bubbles/progress"
 	"os"
+	"slices"
 )
 
 func MarshalToFile(filename string, v interface{}) error {
@@ -28,10 +30,21 @@ func (f *Cluster) DeepCopy() *Cluster {
 	}
 
 	newF := &Cluster{
+		ID:    f.ID,
 		Name:  f.Name,
 		Desc:  f.Desc,
 		Stats: f.Stats,
+		Probe: f.Probe.deepCopy(),
+		K3s:   f.K3s.deepCopy(),
+
+		RequiredKernel: f.RequiredKernel,
+		Changed:        f.Changed.deepCopy(),
 	}
+	newF.AlertRules = append(newF.AlertRules, f.AlertRules...)
+	newF.AlertSinks = append(newF.AlertSinks, f.AlertSinks...)
+	newF.Taints = append(newF.Taints, f.Taints...)
+	newF.DiscoverySubnets = append(newF.DiscoverySubnets, f.DiscoverySubnets...)
+	newF.Wifi = append(newF.Wifi, f.Wifi...)
 
 	if f.ChildrenPhones != nil {
 		newF.ChildrenPhones = make([]*Phone, len(f.ChildrenPhones))
@@ -57,26 +70,34 @@ func (t *Phone) deepCopy() *Phone {
 	}
 
 	newPhone := &Phone{
-		Name:     t.Name,
-		Desc:     t.Desc,
-		RAM:      t.RAM,
-		CPU:      t.CPU,
-		CPUSpeed: t.CPUSpeed,
+		ID:         t.ID,
+		Name:       t.Name,
+		Desc:       t.Desc,
+		RAM:        t.RAM,
+		CPU:        t.CPU,
+		CPUSpeed:   t.CPUSpeed,
+		Probe:      t.Probe.deepCopy(),
+		Address:    t.Address,
+		Check:      t.Check,
+		Tags:       slices.Clone(t.Tags),
+		Hostname:   t.Hostname,
+		Role:       t.Role,
+		Node:       t.Node.deepCopy(),
+		Serial:     t.Serial,
+		Unlock:     t.Unlock.deepCopy(),
+		Enrollment: t.Enrollment.deepCopy(),
+		Pipeline:   t.Pipeline.deepCopy(),
+		Firmware:   t.Firmware.deepCopy(),
+		Changed:    t.Changed.deepCopy(),
 	}
 	return newPhone
 }
 
-func loadIntoCluster(path string) (*Cluster, error) {
-	f, err := os.ReadFile(path)
-	if errors.Is(err, os.ErrNotExist) == true {
-		os.WriteFile(path, []byte("{}"), 0644)
-	}
-	var cluster Cluster
-	_ = json.Unmarshal(f, &cluster)
-	return &cluster, nil
-}
 func reconstructClusterFromJSON(cluster *Cluster) {
 	cluster.JobState = "stopped"
+	if cluster.ID == "" {
+		cluster.ID = newItemID()
+	}
 	for _, item := range cluster.ChildrenClusters {
 		item.Parent = cluster
 		item.JobState = "stopped"
@@ -95,5 +116,14 @@ func reconstructClusterFromJSON(cluster *Cluster) {
 func reconstructPhonesFromJSON(cluster *Cluster) {
 	for _, item := range cluster.ChildrenPhones {
 		item.ParentCluster = cluster
+		if item.ID == "" {
+			item.ID = newItemID()
+		}
 	}
 }
+
+func newItemID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+)
+
+// readConfigFile loads the config along with the hash of exactly what was
+// read.
+func readConfigFile(path string) (*Cluster, string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var root Cluster
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	reconstructClusterFromJSON(&root)
+	if err := checkAlertRules(&root); err != nil {
+		return nil, "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	return &root, contentHash(b), nil
+}
+
+func changedPaths(root *Cluster, ids []string) []string {
+	var paths []string
+	for _, id := range ids {
+		switch c, p := root.findByID(id); {
+		case c != nil:
+			paths = append(paths, c.labelPath())
+		case p != nil:
+			paths = append(paths, p.labelPath())
+		}
+	}
+	return paths
+}
+
+func (i *Cluster) depth() int {
+	var n int
+	for c := i.Parent; c != nil; c = c.Parent {
+		n++
+	}
+	return n
+}
+
+// mergeTrees replays what changed in ours since base onto theirs, one item
+// at a time, and returns the paths of items both sides changed. Those keep
+// theirs' version.
+func mergeTrees(base map[string]itemFingerprint, ours, theirs *Cluster) []string {
+	ourPrints, theirPrints := fingerprints(ours), fingerprints(theirs)
+	changed := func(prints map[string]itemFingerprint, id string) bool {
+		old, wasThere := base[id]
+		now, isThere := prints[id]
+		return wasThere != isThere || old != now
+	}
+	var conflicts []string
+	// theirsWins reports whether the other side changed the item too, and
+	// records a conflict unless both made the same change
+	theirsWins := func(id, path string) bool {
+		if !changed(theirPrints, id) {
+			return false
+		}
+		if changed(ourPrints, id) {
+			o, inOurs := ourPrints[id]
+			t, inTheirs := theirPrints[id]
+			if inOurs != inTheirs || o != t {
+				conflicts = append(conflicts, path)
+			}
+		}
+		return true
+	}
+
+	var walk func(c *Cluster)
+	walk = func(c *Cluster) {
+		if changed(ourPrints, c.ID) && !theirsWins(c.ID, c.labelPath()) && !mergeCluster(theirs, c) {
+			conflicts = append(conflicts, c.labelPath())
+		}
+		for _, p := range c.ChildrenPhones {
+			if changed(ourPrints, p.ID) && !theirsWins(p.ID, p.labelPath()) && !mergePhone(theirs, p) {
+				conflicts = append(conflicts, p.labelPath())
+			}
+		}
+		for _, child := range c.ChildrenClusters {
+			walk(child)
+		}
+	}
+	walk(ours)
+
+	var gone []*Cluster
+	for id, old := range base {
+		if _, ok := ourPrints[id]; ok {
+			continue
+		}
+		c, p := theirs.findByID(id)
+		if c == nil && p == nil {
+			continue
+		}
+		path := changedPaths(theirs, []string{id})[0]
+		if theirsWins(id, path) {
+			continue
+		}
+		switch {
+		case p != nil:
+			from := p.ParentCluster
+			from.ChildrenPhones = slices.DeleteFunc(from.ChildrenPhones, func(x *Phone) bool { return x == p })
+		case old.parent != "":
+			gone = append(gone, c)
+		}
+	}
+	// innermost first, so a removed cluster's removed children are out of
+	// the way; whatever is left in it was added over there
+	sort.Slice(gone, func(i, j int) bool { return gone[i].depth() > gone[j].depth() })
+	for _, c := range gone {
+		if len(c.ChildrenClusters) > 0 || len(c.ChildrenPhones) > 0 {
+			conflicts = append(conflicts, c.labelPath())
+			continue
+		}
+		from := c.Parent
+		from.ChildrenClusters = slices.DeleteFunc(from.ChildrenClusters, func(x *Cluster) bool { return x == c })
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// mergeCluster puts our version of c's own fields in theirs, adding or
+// moving it as needed. It fails if its parent is gone over there.
+func mergeCluster(theirs *Cluster, c *Cluster) bool {
+	own := c.DeepCopy()
+	own.JobState = "stopped"
+	target, _ := theirs.findByID(c.ID)
+	var parent *Cluster
+	if c.Parent != nil {
+		if parent, _ = theirs.findByID(c.Parent.ID); parent == nil {
+			return false
+		}
+	}
+	if target == nil {
+		if parent == nil {
+			return false
+		}
+		own.ChildrenClusters, own.ChildrenPhones = nil, nil
+		own.Parent = parent
+		parent.ChildrenClusters = append(parent.ChildrenClusters, own)
+		return true
+	}
+	own.Parent, own.ChildrenClusters, own.ChildrenPhones = target.Parent, target.ChildrenClusters, target.ChildrenPhones
+	own.Progress = target.Progress
+	*target = *own
+	if parent != nil && target.Parent != parent {
+		if _, err := moveInto(target, parent); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func mergePhone(theirs *Cluster, p *Phone) bool {
+	parent, _ := theirs.findByID(p.ParentCluster.ID)
+	if parent == nil {
+		return false
+	}
+	own := p.deepCopy()
+	_, target := theirs.findByID(p.ID)
+	if target == nil {
+		own.ParentCluster = parent
+		parent.ChildrenPhones = append(parent.ChildrenPhones, own)
+		return true
+	}
+	own.ParentCluster = target.ParentCluster
+	*target = *own
+	if target.ParentCluster != parent {
+		moveInto(target, parent)
+	}
+	return true
+}
+
+// saveConflict is a save that found the stored tree changed since we loaded
+// it, waiting for the user to settle it.
+type saveConflict struct {
+	latest    *Cluster // what's stored now
+	version   string   // its file hash or ETag
+	merged    *Cluster // our changes replayed on top of latest
+	conflicts []string
+}
+
+// loadLatest fetches what's stored now, from the daemon or the file.
+func (m *model) loadLatest() (*Cluster, string, error) {
+	if m.remote != nil {
+		return m.remote.fetchConfig()
+	}
+	return readConfigFile(config_path)
+}
+
+func (m *model) openConflict() {
+	latest, version, err := m.loadLatest()
+	if err != nil {
+		m.statusString = renderWarning("Your last change wasn't saved: " + err.Error())
+		return
+	}
+	merged := latest.DeepCopy()
+	reconstructClusterFromJSON(merged)
+	m.conflict = &saveConflict{
+		latest:    latest,
+		version:   version,
+		merged:    merged,
+		conflicts: mergeTrees(m.fingerprints, m.rootCluster, merged),
+	}
+}
+
+func (m *model) setVersion(version string) {
+	if m.remote != nil {
+		m.remoteETag = version
+	} else {
+		m.diskHash = version
+	}
+}
+
+// resolveConflict carries out the choice made in the conflict prompt.
+func (m *model) resolveConflict(key string) {
+	c := m.conflict
+	var err error
+	switch key {
+	case "m":
+		if err = m.write(c.merged, c.version); err == nil {
+			m.replaceTree(c.merged)
+			m.statusString = "Merged your changes into theirs."
+		}
+	case "o":
+		if err = m.write(m.rootCluster, c.version); err == nil {
+			m.fingerprints = fingerprints(m.rootCluster)
+			m.statusString = "Overwrote their changes with yours."
+		}
+	case "r":
+		m.setVersion(c.version)
+		m.replaceTree(c.latest)
+		m.statusString = "Reloaded, your unsaved changes are gone."
+	case "esc":
+		m.statusString = renderWarning("Your changes aren't saved yet; the next save will ask again.")
+	default:
+		return
+	}
+	m.conflict = nil
+	switch {
+	case errors.Is(err, errConflict):
+		// changed again while the prompt was up
+		m.openConflict()
+	case err != nil:
+		m.statusString = renderWarning("Saving failed: " + err.Error())
+	}
+}
+
+func (m *model) conflictView() string {
+	where := config_path
+	if m.remote != nil {
+		where = "the daemon's inventory"
+	}
+	s := fmt.Sprintf("%s changed since you loaded it, your last change isn't saved.\n\n", where)
+	if len(m.conflict.conflicts) == 0 {
+		s += "None of their changes touch what you changed, merging keeps both.\n"
+	} else {
+		s += "You both changed these, merging keeps their version of them:\n\n"
+		for _, path := range m.conflict.conflicts {
+			s += "  " + renderWarning(path) + "\n"
+		}
+	}
+	return s + "\nm: merge • o: overwrite theirs with yours • r: reload theirs, dropping yours • esc: decide later"
+}
+
+// reloadDisk picks up what someone else wrote to the config file, for
+// frontends that only follow it.
+func (m *model) reloadDisk() ([]string, error) {
+	root, hash, err := readConfigFile(config_path)
+	if err != nil {
+		return nil, err
+	}
+	paths := changedPaths(root, changedItems(m.fingerprints, fingerprints(root)))
+	m.diskHash = hash
+	m.replaceTree(root)
+	if len(paths) > 0 {
+		m.events.publish(changeEvent{By: config_path, Paths: paths, ETag: etag(apiConfig(m.rootCluster))})
+	}
+	return paths, nil
+}
+
+func (m *model) diskChanged() bool {
+	hash, err := fileHash(config_path)
+	return err == nil && hash != m.diskHash
+}
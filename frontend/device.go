@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// AndroidDevice is a phone seen by adb or fastboot.
+type AndroidDevice struct {
+	Serial       string
+	Mode         string // "adb" or "fastboot"
+	Manufacturer string
+	Model        string
+}
+
+// DeviceTools is everything provisioning needs from adb and fastboot. The
+// exec implementation takes the binary paths, so a fake adb/fastboot script
+// can stand in for real hardware.
+type DeviceTools interface {
+	Devices(ctx context.Context) ([]AndroidDevice, error)
+	Getprop(ctx context.Context, serial, prop string) (string, error)
+	Shell(ctx context.Context, serial string, args ...string) (string, error)
+	RebootBootloader(ctx context.Context, serial string) error
+	Fastboot(ctx context.Context, serial string, args ...string) (string, error)
+}
+
+type execTools struct {
+	ADBPath      string
+	FastbootPath string
+}
+
+func newExecTools(adb, fastboot string) *execTools {
+	return &execTools{ADBPath: adb, FastbootPath: fastboot}
+}
+
+func run(ctx context.Context, bin string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, bin, args...).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s: %w: %s", bin, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// parseDeviceList reads "serial<TAB>state" lines as printed by both
+// `adb devices` and `fastboot devices`, keeping devices in wantState.
+func parseDeviceList(out, wantState, mode string) []AndroidDevice {
+	var devices []AndroidDevice
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[1] != wantState {
+			continue
+		}
+		devices = append(devices, AndroidDevice{Serial: fields[0], Mode: mode})
+	}
+	return devices
+}
+
+// Devices lists phones booted into Android with adb enabled, then phones
+// sitting in the bootloader.
+func (t *execTools) Devices(ctx context.Context) ([]AndroidDevice, error) {
+	out, err := run(ctx, t.ADBPath, "devices")
+	if err != nil {
+		return nil, err
+	}
+	devices := parseDeviceList(out, "device", "adb")
+	if out, err := run(ctx, t.FastbootPath, "devices"); err == nil {
+		devices = append(devices, parseDeviceList(out, "fastboot", "fastboot")...)
+	}
+	return devices, nil
+}
+
+func (t *execTools) Getprop(ctx context.Context, serial, prop string) (string, error) {
+	out, err := run(ctx, t.ADBPath, "-s", serial, "shell", "getprop", prop)
+	return strings.TrimSpace(out), err
+}
+
+func (t *execTools) Shell(ctx context.Context, serial string, args ...string) (string, error) {
+	return run(ctx, t.ADBPath, append([]string{"-s", serial, "shell"}, args...)...)
+}
+
+func (t *execTools) RebootBootloader(ctx context.Context, serial string) error {
+	_, err := run(ctx, t.ADBPath, "-s", serial, "reboot", "bootloader")
+	return err
+}
+
+func (t *execTools) Fastboot(ctx context.Context, serial string, args ...string) (string, error) {
+	return run(ctx, t.FastbootPath, append([]string{"-s", serial}, args...)...)
+}
+
+// identify fills in manufacturer and model for adb devices.
+func identify(ctx context.Context, tools DeviceTools, d *AndroidDevice) {
+	if d.Mode != "adb" {
+		return
+	}
+	d.Manufacturer, _ = tools.Getprop(ctx, d.Serial, "ro.product.manufacturer")
+	d.Model, _ = tools.Getprop(ctx, d.Serial, "ro.product.model")
+}
+
+// waitForFastboot polls until serial shows up in fastboot mode.
+func waitForFastboot(ctx context.Context, tools DeviceTools, serial string) error {
+	for {
+		devices, err := tools.Devices(ctx)
+		if err == nil {
+			for _, d := range devices {
+				if d.Serial == serial && d.Mode == "fastboot" {
+					return nil
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s did not reach fastboot: %w", serial, ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
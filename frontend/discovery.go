@@ -0,0 +1,173 @@
+package main
+
+import (
+	"ToDoIt/resources"
+	"context"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	discoveryWorkers = 64
+	discoveryTimeout = time.Second
+	maxSweepHosts    = 4096 // refuse to sweep anything bigger than a /20
+)
+
+type discoveredDevice struct {
+	Addr    string
+	Info    resources.Info
+	Adopted bool
+}
+
+func (d discoveredDevice) print() string {
+	s := fmt.Sprintf("%s  %s", d.Addr, d.Info.Hostname)
+	if d.Info.Model != "" {
+		s += " (" + d.Info.Model + ")"
+	}
+	s += fmt.Sprintf("  %d cores", d.Info.CPUCores)
+	if d.Info.CPUMaxGHz > 0 {
+		s += fmt.Sprintf(" @ %.1fGHz", d.Info.CPUMaxGHz)
+	}
+	s += fmt.Sprintf(", %.1fGB", float64(d.Info.MemoryBytes)/(1<<30))
+	if d.Adopted {
+		s += " (in inventory)"
+	}
+	return s
+}
+
+// subnetHosts lists every host address of a CIDR, without the network and
+// broadcast addresses for IPv4 subnets wider than /31.
+func subnetHosts(cidr string) ([]netip.Addr, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("discovery subnet: %w", err)
+	}
+	prefix = prefix.Masked()
+	var hosts []netip.Addr
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		hosts = append(hosts, addr)
+		if len(hosts) > maxSweepHosts {
+			return nil, fmt.Errorf("discovery subnet %s is too large", cidr)
+		}
+	}
+	if prefix.Addr().Is4() && prefix.Bits() < 31 && len(hosts) > 2 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+// sweep asks every host in the subnets for its agent info.
+func sweep(subnets []string) ([]discoveredDevice, error) {
+	var hosts []netip.Addr
+	for _, subnet := range subnets {
+		h, err := subnetHosts(subnet)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h...)
+	}
+
+	jobs := make(chan netip.Addr)
+	var mu sync.Mutex
+	var found []discoveredDevice
+	var wg sync.WaitGroup
+	for range discoveryWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range jobs {
+				ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+				info, err := agentInfo(ctx, host.String())
+				cancel()
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				found = append(found, discoveredDevice{Addr: host.String(), Info: info})
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, host := range hosts {
+		jobs <- host
+	}
+	close(jobs)
+	wg.Wait()
+	sort.Slice(found, func(i, j int) bool {
+		a, _ := netip.ParseAddr(found[i].Addr)
+		b, _ := netip.ParseAddr(found[j].Addr)
+		return a.Less(b)
+	})
+	return found, nil
+}
+
+type discoveryMsg struct {
+	devices []discoveredDevice
+	err     error
+}
+
+func discoveryCmd(subnets []string) tea.Cmd {
+	return func() tea.Msg {
+		devices, err := sweep(subnets)
+		return discoveryMsg{devices, err}
+	}
+}
+
+// markAdopted flags devices whose address or hostname is already a phone.
+func markAdopted(root *Cluster, devices []discoveredDevice) {
+	known := map[string]bool{}
+	root.walkPhones(func(p *Phone) {
+		host := p.Address
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host != "" {
+			known[host] = true
+		}
+		if p.Hostname != "" {
+			known[p.Hostname] = true
+		}
+	})
+	for i := range devices {
+		devices[i].Adopted = known[devices[i].Addr] || known[devices[i].Info.Hostname]
+	}
+}
+
+func (m *model) discoveredView() string {
+	s := fmt.Sprintf("Discovered devices (%d)\n\n", len(m.discovered))
+	if len(m.discovered) == 0 {
+		s += "No agents answered.\n"
+	}
+	for i, d := range m.discovered {
+		line := d.print()
+		if i == m.discoveredIndex {
+			line = "> " + line
+		} else {
+			line = "  " + line
+		}
+		s += line + "\n"
+	}
+	return s + "\nup/down: select • enter: adopt into " + m.currentCluster.Name + " • esc: back"
+}
+
+// adoptDevice opens the new phone form pre-filled from the device's info.
+func (m *model) adoptDevice(d discoveredDevice) {
+	m.createNewUI.creatingItem = true
+	m.createNewUI.shouldCreateCluster = false
+	m.createNewUI.status = "Adopt Phone: " + PHONE_MESSAGE
+	m.createNewUI.nameInput.SetValue(d.Info.Hostname)
+	m.createNewUI.descInput.SetValue(d.Info.Model)
+	m.createNewUI.ramInput.SetValue(fmt.Sprintf("%.0fGB", float64(d.Info.MemoryBytes)/(1<<30)))
+	m.createNewUI.cpuInput.SetValue(fmt.Sprintf("%d cores", d.Info.CPUCores))
+	if d.Info.CPUMaxGHz > 0 {
+		m.createNewUI.cpuSpeedInput.SetValue(fmt.Sprintf("%.1fGHz", d.Info.CPUMaxGHz))
+	}
+	m.createNewUI.addressInput.SetValue(d.Addr)
+	m.createNewUI.nameInput.Focus()
+	m.createNewUI.descInput.Blur()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"ToDoIt/resources"
+)
+
+func TestSubnetHosts(t *testing.T) {
+	for _, tt := range []struct {
+		cidr        string
+		n           int
+		first, last string
+		err         bool
+	}{
+		{cidr: "10.0.0.5/32", n: 1, first: "10.0.0.5", last: "10.0.0.5"},
+		// point-to-point links have no network or broadcast address
+		{cidr: "10.0.0.4/31", n: 2, first: "10.0.0.4", last: "10.0.0.5"},
+		{cidr: "10.0.0.4/30", n: 2, first: "10.0.0.5", last: "10.0.0.6"},
+		{cidr: "10.0.0.7/30", n: 2, first: "10.0.0.5", last: "10.0.0.6"},
+		{cidr: "192.168.1.0/24", n: 254, first: "192.168.1.1", last: "192.168.1.254"},
+		{cidr: "10.0.0.0/20", n: maxSweepHosts - 2, first: "10.0.0.1", last: "10.0.15.254"},
+		{cidr: "fd00::/126", n: 4, first: "fd00::", last: "fd00::3"},
+		{cidr: "10.0.0.0/19", err: true},
+		{cidr: "fd00::/64", err: true},
+		{cidr: "10.0.0.0", err: true},
+		{cidr: "10.0.0.0/33", err: true},
+	} {
+		hosts, err := subnetHosts(tt.cidr)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: %d hosts, want an error", tt.cidr, len(hosts))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.cidr, err)
+			continue
+		}
+		if len(hosts) != tt.n || hosts[0] != netip.MustParseAddr(tt.first) || hosts[len(hosts)-1] != netip.MustParseAddr(tt.last) {
+			t.Errorf("%s: %d hosts %s..%s, want %d %s..%s", tt.cidr, len(hosts), hosts[0], hosts[len(hosts)-1], tt.n, tt.first, tt.last)
+		}
+	}
+}
+
+func TestMarkAdopted(t *testing.T) {
+	root := &Cluster{ID: "root", ChildrenPhones: []*Phone{
+		{ID: "p1", Address: "10.0.0.5:9101"},
+		{ID: "p2", Address: "10.0.0.6"},
+		{ID: "p3", Address: "[fd00::7]:9101"},
+		{ID: "p4", Hostname: "pixel-8"},
+	}}
+	devices := []discoveredDevice{
+		{Addr: "10.0.0.5"},
+		{Addr: "10.0.0.6"},
+		{Addr: "fd00::7"},
+		{Addr: "10.0.0.8", Info: resources.Info{Hostname: "pixel-8"}},
+		{Addr: "10.0.0.9", Info: resources.Info{Hostname: "pixel-9"}},
+		// p4 has no address, which mustn't match agents without a hostname
+		{Addr: "10.0.0.10"},
+	}
+	markAdopted(root, devices)
+	got := ""
+	for _, d := range devices {
+		got += fmt.Sprintf("%s=%t ", d.Addr, d.Adopted)
+	}
+	want := "10.0.0.5=true 10.0.0.6=true fd00::7=true 10.0.0.8=true 10.0.0.9=false 10.0.0.10=false "
+	if got != want {
+		t.Errorf("adopted = %s, want %s", got, want)
+	}
+}
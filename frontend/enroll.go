@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultEnrollTTL = time.Hour
+
+// EnrollmentToken lets one phone fetch its k3s configs once. Only the hash
+// is stored; the token itself is shown once when it's generated.
+type EnrollmentToken struct {
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	UsedFrom  string     `json:"used_from,omitempty"`
+}
+
+func (e *EnrollmentToken) deepCopy() *EnrollmentToken {
+	if e == nil {
+		return nil
+	}
+	newE := *e
+	if e.UsedAt != nil {
+		used := *e.UsedAt
+		newE.UsedAt = &used
+	}
+	return &newE
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newEnrollmentToken replaces any previous token on the phone.
+func (t *Phone) newEnrollmentToken(now time.Time, ttl time.Duration) string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	token := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+	t.Enrollment = &EnrollmentToken{Hash: hashToken(token), CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	return token
+}
+
+func (e *EnrollmentToken) valid(now time.Time) bool {
+	return e != nil && e.UsedAt == nil && now.Before(e.ExpiresAt)
+}
+
+func (e *EnrollmentToken) matches(token string) bool {
+	return e != nil && subtle.ConstantTimeCompare([]byte(e.Hash), []byte(hashToken(token))) == 1
+}
+
+// badge is the token state for the phone row.
+func (e *EnrollmentToken) badge(now time.Time) string {
+	switch {
+	case e == nil:
+		return ""
+	case e.UsedAt != nil:
+		return "🔑 enrolled " + e.UsedAt.Format(time.DateOnly)
+	case !now.Before(e.ExpiresAt):
+		return renderWarning("🔑 token expired")
+	default:
+		return fmt.Sprintf("🔑 token valid %s", e.ExpiresAt.Sub(now).Round(time.Minute))
+	}
+}
+
+// requestToken takes the token from a bearer header or the token parameter,
+// since wget on the phones can only easily do the latter.
+func requestToken(r *http.Request) string {
+	if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(auth)
+	}
+	return r.URL.Query().Get("token")
+}
+
+// enrollingPhone finds the phone a token was issued to. Expired and used
+// tokens don't match anything.
+func (m *model) enrollingPhone(token string, now time.Time) *Phone {
+	if token == "" {
+		return nil
+	}
+	var found *Phone
+	m.rootCluster.walkPhones(func(p *Phone) {
+		if found == nil && p.Enrollment.valid(now) && p.Enrollment.matches(token) {
+			found = p
+		}
+	})
+	return found
+}
+
+// consumeEnrollment burns the token and pins the node name, so the node that
+// joins with these configs is matched to this phone on the next sync.
+func (t *Phone) consumeEnrollment(now time.Time, from string) {
+	t.Enrollment.UsedAt = &now
+	t.Enrollment.UsedFrom = from
+	if t.Hostname == "" {
+		t.Hostname = t.nodeName()
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// changeEvent is pushed to API clients whenever the tree is saved.
+type changeEvent struct {
+	By    string   `json:"by"`
+	Paths []string `json:"paths"`
+	ETag  string   `json:"etag"` // of the whole tree after the change
+}
+
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan changeEvent]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: map[chan changeEvent]bool{}}
+}
+
+func (h *eventHub) subscribe() chan changeEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan changeEvent, 16)
+	h.subs[ch] = true
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan changeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+}
+
+// publish never blocks; a client too slow to keep up misses events, and
+// reloads the tree when it notices the ETag moved on.
+func (h *eventHub) publish(e changeEvent) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// handleEvents streams change events as server-sent events.
+func (m *model) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	ch := m.events.subscribe()
+	defer m.events.unsubscribe(ch)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e := <-ch:
+			b, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", b)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// promWriter collects samples per metric so HELP/TYPE headers are written
+// once, as the exposition format requires.
+type promWriter struct {
+	order   []string
+	help    map[string]string
+	samples map[string][]string
+}
+
+func newPromWriter() *promWriter {
+	return &promWriter{help: map[string]string{}, samples: map[string][]string{}}
+}
+
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// gauge adds one sample. labels alternate name, value. NaN values are
+// dropped so phones without live data don't export zeros.
+func (w *promWriter) gauge(name, help string, value float64, labels ...string) {
+	if math.IsNaN(value) {
+		return
+	}
+	if _, ok := w.help[name]; !ok {
+		w.order = append(w.order, name)
+		w.help[name] = help
+	}
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], promEscape(labels[i+1])))
+	}
+	w.samples[name] = append(w.samples[name], fmt.Sprintf("%s{%s} %s", name, strings.Join(pairs, ","), strconv.FormatFloat(value, 'g', -1, 64)))
+}
+
+func (w *promWriter) String() string {
+	var sb strings.Builder
+	for _, name := range w.order {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s gauge\n", name, w.help[name], name)
+		for _, line := range w.samples[name] {
+			sb.WriteString(line + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// plainName drops the globe recreateList puts in front of cluster names. An
+// unnamed root is called Root, as in returnPath.
+func (i *Cluster) plainName() string {
+	if i.Parent == nil && i.Name == "" {
+		return "Root"
+	}
+	return strings.TrimSpace(strings.TrimPrefix(i.Name, "🌐"))
+}
+
+// labelPath is returnPath without decorations, e.g. "Root/Lab/Shelf A".
+func (i *Cluster) labelPath() string {
+	var parts []string
+	for current := i; current != nil; current = current.Parent {
+		parts = append(parts, current.plainName())
+	}
+	slices.Reverse(parts)
+	return strings.Join(parts, "/")
+}
+
+func (t *Phone) labelPath() string {
+	return t.ParentCluster.labelPath() + "/" + t.Name
+}
+
+func firstNumber(s string) float64 {
+	v, err := strconv.ParseFloat(ghzRe.FindString(s), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return v
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeClusterMetrics labels every series with the item's ID as well as its
+// path and name, which needn't be unique.
+func writeClusterMetrics(w *promWriter, c *Cluster) {
+	path := c.labelPath()
+	cluster := []string{"id", c.ID, "cluster", path}
+	_, _, phones := c.calculateStats()
+	w.gauge("powercluster_cluster_phones", "Number of phones in the cluster, including sub-clusters.", float64(phones), cluster...)
+	w.gauge("powercluster_cluster_avg_ram_gb", "Average declared RAM per phone in GB.", c.Stats.AvgRAM, cluster...)
+	w.gauge("powercluster_cluster_avg_cpu_cores", "Average declared CPU cores per phone.", c.Stats.AvgCPU, cluster...)
+	w.gauge("powercluster_cluster_job_progress", "Job progress between 0 and 1.", c.JobPercentage, cluster...)
+	for _, state := range []string{"stopped", "running", "failed"} {
+		w.gauge("powercluster_cluster_job_state", "Current job state, 1 for the active state.", boolGauge(c.JobState == state), append(cluster, "state", state)...)
+	}
+	counts := c.healthCounts()
+	for _, status := range []HealthStatus{HealthOnline, HealthDegraded, HealthOffline, HealthUnknown} {
+		w.gauge("powercluster_cluster_phones_by_health", "Phones in the cluster by health status.", float64(counts[status]), append(cluster, "status", string(status))...)
+	}
+
+	for _, p := range c.ChildrenPhones {
+		labels := []string{"id", p.ID, "cluster", path, "phone", p.Name}
+		w.gauge("powercluster_phone_declared_ram_gb", "Declared RAM of the phone in GB.", firstNumber(p.RAM), labels...)
+		w.gauge("powercluster_phone_declared_cpu_cores", "Declared CPU cores of the phone.", firstNumber(p.CPU), labels...)
+		w.gauge("powercluster_phone_declared_cpu_ghz", "Declared CPU speed of the phone in GHz.", firstNumber(p.CPUSpeed), labels...)
+		w.gauge("powercluster_phone_up", "1 if the last health check succeeded.", boolGauge(!p.Health.LastSeen.IsZero() && p.Health.LastErr == nil), labels...)
+		if !p.Health.LastSeen.IsZero() {
+			w.gauge("powercluster_phone_last_seen_timestamp_seconds", "Unix time the phone last answered a health check.", float64(p.Health.LastSeen.Unix()), labels...)
+		}
+		if m := p.Metrics; m != nil {
+			w.gauge("powercluster_phone_cpu_usage_percent", "CPU usage reported by the agent.", m.CPUUsage, labels...)
+			w.gauge("powercluster_phone_load1", "1 minute load average.", m.Load[0], labels...)
+			w.gauge("powercluster_phone_memory_total_bytes", "Total memory reported by the agent.", float64(m.Memory.TotalBytes), labels...)
+			w.gauge("powercluster_phone_memory_available_bytes", "Available memory reported by the agent.", float64(m.Memory.AvailableBytes), labels...)
+			if m.Battery != nil {
+				w.gauge("powercluster_phone_battery_percent", "Battery level.", float64(m.Battery.Level), labels...)
+				w.gauge("powercluster_phone_battery_charging", "1 if the battery is charging.", boolGauge(m.Battery.Charging), labels...)
+			}
+			for _, zone := range m.Thermal {
+				w.gauge("powercluster_phone_temperature_celsius", "Thermal zone temperature.", zone.TempC, append(labels, "zone", zone.Name)...)
+			}
+			for _, st := range m.Storage {
+				if st.Error != "" {
+					continue
+				}
+				w.gauge("powercluster_phone_storage_free_bytes", "Free space on a mount point.", float64(st.FreeBytes), append(labels, "mount", st.Path)...)
+			}
+		}
+	}
+	children := append([]*Cluster(nil), c.ChildrenClusters...)
+	sort.SliceStable(children, func(a, b int) bool { return children[a].Name < children[b].Name })
+	for _, child := range children {
+		writeClusterMetrics(w, child)
+	}
+}
+
+func (m *model) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	pw := newPromWriter()
+	writeClusterMetrics(pw, m.rootCluster)
+	m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, pw.String())
+}
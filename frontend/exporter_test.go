@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetricSeriesUnique(t *testing.T) {
+	// two sibling clusters and two phones with the same names
+	root := &Cluster{ChildrenClusters: []*Cluster{
+		{ID: "c1", Name: "Shelf", ChildrenPhones: []*Phone{{ID: "p1", Name: "pixel", RAM: "8GB"}}},
+		{ID: "c2", Name: "Shelf", ChildrenPhones: []*Phone{{ID: "p2", Name: "pixel", RAM: "6GB"}}},
+	}}
+	reconstructClusterFromJSON(root)
+	w := newPromWriter()
+	writeClusterMetrics(w, root)
+
+	seen := map[string]bool{}
+	for _, line := range strings.Split(w.String(), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		series := line[:strings.LastIndex(line, " ")]
+		if seen[series] {
+			t.Errorf("duplicate series %s", series)
+		}
+		seen[series] = true
+	}
+	if !seen[`powercluster_phone_declared_ram_gb{id="p2",cluster="Root/Shelf",phone="pixel"}`] {
+		t.Errorf("missing phone series by ID in:\n%s", w)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"ToDoIt/resources"
+	"context"
+	"errors"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+const inventoryInterval = 300 // ticks between firmware inventory runs
+
+// FirmwareInfo is what's installed on the phone, as last reported by its
+// agent or read over ssh.
+type FirmwareInfo struct {
+	Model         string    `json:"model,omitempty"`
+	OSImage       string    `json:"os_image,omitempty"`
+	KernelVersion string    `json:"kernel_version,omitempty"`
+	BuildHash     string    `json:"build_hash,omitempty"`
+	CollectedAt   time.Time `json:"collected_at"`
+}
+
+func (f *FirmwareInfo) deepCopy() *FirmwareInfo {
+	if f == nil {
+		return nil
+	}
+	newF := *f
+	return &newF
+}
+
+func (f *FirmwareInfo) same(o *FirmwareInfo) bool {
+	return f != nil && o != nil && f.Model == o.Model && f.OSImage == o.OSImage &&
+		f.KernelVersion == o.KernelVersion && f.BuildHash == o.BuildHash
+}
+
+func (f *FirmwareInfo) print() string {
+	s := "kernel " + f.kernel()
+	if f.OSImage != "" {
+		s += ", " + f.OSImage
+	}
+	if f.Model != "" {
+		s += ", " + f.Model
+	}
+	return s
+}
+
+// kernel is the grouping key for the fleet view.
+func (f *FirmwareInfo) kernel() string {
+	if f == nil || f.KernelVersion == "" {
+		return ""
+	}
+	if f.BuildHash == "" {
+		return f.KernelVersion
+	}
+	return f.KernelVersion + " (" + f.BuildHash + ")"
+}
+
+func firmwareFromInfo(info resources.Info, now time.Time) FirmwareInfo {
+	return FirmwareInfo{
+		Model:         info.Model,
+		OSImage:       info.OSImage,
+		KernelVersion: info.KernelVersion,
+		BuildHash:     info.KernelBuild,
+		CollectedAt:   now,
+	}
+}
+
+// sshFirmwareScript prints the same files the agent reads, separated by
+// marker lines.
+const sshFirmwareScript = `cat /proc/sys/kernel/osrelease; echo '%%'; cat /proc/version; echo '%%'; cat /etc/os-release 2>/dev/null; echo '%%'; tr -d '\0' < /proc/device-tree/model 2>/dev/null`
+
+func sshFirmware(ctx context.Context, target string, now time.Time) (FirmwareInfo, error) {
+	out, err := exec.CommandContext(ctx, "ssh", "-T", "-o", "BatchMode=yes", "-o", "ConnectTimeout=5", target, sshFirmwareScript).CombinedOutput()
+	if err != nil {
+		return FirmwareInfo{}, fmt.Errorf("ssh %s: %w: %s", target, err, strings.TrimSpace(string(out)))
+	}
+	parts := strings.Split(string(out), "%%\n")
+	if len(parts) < 4 {
+		return FirmwareInfo{}, errors.New("unexpected output from the firmware script")
+	}
+	release := strings.TrimSpace(parts[0])
+	return FirmwareInfo{
+		Model:         strings.TrimSpace(parts[3]),
+		OSImage:       resources.OSImage(parts[2]),
+		KernelVersion: release,
+		BuildHash:     resources.KernelBuildHash(release, parts[1]),
+		CollectedAt:   now,
+	}, nil
+}
+
+type firmwareMsg struct {
+	phone    *Phone
+	firmware FirmwareInfo
+	err      error
+}
+
+func firmwareCmd(p *Phone, check, addr string, now time.Time) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		if check == "ssh" {
+			fw, err := sshFirmware(ctx, addr, now)
+			return firmwareMsg{p, fw, err}
+		}
+		info, err := agentInfo(ctx, addr)
+		return firmwareMsg{p, firmwareFromInfo(info, now), err}
+	}
+}
+
+// collectFirmware asks every reachable phone what it's running. Phones that
+// only report through k3s are left to the node info.
+func (m *model) collectFirmware(now time.Time) tea.Cmd {
+	if m.simulate {
+		return nil
+	}
+	var cmds []tea.Cmd
+	m.rootCluster.walkPhones(func(p *Phone) {
+		if p.Address == "" || p.Check == "k3s" || p.Health.Status == HealthOffline {
+			return
+		}
+		cmds = append(cmds, firmwareCmd(p, p.Check, p.Address, now))
+	})
+	return tea.Batch(cmds...)
+}
+
+func (i *Cluster) requiredKernel() string {
+	for current := i; current != nil; current = current.Parent {
+		if current.RequiredKernel != "" {
+			return current.RequiredKernel
+		}
+	}
+	return ""
+}
+
+// kernelMismatch says what the phone should be running instead, or "" when
+// it's fine or we don't know yet. The requirement matches the kernel
+// version or the build hash.
+func (t *Phone) kernelMismatch() string {
+	want := t.ParentCluster.requiredKernel()
+	if want == "" || t.Firmware == nil || t.Firmware.KernelVersion == "" {
+		return ""
+	}
+	if t.Firmware.KernelVersion == want || t.Firmware.BuildHash == want {
+		return ""
+	}
+	return want
+}
+
+func (m *model) fleetView() string {
+	groups := map[string][]*Phone{}
+	m.currentCluster.walkPhones(func(p *Phone) {
+		groups[p.Firmware.kernel()] = append(groups[p.Firmware.kernel()], p)
+	})
+	kernels := make([]string, 0, len(groups))
+	for k := range groups {
+		kernels = append(kernels, k)
+	}
+	// biggest group first, phones we know nothing about last
+	slices.SortFunc(kernels, func(a, b string) int {
+		if (a == "") != (b == "") {
+			if a == "" {
+				return 1
+			}
+			return -1
+		}
+		if d := len(groups[b]) - len(groups[a]); d != 0 {
+			return d
+		}
+		return strings.Compare(a, b)
+	})
+
+	s := "Kernels under " + m.currentCluster.Name
+	if want := m.currentCluster.requiredKernel(); want != "" {
+		s += " (required: " + want + ")"
+	}
+	s += "\n"
+	for _, k := range kernels {
+		title := k
+		if title == "" {
+			title = "not collected yet"
+		}
+		s += fmt.Sprintf("\n%s — %d phones\n", title, len(groups[k]))
+		for _, p := range groups[k] {
+			line := "\t" + p.labelPath()
+			if p.Firmware != nil {
+				line += fmt.Sprintf("  %s  %s", p.Firmware.Model, p.Firmware.OSImage)
+			}
+			if want := p.kernelMismatch(); want != "" {
+				line = renderWarning(line + "  needs " + want)
+			}
+			s += line + "\n"
+		}
+	}
+	return s + "\nesc: back"
+}
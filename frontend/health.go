@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"os/exec"
+	"time"
+)
+
+const (
+	healthInterval = 5 // ticks between health checks
+	healthTimeout  = 5 * time.Second
+	slowResponse   = 2 * time.Second
+	offlineAfter   = 2 * time.Minute
+)
+
+type HealthStatus string
+
+const (
+	HealthUnknown  HealthStatus = "unknown"
+	HealthOnline   HealthStatus = "online"
+	HealthDegraded HealthStatus = "degraded"
+	HealthOffline  HealthStatus = "offline"
+)
+
+var healthColors = map[HealthStatus]lipgloss.Color{
+	HealthUnknown:  lipgloss.Color("245"),
+	HealthOnline:   lipgloss.Color("#3BFF7A"),
+	HealthDegraded: lipgloss.Color("#FFC53B"),
+	HealthOffline:  lipgloss.Color("#FF593B"),
+}
+
+type PhoneHealth struct {
+	Status   HealthStatus
+	LastSeen time.Time
+	Latency  time.Duration
+	LastErr  error
+	checking bool
+}
+
+func (h PhoneHealth) badge() string {
+	status := h.Status
+	if status == "" {
+		status = HealthUnknown
+	}
+	return lipgloss.NewStyle().Foreground(healthColors[status]).Render("● " + string(status))
+}
+
+func (h PhoneHealth) print() string {
+	s := h.badge()
+	if !h.LastSeen.IsZero() {
+		s += fmt.Sprintf(", last seen %s ago", time.Since(h.LastSeen).Round(time.Second))
+	}
+	return s
+}
+
+// record folds the result of one check into the phone's health. A failing
+// phone stays degraded until it has been unreachable for offlineAfter.
+func (h *PhoneHealth) record(now time.Time, latency time.Duration, err error) {
+	h.checking = false
+	h.LastErr = err
+	if err == nil {
+		h.LastSeen = now
+		h.Latency = latency
+		h.Status = HealthOnline
+		if latency > slowResponse {
+			h.Status = HealthDegraded
+		}
+		return
+	}
+	h.expire(now)
+	if h.Status == HealthOnline {
+		h.Status = HealthDegraded
+	}
+}
+
+func (h *PhoneHealth) expire(now time.Time) {
+	if h.Status == "" || h.Status == HealthUnknown {
+		if h.LastErr != nil {
+			h.Status = HealthOffline
+		}
+		return
+	}
+	if now.Sub(h.LastSeen) > offlineAfter {
+		h.Status = HealthOffline
+	}
+}
+
+type healthMsg struct {
+	phone   *Phone
+	at      time.Time
+	latency time.Duration
+	err     error
+}
+
+// checkPhone runs the phone's configured check. Phones use the agent ping
+// unless Check says otherwise. Tests swap it out for canned results.
+var checkPhone = func(ctx context.Context, check, addr string) error {
+	switch check {
+	case "", "agent":
+		return agentPing(ctx, addr)
+	case "ssh":
+		out, err := exec.CommandContext(ctx, "ssh", "-T", "-o", "BatchMode=yes", "-o", "ConnectTimeout=5", addr, "true").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ssh %s: %w: %s", addr, err, out)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown health check %q", check)
+	}
+}
+
+// healthCheck names the check the phone gets, agent unless set.
+func (t *Phone) healthCheck() string {
+	if t.Check == "" {
+		return "agent"
+	}
+	return t.Check
+}
+
+func healthCmd(p *Phone) tea.Cmd {
+	check, addr := p.Check, p.Address
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+		defer cancel()
+		start := time.Now()
+		err := checkPhone(ctx, check, addr)
+		return healthMsg{phone: p, at: time.Now(), latency: time.Since(start), err: err}
+	}
+}
+
+func (s *Simulator) checkPhone(p *Phone) error {
+	if s.HealthFailureRate > 0 && s.rng.Float64() < s.HealthFailureRate {
+		return errors.New("simulated timeout")
+	}
+	return nil
+}
+
+// checkHealth starts a check for every phone with an address. Under the
+// simulator all phones are checked synchronously so runs stay reproducible.
+func (m *model) checkHealth(now time.Time) tea.Cmd {
+	var cmds []tea.Cmd
+	m.rootCluster.walkPhones(func(p *Phone) {
+		if m.simulate {
+			p.Health.record(now, 0, m.sim.checkPhone(p))
+			return
+		}
+		p.Health.expire(now)
+		// k3s phones are updated by the node sync instead
+		if p.Check == "k3s" || p.Address == "" || p.Health.checking {
+			return
+		}
+		p.Health.checking = true
+		cmds = append(cmds, healthCmd(p))
+	})
+	return tea.Batch(cmds...)
+}
+
+func (i *Cluster) walkPhones(fn func(p *Phone)) {
+	for _, p := range i.ChildrenPhones {
+		fn(p)
+	}
+	for _, child := range i.ChildrenClusters {
+		child.walkPhones(fn)
+	}
+}
+
+// healthCounts rolls up phone health below the cluster.
+func (i *Cluster) healthCounts() map[HealthStatus]int {
+	counts := map[HealthStatus]int{}
+	i.walkPhones(func(p *Phone) {
+		status := p.Health.Status
+		if status == "" {
+			status = HealthUnknown
+		}
+		counts[status]++
+	})
+	return counts
+}
+
+func (i *Cluster) healthSummary() string {
+	counts := i.healthCounts()
+	var s string
+	for _, status := range []HealthStatus{HealthOnline, HealthDegraded, HealthOffline, HealthUnknown} {
+		if counts[status] == 0 {
+			continue
+		}
+		if s != "" {
+			s += "  "
+		}
+		s += lipgloss.NewStyle().Foreground(healthColors[status]).Render(fmt.Sprintf("%d %s", counts[status], status))
+	}
+	if s == "" {
+		return "no phones"
+	}
+	return s
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPhoneHealthRecord(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	down := errors.New("connection refused")
+	type check struct {
+		after   time.Duration
+		latency time.Duration
+		err     error
+		want    HealthStatus
+	}
+	for _, tt := range []struct {
+		name   string
+		checks []check
+	}{
+		{"first check fails", []check{{0, 0, down, HealthOffline}}},
+		{"online", []check{{0, 100 * time.Millisecond, nil, HealthOnline}}},
+		{"slow", []check{{0, 3 * time.Second, nil, HealthDegraded}}},
+		{"fails then recovers", []check{
+			{0, 0, nil, HealthOnline},
+			{time.Second, 0, down, HealthDegraded},
+			{time.Minute, 0, nil, HealthOnline},
+		}},
+		{"stays degraded until offlineAfter", []check{
+			{0, 0, nil, HealthOnline},
+			{time.Minute, 0, down, HealthDegraded},
+			{offlineAfter, 0, down, HealthDegraded},
+			{offlineAfter + time.Second, 0, down, HealthOffline},
+			{offlineAfter + 2*time.Second, 0, nil, HealthOnline},
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var h PhoneHealth
+			for i, c := range tt.checks {
+				h.checking = true
+				h.record(start.Add(c.after), c.latency, c.err)
+				if h.Status != c.want || h.checking || h.LastErr != c.err {
+					t.Errorf("check %d: %s, err %v, checking %v, want %s", i, h.Status, h.LastErr, h.checking, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPhoneHealthExpire(t *testing.T) {
+	seen := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var never PhoneHealth
+	never.expire(seen)
+	if never.Status != "" {
+		t.Errorf("never checked phone expired to %s", never.Status)
+	}
+	h := PhoneHealth{Status: HealthOnline, LastSeen: seen}
+	if h.expire(seen.Add(offlineAfter)); h.Status != HealthOnline {
+		t.Errorf("offline at exactly offlineAfter")
+	}
+	if h.expire(seen.Add(offlineAfter + time.Second)); h.Status != HealthOffline {
+		t.Errorf("still %s after offlineAfter", h.Status)
+	}
+}
+
+func TestHealthCmd(t *testing.T) {
+	old := checkPhone
+	t.Cleanup(func() { checkPhone = old })
+	var gotCheck, gotAddr string
+	checkPhone = func(ctx context.Context, check, addr string) error {
+		gotCheck, gotAddr = check, addr
+		return errors.New("timeout")
+	}
+	p := &Phone{Name: "a", Address: "10.0.0.5:9101", Check: "ssh"}
+	msg := healthCmd(p)().(healthMsg)
+	if msg.phone != p || msg.err == nil || gotCheck != "ssh" || gotAddr != "10.0.0.5:9101" {
+		t.Errorf("healthMsg = %+v after checking %s %s", msg, gotCheck, gotAddr)
+	}
+}
+
+func TestHealthCounts(t *testing.T) {
+	if got := (&Cluster{}).healthSummary(); got != "no phones" {
+		t.Errorf("empty summary = %q", got)
+	}
+	root := &Cluster{
+		ChildrenPhones: []*Phone{{Health: PhoneHealth{Status: HealthOnline}}, {}},
+		ChildrenClusters: []*Cluster{{ChildrenPhones: []*Phone{
+			{Health: PhoneHealth{Status: HealthOnline}},
+			{Health: PhoneHealth{Status: HealthOffline}},
+			{Health: PhoneHealth{Status: HealthUnknown}},
+		}}},
+	}
+	counts := root.healthCounts()
+	want := map[HealthStatus]int{HealthOnline: 2, HealthOffline: 1, HealthUnknown: 2}
+	if len(counts) != len(want) {
+		t.Errorf("counts = %v", counts)
+	}
+	for status, n := range want {
+		if counts[status] != n {
+			t.Errorf("%s = %d, want %d", status, counts[status], n)
+		}
+	}
+	summary := root.healthSummary()
+	for _, part := range []string{"2 online", "1 offline", "2 unknown"} {
+		if !strings.Contains(summary, part) {
+			t.Errorf("summary %q lacks %q", summary, part)
+		}
+	}
+	if strings.Contains(summary, "degraded") || strings.Index(summary, "online") > strings.Index(summary, "offline") {
+		t.Errorf("summary %q", summary)
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"ToDoIt/resources"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	historySize     = 720 // one hour at the default interval
+	metricsInterval = 5   // ticks between metric collections
+	sparklineWidth  = 16
+	sparklineWindow = 5 * time.Minute
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+var historyWindows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour}
+
+// Sample is one point of a phone's metric history. Fields that the phone
+// didn't report are NaN so they are skipped rather than drawn as zero.
+type Sample struct {
+	At      time.Time `json:"at"`
+	CPU     float64   `json:"cpu"`
+	RAM     float64   `json:"ram"`
+	TempC   float64   `json:"temp_c"`
+	Battery float64   `json:"battery"`
+}
+
+type seriesDef struct {
+	key      string
+	name     string
+	unit     string
+	min, max float64
+	value    func(Sample) float64
+}
+
+var historySeries = []seriesDef{
+	{"cpu", "CPU", "%", 0, 100, func(s Sample) float64 { return s.CPU }},
+	{"ram", "RAM", "%", 0, 100, func(s Sample) float64 { return s.RAM }},
+	{"temp", "Temp", "°C", 20, 90, func(s Sample) float64 { return s.TempC }},
+	{"battery", "Bat", "%", 0, 100, func(s Sample) float64 { return s.Battery }},
+}
+
+func sampleFromMetrics(m resources.Metrics, at time.Time) Sample {
+	s := Sample{At: at, CPU: m.CPUUsage, RAM: m.Memory.UsedPercent(), TempC: math.NaN(), Battery: math.NaN()}
+	if len(m.Thermal) > 0 {
+		s.TempC = m.MaxTempC()
+	}
+	if m.Battery != nil {
+		s.Battery = float64(m.Battery.Level)
+	}
+	return s
+}
+
+// MarshalJSON writes NaN fields as null.
+func (s Sample) MarshalJSON() ([]byte, error) {
+	opt := func(v float64) *float64 {
+		if math.IsNaN(v) {
+			return nil
+		}
+		return &v
+	}
+	return json.Marshal(struct {
+		At      time.Time `json:"at"`
+		CPU     *float64  `json:"cpu"`
+		RAM     *float64  `json:"ram"`
+		TempC   *float64  `json:"temp_c"`
+		Battery *float64  `json:"battery"`
+	}{s.At, opt(s.CPU), opt(s.RAM), opt(s.TempC), opt(s.Battery)})
+}
+
+func (s *Sample) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		At      time.Time `json:"at"`
+		CPU     *float64  `json:"cpu"`
+		RAM     *float64  `json:"ram"`
+		TempC   *float64  `json:"temp_c"`
+		Battery *float64  `json:"battery"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	val := func(v *float64) float64 {
+		if v == nil {
+			return math.NaN()
+		}
+		return *v
+	}
+	*s = Sample{At: raw.At, CPU: val(raw.CPU), RAM: val(raw.RAM), TempC: val(raw.TempC), Battery: val(raw.Battery)}
+	return nil
+}
+
+// MetricHistory is a fixed size ring of samples, oldest first.
+type MetricHistory struct {
+	samples []Sample
+	next    int
+	full    bool
+	written int // lines in the history file, to know when to trim it
+}
+
+func (h *MetricHistory) add(s Sample) {
+	if h.samples == nil {
+		h.samples = make([]Sample, historySize)
+	}
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % historySize
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+func (h *MetricHistory) all() []Sample {
+	if h == nil || h.samples == nil {
+		return nil
+	}
+	if !h.full {
+		return h.samples[:h.next]
+	}
+	return append(append([]Sample(nil), h.samples[h.next:]...), h.samples[:h.next]...)
+}
+
+func (h *MetricHistory) last() (Sample, bool) {
+	all := h.all()
+	if len(all) == 0 {
+		return Sample{}, false
+	}
+	return all[len(all)-1], true
+}
+
+func (h *MetricHistory) since(t time.Time) []Sample {
+	all := h.all()
+	for i, s := range all {
+		if !s.At.Before(t) {
+			return all[i:]
+		}
+	}
+	return nil
+}
+
+// bucket averages values into width columns, skipping NaNs. Empty columns
+// stay NaN.
+func bucket(values []float64, width int) []float64 {
+	if len(values) <= width {
+		return values
+	}
+	out := make([]float64, width)
+	for col := range out {
+		lo, hi := col*len(values)/width, (col+1)*len(values)/width
+		var sum float64
+		var n int
+		for _, v := range values[lo:hi] {
+			if !math.IsNaN(v) {
+				sum += v
+				n++
+			}
+		}
+		out[col] = math.NaN()
+		if n > 0 {
+			out[col] = sum / float64(n)
+		}
+	}
+	return out
+}
+
+func level(v, min, max float64, levels int) int {
+	l := int((v - min) / (max - min) * float64(levels))
+	if l < 0 {
+		return 0
+	}
+	if l >= levels {
+		return levels - 1
+	}
+	return l
+}
+
+func sparkline(values []float64, width int, min, max float64) string {
+	var sb strings.Builder
+	for _, v := range bucket(values, width) {
+		if math.IsNaN(v) {
+			sb.WriteRune(' ')
+			continue
+		}
+		sb.WriteRune(sparkBlocks[level(v, min, max, len(sparkBlocks))])
+	}
+	return sb.String()
+}
+
+// chart draws values as a block chart height rows tall.
+func chart(values []float64, width, height int, min, max float64) string {
+	values = bucket(values, width)
+	rows := make([]string, height)
+	levels := height * len(sparkBlocks)
+	for row := range rows {
+		var sb strings.Builder
+		floor := (height - 1 - row) * len(sparkBlocks)
+		for _, v := range values {
+			if math.IsNaN(v) {
+				sb.WriteRune(' ')
+				continue
+			}
+			l := level(v, min, max, levels) - floor
+			switch {
+			case l < 0:
+				sb.WriteRune(' ')
+			case l >= len(sparkBlocks):
+				sb.WriteRune('█')
+			default:
+				sb.WriteRune(sparkBlocks[l])
+			}
+		}
+		rows[row] = sb.String()
+	}
+	return strings.Join(rows, "\n")
+}
+
+func seriesValues(samples []Sample, def seriesDef) []float64 {
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = def.value(s)
+	}
+	return values
+}
+
+func (h *MetricHistory) sparklines(width int) string {
+	samples := h.since(time.Now().Add(-sparklineWindow))
+	if len(samples) == 0 {
+		return "\tno metrics yet"
+	}
+	var parts []string
+	for _, def := range historySeries {
+		parts = append(parts, def.name+" "+sparkline(seriesValues(samples, def), width, def.min, def.max))
+	}
+	return "\t" + strings.Join(parts, "  ")
+}
+
+func (h *MetricHistory) detail(window time.Duration, width int) string {
+	samples := h.since(time.Now().Add(-window))
+	s := fmt.Sprintf("Last %s (%d samples)\n", window, len(samples))
+	for _, def := range historySeries {
+		current := "n/a"
+		if len(samples) > 0 {
+			if v := def.value(samples[len(samples)-1]); !math.IsNaN(v) {
+				current = fmt.Sprintf("%.1f%s", v, def.unit)
+			}
+		}
+		s += fmt.Sprintf("\n%s: %s\n%s\n", def.name, current, chart(seriesValues(samples, def), width, 4, def.min, def.max))
+	}
+	return s
+}
+
+// aggregateHistory averages the latest sample of every phone below the
+// cluster into the cluster's own history.
+func (i *Cluster) aggregateHistory(now time.Time) {
+	for _, child := range i.ChildrenClusters {
+		child.aggregateHistory(now)
+	}
+	var sums [4]float64
+	var counts [4]int
+	i.walkPhones(func(p *Phone) {
+		last, ok := p.History.last()
+		if !ok || now.Sub(last.At) >= offlineAfter {
+			return
+		}
+		for k, def := range historySeries {
+			if v := def.value(last); !math.IsNaN(v) {
+				sums[k] += v
+				counts[k]++
+			}
+		}
+	})
+	avg := func(k int) float64 {
+		if counts[k] == 0 {
+			return math.NaN()
+		}
+		return sums[k] / float64(counts[k])
+	}
+	i.History.add(Sample{At: now, CPU: avg(0), RAM: avg(1), TempC: avg(2), Battery: avg(3)})
+}
+
+type metricsMsg struct {
+	phone   *Phone
+	metrics resources.Metrics
+	at      time.Time
+	err     error
+}
+
+func metricsCmd(p *Phone) tea.Cmd {
+	addr := p.Address
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+		defer cancel()
+		metrics, err := agentMetrics(ctx, addr)
+		return metricsMsg{phone: p, metrics: metrics, at: time.Now(), err: err}
+	}
+}
+
+// phoneMetrics random-walks from the phone's previous sample.
+func (s *Simulator) phoneMetrics(p *Phone, now time.Time) resources.Metrics {
+	prev, ok := p.History.last()
+	if !ok {
+		prev = Sample{CPU: 20, RAM: 40, TempC: 35, Battery: 100}
+	}
+	walk := func(v, step, min, max float64) float64 {
+		return math.Max(min, math.Min(max, v+(s.rng.Float64()*2-1)*step))
+	}
+	cpu := walk(prev.CPU, 10, 0, 100)
+	if p.ParentCluster != nil && p.ParentCluster.JobState == "running" {
+		cpu = walk(85, 10, 0, 100)
+	}
+	const total = 8 << 30
+	return resources.Metrics{
+		Hostname:    p.Name,
+		CollectedAt: now,
+		CPUUsage:    cpu,
+		Memory:      resources.Memory{TotalBytes: total, AvailableBytes: uint64(total * (1 - walk(prev.RAM, 3, 5, 95)/100))},
+		Battery:     &resources.Battery{Level: int(walk(prev.Battery, 1, 5, 100))},
+		Thermal:     []resources.ThermalZone{{Name: "cpu", TempC: walk(prev.TempC+(cpu-50)/50, 1, 25, 85)}},
+	}
+}
+
+// collectMetrics pulls metrics from every agent, or from the simulator.
+func (m *model) collectMetrics(now time.Time) tea.Cmd {
+	var cmds []tea.Cmd
+	m.rootCluster.walkPhones(func(p *Phone) {
+		if m.simulate {
+			m.recordMetrics(p, m.sim.phoneMetrics(p, now), now)
+			return
+		}
+		if p.Address == "" || (p.Check != "" && p.Check != "agent") {
+			return
+		}
+		cmds = append(cmds, metricsCmd(p))
+	})
+	m.rootCluster.aggregateHistory(now)
+	return tea.Batch(cmds...)
+}
+
+// recordMetrics stamps the sample with when it arrived rather than the
+// phone's own clock, which may be off.
+func (m *model) recordMetrics(p *Phone, metrics resources.Metrics, at time.Time) {
+	p.Metrics = &metrics
+	sample := sampleFromMetrics(metrics, at)
+	p.History.add(sample)
+	if m.historyDir != "" {
+		appendHistory(m.historyDir, p, sample)
+	}
+}
+
+// historyFile is named by ID so phones with the same name don't share one
+// and renaming keeps it.
+func historyFile(dir string, p *Phone) string {
+	return filepath.Join(dir, p.ID+".jsonl")
+}
+
+// legacyHistoryFile is where history was kept by name before.
+func legacyHistoryFile(dir string, p *Phone) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r == ' ' {
+			return '_'
+		}
+		return r
+	}, p.Name)
+	return filepath.Join(dir, name+".jsonl")
+}
+
+// appendHistory adds s to the phone's file, rewriting it with just what's
+// in memory once it holds twice that.
+func appendHistory(dir string, p *Phone, s Sample) {
+	if p.History.written >= 2*historySize {
+		writeHistory(dir, p)
+		return
+	}
+	f, err := os.OpenFile(historyFile(dir, p), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	b, _ := json.Marshal(s)
+	if _, err := f.Write(append(b, '\n')); err == nil {
+		p.History.written++
+	}
+}
+
+func writeHistory(dir string, p *Phone) error {
+	var buf []byte
+	samples := p.History.all()
+	for _, s := range samples {
+		b, _ := json.Marshal(s)
+		buf = append(append(buf, b...), '\n')
+	}
+	tmp := historyFile(dir, p) + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, historyFile(dir, p)); err != nil {
+		return err
+	}
+	p.History.written = len(samples)
+	return nil
+}
+
+// loadHistory fills every phone's history from dir, keeping the newest
+// historySize samples, and trims files that grew past that.
+func loadHistory(dir string, root *Cluster) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("history dir: %w", err)
+	}
+	names := map[string]int{}
+	root.walkPhones(func(p *Phone) { names[p.Name]++ })
+	var err error
+	root.walkPhones(func(p *Phone) {
+		path := historyFile(dir, p)
+		legacy := false
+		if _, statErr := os.Stat(path); errors.Is(statErr, os.ErrNotExist) && names[p.Name] == 1 {
+			path, legacy = legacyHistoryFile(dir, p), true
+		}
+		f, openErr := os.Open(path)
+		if openErr != nil {
+			return
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var s Sample
+			if json.Unmarshal(scanner.Bytes(), &s) == nil {
+				p.History.add(s)
+				p.History.written++
+			}
+		}
+		f.Close()
+		if legacy || p.History.written > historySize {
+			if wErr := writeHistory(dir, p); wErr != nil && err == nil {
+				err = fmt.Errorf("history dir: %w", wErr)
+			}
+		}
+		if legacy && err == nil {
+			os.Remove(path)
+		}
+	})
+	return err
+}
+
+func (m *model) detailView() string {
+	window := historyWindows[m.detailWindow]
+	width := m.list.Width() - 10
+	if width < 20 {
+		width = 20
+	}
+	var s string
+	switch v := m.detailItem.(type) {
+	case *Phone:
+		s = v.returnStatusString() + "\n" + v.Health.print() + "\n"
+		if v.Metrics != nil {
+			s += fmt.Sprintf("Load: %.2f %.2f %.2f\n", v.Metrics.Load[0], v.Metrics.Load[1], v.Metrics.Load[2])
+			for _, st := range v.Metrics.Storage {
+				if st.Error != "" {
+					s += renderWarning(fmt.Sprintf("Storage %s: %s", st.Path, st.Error)) + "\n"
+					continue
+				}
+				s += fmt.Sprintf("Storage %s: %.1f / %.1f GB free\n", st.Path, float64(st.FreeBytes)/(1<<30), float64(st.TotalBytes)/(1<<30))
+			}
+		}
+		s += v.History.detail(window, width)
+	case *Cluster:
+		s = v.Title() + "\n" + v.healthSummary() + "\n" + v.History.detail(window, width)
+	}
+	return s + "\nw/tab: change window (1m, 5m, 15m, 1h) • esc: back"
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"ToDoIt/resources"
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		n++
+	}
+	return n
+}
+
+func TestHistoryFilesByID(t *testing.T) {
+	dir := t.TempDir()
+	root := &Cluster{ChildrenClusters: []*Cluster{{Name: "A"}, {Name: "B"}}}
+	a := &Phone{ID: "a1", Name: "pixel"}
+	b := &Phone{ID: "b1", Name: "pixel"}
+	root.ChildrenClusters[0].ChildrenPhones = []*Phone{a}
+	root.ChildrenClusters[1].ChildrenPhones = []*Phone{b}
+	reconstructClusterFromJSON(root)
+	m := &model{historyDir: dir}
+
+	// the phone's clock is a day off, the sample is stamped on arrival
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	m.recordMetrics(a, resources.Metrics{CollectedAt: at.Add(-24 * time.Hour), CPUUsage: 10}, at)
+	m.recordMetrics(b, resources.Metrics{CPUUsage: 90}, at)
+	if last, _ := a.History.last(); !last.At.Equal(at) {
+		t.Errorf("sample stamped %v, want %v", last.At, at)
+	}
+
+	a.Name = "renamed"
+	fresh := root.DeepCopy()
+	reconstructClusterFromJSON(fresh)
+	if err := loadHistory(dir, fresh); err != nil {
+		t.Fatal(err)
+	}
+	_, a2 := fresh.findByID("a1")
+	_, b2 := fresh.findByID("b1")
+	if last, ok := a2.History.last(); !ok || last.CPU != 10 {
+		t.Errorf("renamed phone's history = %+v", a2.History.all())
+	}
+	if last, ok := b2.History.last(); !ok || last.CPU != 90 {
+		t.Errorf("same-named phone's history = %+v", b2.History.all())
+	}
+}
+
+func TestLegacyHistoryFileMoved(t *testing.T) {
+	dir := t.TempDir()
+	p := &Phone{ID: "s1", Name: "shelf phone"}
+	root := &Cluster{ChildrenPhones: []*Phone{p}}
+	reconstructClusterFromJSON(root)
+	os.WriteFile(filepath.Join(dir, "shelf_phone.jsonl"), []byte(`{"at":"2026-01-01T00:00:00Z","cpu":42}`+"\n"), 0644)
+	if err := loadHistory(dir, root); err != nil {
+		t.Fatal(err)
+	}
+	if last, ok := p.History.last(); !ok || last.CPU != 42 {
+		t.Errorf("history = %+v", p.History.all())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "shelf_phone.jsonl")); err == nil {
+		t.Error("old file left behind")
+	}
+	if n := countLines(t, historyFile(dir, p)); n != 1 {
+		t.Errorf("history file has %d lines, want 1", n)
+	}
+}
+
+func TestHistoryFileTrimmed(t *testing.T) {
+	dir := t.TempDir()
+	p := &Phone{ID: "p1", Name: "p1"}
+	root := &Cluster{ChildrenPhones: []*Phone{p}}
+	reconstructClusterFromJSON(root)
+	m := &model{historyDir: dir}
+	start := time.Now()
+	for i := 0; i < 2*historySize+1; i++ {
+		m.recordMetrics(p, resources.Metrics{CPUUsage: float64(i % 100)}, start.Add(time.Duration(i)*time.Second))
+	}
+	if n := countLines(t, historyFile(dir, p)); n != historySize {
+		t.Errorf("history file has %d lines, want %d", n, historySize)
+	}
+
+	// a file that grew while nothing trimmed it is cut down on load
+	f, _ := os.OpenFile(historyFile(dir, p), os.O_APPEND|os.O_WRONLY, 0644)
+	for i := 0; i < 10; i++ {
+		f.WriteString(`{"at":"2026-01-01T00:00:00Z","cpu":1}` + "\n")
+	}
+	f.Close()
+	p.History = MetricHistory{}
+	if err := loadHistory(dir, root); err != nil {
+		t.Fatal(err)
+	}
+	if n := countLines(t, historyFile(dir, p)); n != historySize {
+		t.Errorf("after loading, history file has %d lines, want %d", n, historySize)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "p1.jsonl.tmp")); err == nil {
+		t.Error("temporary file left behind")
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"ToDoIt/resources"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kubeSyncInterval = 30 // ticks between automatic node syncs
+	phoneIDLabel     = "powercluster.io/phone-id"
+)
+
+// kubeClient talks to the Kubernetes API directly; it only needs a couple of
+// endpoints, and a plain base URL makes it easy to point at a fake server.
+type kubeClient struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func newKubeClient(api, tokenFile, caFile string, insecure bool) (*kubeClient, error) {
+	k := &kubeClient{BaseURL: strings.TrimSuffix(api, "/")}
+	if tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading kube token: %w", err)
+		}
+		k.Token = strings.TrimSpace(string(b))
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading kube CA: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("kube CA file has no certificates")
+		}
+	}
+	k.HTTP = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	return k, nil
+}
+
+func (k *kubeClient) do(ctx context.Context, method, path, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, k.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if k.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.Token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("kube api: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("kube api %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out != nil {
+		return json.Unmarshal(b, out)
+	}
+	return nil
+}
+
+type kubeTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+type kubeNode struct {
+	Metadata struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		Taints []kubeTaint `json:"taints"`
+	} `json:"spec"`
+	Status struct {
+		Allocatable map[string]string `json:"allocatable"`
+		NodeInfo    struct {
+			KubeletVersion string `json:"kubeletVersion"`
+			KernelVersion  string `json:"kernelVersion"`
+			OSImage        string `json:"osImage"`
+		} `json:"nodeInfo"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+func (n kubeNode) ready() bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == "Ready" {
+			return c.Status == "True"
+		}
+	}
+	return false
+}
+
+func (n kubeNode) address(kind string) string {
+	for _, a := range n.Status.Addresses {
+		if a.Type == kind {
+			return a.Address
+		}
+	}
+	return ""
+}
+
+func (k *kubeClient) listNodes(ctx context.Context) ([]kubeNode, error) {
+	var list struct {
+		Items []kubeNode `json:"items"`
+	}
+	if err := k.do(ctx, http.MethodGet, "/api/v1/nodes", "", nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// NodeInfo is what the last sync learned about the phone's k3s node.
+type NodeInfo struct {
+	Name              string    `json:"name"`
+	KubeletVersion    string    `json:"kubelet_version,omitempty"`
+	AllocatableCPU    string    `json:"allocatable_cpu,omitempty"`
+	AllocatableMemory string    `json:"allocatable_memory,omitempty"`
+	Ready             bool      `json:"ready"`
+	SyncedAt          time.Time `json:"synced_at"`
+}
+
+func (n *NodeInfo) deepCopy() *NodeInfo {
+	if n == nil {
+		return nil
+	}
+	newN := *n
+	return &newN
+}
+
+func (n *NodeInfo) print() string {
+	if n == nil {
+		return "k3s: not joined"
+	}
+	state := "Ready"
+	if !n.Ready {
+		state = renderWarning("NotReady")
+	}
+	return fmt.Sprintf("k3s: %s %s, kubelet %s, allocatable %s / %s", n.Name, state, n.KubeletVersion, formatCPU(n.AllocatableCPU), formatQuantity(n.AllocatableMemory))
+}
+
+// parseQuantity understands the binary and decimal suffixes kubelet uses,
+// returning bytes for memory and cores for CPU.
+func parseQuantity(q string) (float64, error) {
+	suffixes := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+		{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+	}
+	if milli, ok := strings.CutSuffix(q, "m"); ok {
+		v, err := strconv.ParseFloat(milli, 64)
+		return v / 1000, err
+	}
+	for _, s := range suffixes {
+		if strings.HasSuffix(q, s.suffix) {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(q, s.suffix), 64)
+			return v * s.mult, err
+		}
+	}
+	return strconv.ParseFloat(q, 64)
+}
+
+func formatQuantity(q string) string {
+	b, err := parseQuantity(q)
+	if err != nil {
+		return q
+	}
+	return fmt.Sprintf("%.1fGB", b/(1<<30))
+}
+
+// formatCPU turns a CPU quantity, whole cores or millicores like "3800m",
+// into cores.
+func formatCPU(q string) string {
+	cores, err := parseQuantity(q)
+	if err != nil {
+		return q
+	}
+	return strconv.FormatFloat(cores, 'f', -1, 64) + " cores"
+}
+
+type syncReport struct {
+	At           time.Time
+	Matched      int
+	UnknownNodes []kubeNode
+	NeverJoined  []*Phone
+	Advanced     int // phones whose pipeline stage moved on
+	Inventoried  int // k3s-only phones whose firmware changed
+	Err          error
+}
+
+func (r *syncReport) print() string {
+	if r.Err != nil {
+		return renderWarning(fmt.Sprintf("k3s sync failed: %v", r.Err))
+	}
+	s := fmt.Sprintf("k3s sync at %s\n%d phones matched to nodes\n", r.At.Format(time.TimeOnly), r.Matched)
+	if len(r.UnknownNodes) > 0 {
+		s += renderWarning(fmt.Sprintf("%d nodes not in the inventory (i to import here):", len(r.UnknownNodes))) + "\n"
+		for _, n := range r.UnknownNodes {
+			s += "\t" + n.Metadata.Name + "\n"
+		}
+	}
+	if len(r.NeverJoined) > 0 {
+		s += renderWarning(fmt.Sprintf("%d phones never joined:", len(r.NeverJoined))) + "\n"
+		for _, p := range r.NeverJoined {
+			s += "\t" + p.Name + "\n"
+		}
+	}
+	return s
+}
+
+type nodesMsg struct {
+	nodes []kubeNode
+	err   error
+}
+
+func (k *kubeClient) syncCmd() tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		nodes, err := k.listNodes(ctx)
+		return nodesMsg{nodes, err}
+	}
+}
+
+// matchesNode decides whether a node is this phone: by the phone-id label,
+// then hostname, then name, then address.
+func (t *Phone) matchesNode(n kubeNode) bool {
+	name := n.Metadata.Name
+	switch {
+	case t.ID != "" && n.Metadata.Labels[phoneIDLabel] == t.ID:
+		return true
+	case t.Hostname != "":
+		return strings.EqualFold(t.Hostname, name)
+	case strings.EqualFold(t.Name, name):
+		return true
+	}
+	host := t.Address
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host != "" && host == n.address("InternalIP")
+}
+
+// reconcileNodes matches nodes to phones and records what it found on them.
+func reconcileNodes(root *Cluster, nodes []kubeNode, now time.Time) *syncReport {
+	report := &syncReport{At: now}
+	claimed := make([]bool, len(nodes))
+	root.walkPhones(func(p *Phone) {
+		for i, n := range nodes {
+			if claimed[i] || !p.matchesNode(n) {
+				continue
+			}
+			claimed[i] = true
+			report.Matched++
+			if p.Hostname == "" {
+				p.Hostname = n.Metadata.Name
+			}
+			p.Node = &NodeInfo{
+				Name:              n.Metadata.Name,
+				KubeletVersion:    n.Status.NodeInfo.KubeletVersion,
+				AllocatableCPU:    n.Status.Allocatable["cpu"],
+				AllocatableMemory: n.Status.Allocatable["memory"],
+				Ready:             n.ready(),
+				SyncedAt:          now,
+			}
+			if p.Check == "k3s" {
+				var err error
+				if !n.ready() {
+					err = errors.New("node not ready")
+				}
+				p.Health.record(now, 0, err)
+				// nothing else reports what these phones run
+				fw := &FirmwareInfo{
+					OSImage:       n.Status.NodeInfo.OSImage,
+					KernelVersion: n.Status.NodeInfo.KernelVersion,
+					BuildHash:     resources.KernelBuildHash(n.Status.NodeInfo.KernelVersion, ""),
+					CollectedAt:   now,
+				}
+				if p.Firmware != nil {
+					fw.Model = p.Firmware.Model
+				}
+				if !fw.same(p.Firmware) {
+					report.Inventoried++
+				}
+				p.Firmware = fw
+			}
+			stage := StageK3s
+			if n.ready() {
+				stage = StageNodeReady
+			}
+			if p.advance(stage, now) {
+				report.Advanced++
+			}
+			return
+		}
+		if p.Node == nil {
+			report.NeverJoined = append(report.NeverJoined, p)
+		} else {
+			p.Node.Ready = false
+		}
+		if p.Check == "k3s" {
+			p.Health.record(now, 0, errors.New("node missing from cluster"))
+		}
+	})
+	for i, n := range nodes {
+		if !claimed[i] {
+			report.UnknownNodes = append(report.UnknownNodes, n)
+		}
+	}
+	return report
+}
+
+// phoneFromNode builds an inventory entry for a node nobody declared.
+func phoneFromNode(n kubeNode, parent *Cluster) *Phone {
+	p := &Phone{
+		ID:            newItemID(),
+		Name:          n.Metadata.Name,
+		Desc:          "imported from k3s",
+		Hostname:      n.Metadata.Name,
+		Address:       n.address("InternalIP"),
+		ParentCluster: parent,
+		Node: &NodeInfo{
+			Name:              n.Metadata.Name,
+			KubeletVersion:    n.Status.NodeInfo.KubeletVersion,
+			AllocatableCPU:    n.Status.Allocatable["cpu"],
+			AllocatableMemory: n.Status.Allocatable["memory"],
+			Ready:             n.ready(),
+			SyncedAt:          time.Now(),
+		},
+	}
+	if _, err := parseQuantity(n.Status.Allocatable["cpu"]); err == nil {
+		p.CPU = formatCPU(n.Status.Allocatable["cpu"])
+	}
+	if b, err := parseQuantity(n.Status.Allocatable["memory"]); err == nil {
+		p.RAM = fmt.Sprintf("%.0fGB", b/(1<<30))
+	}
+	return p
+}
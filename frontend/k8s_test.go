@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeNodes = `{"items": [
+ {"metadata": {"name": "phone-a", "labels": {"powercluster.io/phone-id": "pa", "powercluster.io/stale": "x"}},
+  "status": {"allocatable": {"cpu": "3800m", "memory": "5934348Ki"},
+   "nodeInfo": {"kubeletVersion": "v1.30.2+k3s1", "kernelVersion": "6.1.0", "osImage": "postmarketOS"},
+   "conditions": [{"type": "Ready", "status": "True"}]}},
+ {"metadata": {"name": "phone-b"},
+  "status": {"allocatable": {"cpu": "8", "memory": "8Gi"},
+   "conditions": [{"type": "Ready", "status": "False"}],
+   "addresses": [{"type": "InternalIP", "address": "10.0.0.2"}]}},
+ {"metadata": {"name": "stranger"},
+  "spec": {"taints": [{"key": "node.kubernetes.io/unschedulable", "effect": "NoSchedule"}]},
+  "status": {"allocatable": {"cpu": "250m", "memory": "1Gi"}}}
+]}`
+
+// fakeKubeAPI serves fakeNodes and records node patches by node name.
+type fakeKubeAPI struct {
+	mu      sync.Mutex
+	patches map[string]map[string]any
+}
+
+func newFakeKubeAPI(t *testing.T) (*fakeKubeAPI, *kubeClient) {
+	api := &fakeKubeAPI{patches: map[string]map[string]any{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/nodes":
+			io.WriteString(w, fakeNodes)
+		case r.Method == http.MethodPatch && r.Header.Get("Content-Type") == "application/merge-patch+json":
+			var patch map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			api.mu.Lock()
+			api.patches[r.URL.Path[len("/api/v1/nodes/"):]] = patch
+			api.mu.Unlock()
+			io.WriteString(w, "{}")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return api, &kubeClient{BaseURL: srv.URL, Token: "secret", HTTP: srv.Client()}
+}
+
+func TestListNodes(t *testing.T) {
+	_, k := newFakeKubeAPI(t)
+	nodes, err := k.listNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 3 || nodes[0].Metadata.Name != "phone-a" || !nodes[0].ready() || nodes[1].ready() {
+		t.Fatalf("nodes = %+v", nodes)
+	}
+	if nodes[1].address("InternalIP") != "10.0.0.2" {
+		t.Errorf("address = %q", nodes[1].address("InternalIP"))
+	}
+
+	k.Token = "wrong"
+	if _, err := k.listNodes(context.Background()); err == nil {
+		t.Error("listed nodes with a bad token")
+	}
+}
+
+func TestReconcileNodes(t *testing.T) {
+	_, k := newFakeKubeAPI(t)
+	nodes, err := k.listNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Phone{ID: "pa", Name: "a", Check: "k3s"}
+	b := &Phone{ID: "pb", Name: "b", Address: "10.0.0.2:9101"}
+	never := &Phone{ID: "pc", Name: "c"}
+	root := &Cluster{ChildrenClusters: []*Cluster{{Name: "Lab", ChildrenPhones: []*Phone{a, b, never}}}}
+	reconstructClusterFromJSON(root)
+
+	now := time.Now()
+	report := reconcileNodes(root, nodes, now)
+	if report.Matched != 2 {
+		t.Errorf("matched %d, want 2", report.Matched)
+	}
+	if len(report.UnknownNodes) != 1 || report.UnknownNodes[0].Metadata.Name != "stranger" {
+		t.Errorf("unknown = %+v", report.UnknownNodes)
+	}
+	if len(report.NeverJoined) != 1 || report.NeverJoined[0] != never {
+		t.Errorf("never joined = %+v", report.NeverJoined)
+	}
+	if a.Node == nil || !a.Node.Ready || a.Hostname != "phone-a" || a.Firmware == nil || a.Firmware.KernelVersion != "6.1.0" {
+		t.Errorf("phone a = %+v, node %+v", a, a.Node)
+	}
+	if a.Health.LastErr != nil {
+		t.Errorf("k3s health = %v", a.Health.LastErr)
+	}
+	if b.Node == nil || b.Node.Ready || b.Node.Name != "phone-b" {
+		t.Errorf("phone b node = %+v", b.Node)
+	}
+
+	imported := phoneFromNode(report.UnknownNodes[0], root)
+	if imported.CPU != "0.25 cores" || imported.RAM != "1GB" {
+		t.Errorf("imported CPU %q, RAM %q", imported.CPU, imported.RAM)
+	}
+	if got := phoneFromNode(nodes[0], root).CPU; got != "3.8 cores" || firstNumber(got) != 3.8 {
+		t.Errorf("millicore CPU = %q", got)
+	}
+}
+
+func TestApplyLabelPlan(t *testing.T) {
+	api, k := newFakeKubeAPI(t)
+	nodes, err := k.listNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Phone{ID: "pa", Name: "a", CPU: "8 cores", RAM: "6GB"}
+	root := &Cluster{ChildrenClusters: []*Cluster{{Name: "Lab", ChildrenPhones: []*Phone{a}}}}
+	reconstructClusterFromJSON(root)
+	reconcileNodes(root, nodes, time.Now())
+
+	plans := planLabels(root, nodes, nil)
+	if len(plans) != 1 || plans[0].node != "phone-a" {
+		t.Fatalf("plans = %+v", plans)
+	}
+	if err := k.applyLabelPlan(context.Background(), plans[0]); err != nil {
+		t.Fatal(err)
+	}
+	patch := api.patches["phone-a"]
+	labels, _ := patch["metadata"].(map[string]any)["labels"].(map[string]any)
+	want := map[string]any{
+		clusterLabel:                 "Root.Lab",
+		hardwareLabel:                "8c-6gb",
+		roleLabel:                    "agent",
+		inClusterPrefix + "Root":     "true",
+		inClusterPrefix + "Root.Lab": "true",
+		"powercluster.io/stale":      nil,
+	}
+	for key, v := range want {
+		if got, ok := labels[key]; !ok || got != v {
+			t.Errorf("label %s = %v, want %v", key, got, v)
+		}
+	}
+	if _, ok := labels[phoneIDLabel]; ok {
+		t.Error("patched the phone-id label the node already has")
+	}
+	if _, ok := patch["spec"]; ok {
+		t.Error("patched taints that didn't change")
+	}
+}
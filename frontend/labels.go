@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	labelPrefix      = "powercluster.io/"
+	clusterLabel     = labelPrefix + "cluster"
+	roleLabel        = labelPrefix + "role"
+	hardwareLabel    = labelPrefix + "hardware-class"
+	inClusterPrefix  = "in." + labelPrefix // in.powercluster.io/<path>: "true" for every ancestor
+	maxLabelValueLen = 63
+)
+
+var labelUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// labelValue squeezes s into a valid label value.
+func labelValue(s string) string {
+	s = strings.Trim(labelUnsafe.ReplaceAllString(s, "_"), "._-")
+	if len(s) > maxLabelValueLen {
+		s = strings.Trim(s[:maxLabelValueLen], "._-")
+	}
+	return s
+}
+
+// labelClusterPath is the cluster path as a label value, "Root.Lab.Shelf_A".
+func (i *Cluster) labelClusterPath() string {
+	var parts []string
+	for _, part := range strings.Split(i.labelPath(), "/") {
+		parts = append(parts, labelValue(part))
+	}
+	return labelValue(strings.Join(parts, "."))
+}
+
+func (t *Phone) hardwareClass() string {
+	return labelValue(fmt.Sprintf("%gc-%ggb", firstNumber(t.CPU), firstNumber(t.RAM)))
+}
+
+func (t *Phone) role() string {
+	if t.Role == "" {
+		return "agent"
+	}
+	return t.Role
+}
+
+// desiredLabels are the labels the tree says the phone's node should carry.
+// Every ancestor gets an in.powercluster.io label so workloads can select a
+// whole sub-cluster.
+func (t *Phone) desiredLabels() map[string]string {
+	labels := map[string]string{
+		clusterLabel:  t.ParentCluster.labelClusterPath(),
+		roleLabel:     labelValue(t.role()),
+		hardwareLabel: t.hardwareClass(),
+		phoneIDLabel:  t.ID,
+	}
+	for c := t.ParentCluster; c != nil; c = c.Parent {
+		labels[inClusterPrefix+c.labelClusterPath()] = "true"
+	}
+	return labels
+}
+
+// desiredTaints collects taints from the phone's clusters, nearest first.
+func (t *Phone) desiredTaints() []kubeTaint {
+	var taints []kubeTaint
+	seen := map[string]bool{}
+	for c := t.ParentCluster; c != nil; c = c.Parent {
+		for _, taint := range c.Taints {
+			if !seen[taint.Key+taint.Effect] {
+				seen[taint.Key+taint.Effect] = true
+				taints = append(taints, taint)
+			}
+		}
+	}
+	return taints
+}
+
+func isManagedLabel(key string) bool {
+	return strings.HasPrefix(key, labelPrefix) || strings.HasPrefix(key, inClusterPrefix)
+}
+
+// Taints are only managed when their key carries our prefix, so taints set by
+// k3s or by hand are left alone.
+func isManagedTaint(t kubeTaint) bool {
+	return strings.HasPrefix(t.Key, labelPrefix)
+}
+
+type labelPlan struct {
+	phone      *Phone
+	node       string
+	setLabels  map[string]string
+	dropLabels []string
+	taints     []kubeTaint // full taint list to write, nil if unchanged
+	oldTaints  []kubeTaint
+}
+
+func (p labelPlan) empty() bool {
+	return len(p.setLabels) == 0 && len(p.dropLabels) == 0 && p.taints == nil
+}
+
+func sameTaints(a, b []kubeTaint) bool {
+	key := func(t kubeTaint) string { return t.Key + "=" + t.Value + ":" + t.Effect }
+	var ka, kb []string
+	for _, t := range a {
+		ka = append(ka, key(t))
+	}
+	for _, t := range b {
+		kb = append(kb, key(t))
+	}
+	slices.Sort(ka)
+	slices.Sort(kb)
+	return slices.Equal(ka, kb)
+}
+
+// planLabels diffs the tree against the live nodes. Only phones already
+// matched to a node are considered; only is optional and limits the plan to
+// those phones.
+func planLabels(root *Cluster, nodes []kubeNode, only map[*Phone]bool) []labelPlan {
+	byName := map[string]kubeNode{}
+	for _, n := range nodes {
+		byName[n.Metadata.Name] = n
+	}
+	var plans []labelPlan
+	root.walkPhones(func(p *Phone) {
+		if only != nil && !only[p] {
+			return
+		}
+		name := p.Hostname
+		if p.Node != nil {
+			name = p.Node.Name
+		}
+		n, ok := byName[name]
+		if !ok {
+			return
+		}
+		plan := labelPlan{phone: p, node: name, setLabels: map[string]string{}}
+		want := p.desiredLabels()
+		for k, v := range want {
+			if n.Metadata.Labels[k] != v {
+				plan.setLabels[k] = v
+			}
+		}
+		for k := range n.Metadata.Labels {
+			if _, ok := want[k]; !ok && isManagedLabel(k) {
+				plan.dropLabels = append(plan.dropLabels, k)
+			}
+		}
+		slices.Sort(plan.dropLabels)
+
+		var keep, managed []kubeTaint
+		for _, t := range n.Spec.Taints {
+			if isManagedTaint(t) {
+				managed = append(managed, t)
+			} else {
+				keep = append(keep, t)
+			}
+		}
+		if desired := p.desiredTaints(); !sameTaints(managed, desired) {
+			plan.oldTaints = managed
+			plan.taints = append(keep, desired...)
+			if plan.taints == nil {
+				plan.taints = []kubeTaint{}
+			}
+		}
+		if !plan.empty() {
+			plans = append(plans, plan)
+		}
+	})
+	return plans
+}
+
+func printLabelPlans(plans []labelPlan) string {
+	if len(plans) == 0 {
+		return "All node labels and taints match the tree.\n"
+	}
+	s := fmt.Sprintf("Label changes for %d nodes (dry run):\n", len(plans))
+	for _, plan := range plans {
+		s += fmt.Sprintf("\n%s (%s)\n", plan.node, plan.phone.Name)
+		for _, k := range slices.Sorted(maps.Keys(plan.setLabels)) {
+			s += fmt.Sprintf("\t+ %s=%s\n", k, plan.setLabels[k])
+		}
+		for _, k := range plan.dropLabels {
+			s += renderWarning(fmt.Sprintf("\t- %s", k)) + "\n"
+		}
+		if plan.taints != nil {
+			for _, t := range plan.oldTaints {
+				s += renderWarning(fmt.Sprintf("\t- taint %s=%s:%s", t.Key, t.Value, t.Effect)) + "\n"
+			}
+			for _, t := range plan.phone.desiredTaints() {
+				s += fmt.Sprintf("\t+ taint %s=%s:%s\n", t.Key, t.Value, t.Effect)
+			}
+		}
+	}
+	return s
+}
+
+func (k *kubeClient) applyLabelPlan(ctx context.Context, plan labelPlan) error {
+	labels := map[string]any{}
+	for key, v := range plan.setLabels {
+		labels[key] = v
+	}
+	for _, key := range plan.dropLabels {
+		labels[key] = nil
+	}
+	patch := map[string]any{"metadata": map[string]any{"labels": labels}}
+	if plan.taints != nil {
+		patch["spec"] = map[string]any{"taints": plan.taints}
+	}
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	return k.do(ctx, http.MethodPatch, "/api/v1/nodes/"+plan.node, "application/merge-patch+json", strings.NewReader(string(b)), nil)
+}
+
+type labelAppliedMsg struct {
+	applied int
+	err     error
+}
+
+type nodesForLabelsMsg struct {
+	nodes []kubeNode
+	only  map[*Phone]bool
+	apply bool // apply straight away instead of showing the dry run
+	err   error
+}
+
+// planLabelsCmd fetches the nodes to diff against the tree. The tree itself
+// is only read once the result is back on the UI goroutine.
+func (m *model) planLabelsCmd(only map[*Phone]bool, apply bool) tea.Cmd {
+	kube := m.kube
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		nodes, err := kube.listNodes(ctx)
+		return nodesForLabelsMsg{nodes: nodes, only: only, apply: apply, err: err}
+	}
+}
+
+func (m *model) applyLabelsCmd(plans []labelPlan) tea.Cmd {
+	kube := m.kube
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		var applied int
+		for _, plan := range plans {
+			if err := kube.applyLabelPlan(ctx, plan); err != nil {
+				return labelAppliedMsg{applied, fmt.Errorf("%s: %w", plan.node, err)}
+			}
+			applied++
+		}
+		return labelAppliedMsg{applied, nil}
+	}
+}
+
+func (m *model) labelPlanView() string {
+	return printLabelPlans(m.labelPlans) + "\ny/enter: apply • esc: cancel"
+}
+
+// moveInto reparents a phone or cluster and returns every phone that moved,
+// so their nodes can be relabeled.
+func moveInto(item any, target *Cluster) (map[*Phone]bool, error) {
+	moved := map[*Phone]bool{}
+	switch v := item.(type) {
+	case *Phone:
+		if v.ParentCluster == target {
+			return nil, fmt.Errorf("%s is already in %s", v.Name, target.Name)
+		}
+		from := v.ParentCluster
+		from.ChildrenPhones = slices.DeleteFunc(from.ChildrenPhones, func(p *Phone) bool { return p == v })
+		target.ChildrenPhones = append(target.ChildrenPhones, v)
+		v.ParentCluster = target
+		moved[v] = true
+	case *Cluster:
+		for c := target; c != nil; c = c.Parent {
+			if c == v {
+				return nil, fmt.Errorf("cannot move %s into itself", v.Name)
+			}
+		}
+		if v.Parent == nil || v.Parent == target {
+			return nil, fmt.Errorf("%s is already in %s", v.Name, target.Name)
+		}
+		from := v.Parent
+		from.ChildrenClusters = slices.DeleteFunc(from.ChildrenClusters, func(c *Cluster) bool { return c == v })
+		target.ChildrenClusters = append(target.ChildrenClusters, v)
+		v.Parent = target
+		v.walkPhones(func(p *Phone) { moved[p] = true })
+	}
+	return moved, nil
+}
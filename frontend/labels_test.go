@@ -0,0 +1,91 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func testNode(name string, labels map[string]string, taints ...kubeTaint) kubeNode {
+	var n kubeNode
+	n.Metadata.Name = name
+	n.Metadata.Labels = labels
+	n.Spec.Taints = taints
+	return n
+}
+
+func TestPlanLabels(t *testing.T) {
+	gpu := kubeTaint{Key: labelPrefix + "gpu", Value: "true", Effect: "NoSchedule"}
+	old := kubeTaint{Key: labelPrefix + "old", Effect: "NoExecute"}
+	cordon := kubeTaint{Key: "node.kubernetes.io/unschedulable", Effect: "NoSchedule"}
+
+	synced := &Phone{ID: "p1", Name: "synced", CPU: "8 cores", RAM: "6GB", Node: &NodeInfo{Name: "n1"}}
+	stale := &Phone{ID: "p2", Name: "stale", CPU: "4 cores", RAM: "4GB", Hostname: "n2"}
+	tainted := &Phone{ID: "p3", Name: "tainted", CPU: "8 cores", RAM: "8GB", Node: &NodeInfo{Name: "n3"}}
+	untaint := &Phone{ID: "p4", Name: "untaint", CPU: "8 cores", RAM: "8GB", Node: &NodeInfo{Name: "n4"}}
+	unmatched := &Phone{ID: "p5", Name: "unmatched", Node: &NodeInfo{Name: "gone"}}
+	root := &Cluster{ChildrenClusters: []*Cluster{
+		{Name: "Lab", ChildrenPhones: []*Phone{synced, stale, unmatched}},
+		{Name: "Gpu", Taints: []kubeTaint{gpu}, ChildrenPhones: []*Phone{tainted}},
+		{Name: "Plain", ChildrenPhones: []*Phone{untaint}},
+	}}
+	reconstructClusterFromJSON(root)
+
+	staleLabels := synced.desiredLabels()
+	staleLabels[clusterLabel] = "Root.Old"
+	staleLabels[phoneIDLabel] = "p2"
+	staleLabels[hardwareLabel] = "4c-4gb"
+	staleLabels[inClusterPrefix+"Root.Old"] = "true"
+	staleLabels["kubernetes.io/hostname"] = "n2"
+	nodes := []kubeNode{
+		testNode("n1", synced.desiredLabels(), cordon),
+		testNode("n2", staleLabels),
+		testNode("n3", tainted.desiredLabels(), cordon, old),
+		testNode("n4", untaint.desiredLabels(), old),
+	}
+
+	plans := planLabels(root, nodes, nil)
+	byNode := map[string]labelPlan{}
+	for _, plan := range plans {
+		byNode[plan.node] = plan
+	}
+	if len(plans) != 3 || byNode["n1"].phone != nil {
+		t.Fatalf("plans for %v, want n2, n3 and n4", slices.Sorted(maps.Keys(byNode)))
+	}
+
+	n2 := byNode["n2"]
+	if !maps.Equal(n2.setLabels, map[string]string{clusterLabel: "Root.Lab"}) {
+		t.Errorf("n2 sets %v", n2.setLabels)
+	}
+	if !slices.Equal(n2.dropLabels, []string{inClusterPrefix + "Root.Old"}) {
+		t.Errorf("n2 drops %v, unmanaged labels must stay", n2.dropLabels)
+	}
+	if n2.taints != nil {
+		t.Errorf("n2 rewrites taints %v", n2.taints)
+	}
+
+	n3 := byNode["n3"]
+	if len(n3.setLabels) != 0 || len(n3.dropLabels) != 0 {
+		t.Errorf("n3 label changes %v %v", n3.setLabels, n3.dropLabels)
+	}
+	if !slices.Equal(n3.taints, []kubeTaint{cordon, gpu}) || !slices.Equal(n3.oldTaints, []kubeTaint{old}) {
+		t.Errorf("n3 taints %v replacing %v, want the cordon kept, old dropped and gpu added", n3.taints, n3.oldTaints)
+	}
+
+	// dropping the only taint must write an empty list, not leave it be
+	n4 := byNode["n4"]
+	if n4.taints == nil || len(n4.taints) != 0 || n4.empty() {
+		t.Errorf("n4 taints = %#v", n4.taints)
+	}
+	if out := printLabelPlans([]labelPlan{n4}); !strings.Contains(out, "- taint "+labelPrefix+"old=:NoExecute") {
+		t.Errorf("dry run:\n%s", out)
+	}
+
+	if only := planLabels(root, nodes, map[*Phone]bool{stale: true}); len(only) != 1 || only[0].phone != stale {
+		t.Errorf("plans for only stale = %+v", only)
+	}
+	if got := printLabelPlans(planLabels(root, nodes[:1], nil)); !strings.Contains(got, "match the tree") {
+		t.Errorf("in sync: %s", got)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var errLocked = errors.New("locked")
+
+var errReadOnly = errors.New("opened read-only")
+
+// diskInterval is how many ticks a read-only or headless frontend waits
+// between checks for someone else saving the config.
+const diskInterval = 2
+
+// readOnlyBlocked lists the keys that edit the tree, which a read-only
+// frontend turns down.
+func readOnlyBlocked(key string) bool {
+	switch key {
+	case "n", "e", "d", "m", "i", "T", "D", "+":
+		return true
+	}
+	return false
+}
+
+// lockConfig takes an advisory lock on a file next to the config, so a
+// second frontend can't write over the first one's changes. The lock goes
+// away when the returned file is closed or the process exits.
+func lockConfig(path string) (*os.File, error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+	if err := tryLock(f); err != nil {
+		holder, _ := os.ReadFile(path + ".lock")
+		f.Close()
+		if errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("%s is %w by %s", path, errLocked, strings.TrimSpace(string(holder)))
+		}
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+	// only informational, for whoever finds it locked
+	f.Truncate(0)
+	fmt.Fprintf(f, "%s (pid %d)\n", currentUser(), os.Getpid())
+	return f, nil
+}
+
+// withConfigLock runs a command that loads, edits and saves the config
+// while holding the lock.
+func withConfigLock(fn func() error) error {
+	lock, err := lockConfig(config_path)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	return fn()
+}
+
+// updateConfig loads the config under the lock, lets fn change it and saves
+// it with what fn changed stamped. Slow commands call it once they're done
+// instead of holding the lock throughout.
+func updateConfig(fn func(root *Cluster) error) error {
+	return withConfigLock(func() error {
+		root, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		before := fingerprints(root)
+		if err := fn(root); err != nil {
+			return err
+		}
+		stampChanges(root, before, currentUser(), time.Now())
+		return MarshalToFile(config_path, root.DeepCopy())
+	})
+}
+
+// askReadOnly offers to open a locked config read-only instead of quitting.
+func askReadOnly(err error) bool {
+	fmt.Fprintf(os.Stderr, "%v\nOpen it read-only? [Y/n] ", err)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+// fileHash identifies what's in the config file, to notice someone else
+// writing it. A missing file hashes like an empty one.
+func fileHash(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	return contentHash(b), nil
+}
+
+func contentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/charmbracelet/bubbles/help"
@@ -13,10 +14,12 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"go.dalton.dog/bubbleup"
 	"io"
-	"math/rand"
+	"log"
+	"net/http"
 	"os"
-	"os/exec"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -32,9 +35,10 @@ const (
 )
 
 var config_path = "config.json"
-var last_pos int
 
-type itemDelegate struct{}
+type itemDelegate struct {
+	showPaths bool // the list holds phones from all over the tree
+}
 
 func (d itemDelegate) Height() int { return 6 }
 
@@ -49,7 +53,11 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 
 		p := s.JobPercentage
 		progressStr := fmt.Sprintf("%s of job", s.Progress.ViewAs(p))
-		str := fmt.Sprintf("%s \n %s \n %s \n %s", s.Title(), s.Description(), s.Stats.print(), progressStr)
+		if s.JobState == "failed" {
+			progressStr += " " + renderWarning("(failed)")
+		}
+		desc := strings.TrimSpace(s.Description() + "  " + s.Changed.print(time.Now()))
+		str := fmt.Sprintf("%s \n %s \n %s \n %s \n%s", s.Title(), desc, s.Stats.print(), progressStr, s.History.sparklines(sparklineWidth))
 		fn := lipgloss.NewStyle().PaddingLeft(4).Render
 		if index == m.Index() {
 			fn = func(s ...string) string {
@@ -64,7 +72,10 @@ func (d itemDelegate) Render(w io.Writer, m list.Model, index int, listItem list
 		return
 	case *Phone:
 		s := item
-		str := s.returnStatusString()
+		str := s.returnStatusString() + "\n" + s.History.sparklines(sparklineWidth)
+		if d.showPaths {
+			str = strings.Replace(str, "\n", "  in "+s.ParentCluster.labelPath()+"\n", 1)
+		}
 		fn := lipgloss.NewStyle().PaddingLeft(4).Render
 		if index == m.Index() {
 			fn = func(s ...string) string {
@@ -86,22 +97,76 @@ type CreateNewUI struct {
 	ramInput            textinput.Model
 	cpuInput            textinput.Model
 	cpuSpeedInput       textinput.Model
+	addressInput        textinput.Model
+	serial              string // set when attaching a phone found on USB
 	shouldCreateCluster bool
 	creatingItem        bool
 	edit                bool
 }
 type model struct {
-	list           list.Model
-	statusString   string
-	currentCluster *Cluster
-	alert          bubbleup.AlertModel
-	rootCluster    *Cluster
-	createNewUI    *CreateNewUI
-	itemsToDelete  []list.Item
-	deletionMode   bool
-	sortMode       bool
-	help           help.Model
-	showHelp       bool
+	list            list.Model
+	statusString    string
+	currentCluster  *Cluster
+	alert           bubbleup.AlertModel
+	rootCluster     *Cluster
+	createNewUI     *CreateNewUI
+	itemsToDelete   []list.Item
+	deletionMode    bool
+	sortMode        bool
+	help            help.Model
+	showHelp        bool
+	sim             *Simulator
+	simulate        bool
+	ticks           int
+	historyDir      string
+	detailItem      list.Item
+	detailWindow    int
+	alerts          *alertEngine
+	showAlerts      bool
+	alertIndex      int
+	mu              sync.Mutex // guards the tree against the HTTP handlers
+	kube            *kubeClient
+	lastSync        *syncReport
+	syncRequested   bool
+	labelPlans      []labelPlan
+	showLabelPlan   bool
+	moving          list.Item
+	discovered      []discoveredDevice
+	showDiscovered  bool
+	discoveredIndex int
+	bootstrapLog    *log.Logger
+	enrollTTL       time.Duration
+	showPipeline    bool
+	pipelineIndex   int
+	usbRoot         string
+	usbSeen         map[string]USBDevice
+	usbPending      []USBDevice
+	showFleet       bool
+	user            string // who changes are stamped with
+	fingerprints    map[string]itemFingerprint
+	remote          *remoteClient
+	remoteETag      string
+	remotePending   bool // the daemon's tree changed while we were busy
+	events          *eventHub
+	headless        bool
+	readOnly        bool   // someone else holds the config lock
+	diskHash        string // of the config file as last loaded or saved
+	conflict        *saveConflict
+	queryInput      textinput.Model
+	queryEditing    bool
+	queryErr        string
+	query           *phoneQuery // selects the listed phones instead of the current cluster
+	searchInput     textinput.Model
+	searching       bool
+	searchResults   []searchResult
+	searchIndex     int
+	navStack        []navFrame // the clusters above the current one
+	crumbFocus      bool
+	crumbIndex      int
+	gotoInput       textinput.Model
+	gotoEditing     bool
+	gotoErr         string
+	gotoMatches     []string
 }
 
 type tickMsg time.Time
@@ -117,14 +182,300 @@ func (m *model) Init() tea.Cmd {
 }
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	var alertCmd tea.Cmd
 	switch msg := msg.(type) {
 	case tickMsg:
+		var healthCmds, metricsCmds, alertCmds, syncCmd, usbCmd, firmwareCmds tea.Cmd
+		m.ticks++
 		if m.rootCluster != nil {
-			m.rootCluster.updateJobPercentages()
+			m.rootCluster.updateJobPercentages(m.sim)
+			alertCmds = m.evaluateAlerts(time.Time(msg))
+			if m.ticks%healthInterval == 0 {
+				healthCmds = m.checkHealth(time.Time(msg))
+			}
+			if m.ticks%metricsInterval == 0 {
+				metricsCmds = m.collectMetrics(time.Time(msg))
+			}
+			// offset so the first run comes right after the first health check
+			if m.ticks%inventoryInterval == healthInterval+1 {
+				firmwareCmds = m.collectFirmware(time.Time(msg))
+			}
+			if m.kube != nil && m.ticks%kubeSyncInterval == 0 {
+				syncCmd = m.kube.syncCmd()
+			}
+		}
+		if m.remotePending && !m.busy() {
+			m.reloadRemote()
+		}
+		if m.query != nil && m.query.live() && m.ticks%metricsInterval == 0 && !m.busy() && m.list.FilterState() == list.Unfiltered {
+			m.recreateList(m.currentCluster, m.list.Index())
+		}
+		// frontends that don't save follow whoever does
+		if (m.readOnly || m.headless) && m.remote == nil && m.ticks%diskInterval == 0 && !m.busy() && m.diskChanged() {
+			paths, err := m.reloadDisk()
+			if err == nil && len(paths) > 0 && !m.headless {
+				alertCmd = m.alert.NewAlertCmd(bubbleup.InfoKey, fmt.Sprintf("%s changed %s", config_path, strings.Join(paths, ", ")))
+			}
+		}
+		if m.usbRoot != "" && m.ticks%usbInterval == 0 {
+			usbCmd = usbScanCmd(m.usbRoot)
+		}
+		m.updateTitle()
+		return m, tea.Batch(periodicTicker(), healthCmds, metricsCmds, alertCmds, syncCmd, usbCmd, firmwareCmds, alertCmd)
+	case usbMsg:
+		if msg.err != nil {
+			// no sysfs here, stop looking
+			m.usbRoot = ""
+			return m, nil
+		}
+		for _, d := range m.usbHotplug(msg.devices) {
+			if p := m.rootCluster.phoneBySerial(d.Serial); p != nil {
+				alertCmd = m.alert.NewAlertCmd(bubbleup.InfoKey, fmt.Sprintf("%s connected over USB (%s)", p.Name, d.Mode))
+				continue
+			}
+			if !slices.ContainsFunc(m.usbPending, func(p USBDevice) bool { return p.key() == d.key() }) {
+				m.usbPending = append(m.usbPending, d)
+			}
+		}
+		return m, alertCmd
+	case remoteChangeMsg:
+		if msg.ETag == m.remoteETag {
+			// our own save coming back
+			return m, nil
+		}
+		if m.busy() {
+			m.remotePending = true
+			return m, nil
+		}
+		m.reloadRemote()
+		alertCmd = m.alert.NewAlertCmd(bubbleup.InfoKey, fmt.Sprintf("%s changed %s", msg.By, strings.Join(msg.Paths, ", ")))
+		return m, alertCmd
+	case remoteStatusMsg:
+		if msg.err != nil {
+			alertCmd = m.alert.NewAlertCmd(bubbleup.WarnKey, "Lost the daemon's change feed: "+msg.err.Error())
+			return m, alertCmd
+		}
+		// catch up on anything missed while disconnected
+		if m.busy() {
+			m.remotePending = true
+		} else {
+			m.reloadRemote()
 		}
-		return m, periodicTicker()
+		return m, nil
+	case healthMsg:
+		msg.phone.Health.record(msg.at, msg.latency, msg.err)
+		// answering over the network means the phone booted and joined Wi-Fi
+		if !m.simulate && msg.err == nil && msg.phone.Check != "k3s" && msg.phone.advance(StageWifi, msg.at) {
+			m.persist()
+		}
+		m.updateTitle()
+		return m, nil
+	case nodesMsg:
+		if msg.err != nil {
+			m.lastSync = &syncReport{At: time.Now(), Err: msg.err}
+		} else {
+			m.lastSync = reconcileNodes(m.rootCluster, msg.nodes, time.Now())
+		}
+		if m.syncRequested || m.lastSync.Advanced > 0 || m.lastSync.Inventoried > 0 {
+			if m.syncRequested {
+				m.statusString = m.lastSync.print()
+			}
+			m.syncRequested = false
+			if msg.err == nil {
+				m.persist()
+			}
+		}
+		m.updateTitle()
+		return m, nil
+	case nodesForLabelsMsg:
+		if msg.err != nil {
+			alertCmd = m.alert.NewAlertCmd(bubbleup.ErrorKey, msg.err.Error())
+			return m, alertCmd
+		}
+		plans := planLabels(m.rootCluster, msg.nodes, msg.only)
+		if msg.apply {
+			if len(plans) == 0 {
+				return m, nil
+			}
+			m.statusString = fmt.Sprintf("Relabeling %d nodes...", len(plans))
+			return m, m.applyLabelsCmd(plans)
+		}
+		m.labelPlans = plans
+		m.showLabelPlan = true
+		return m, nil
+	case labelAppliedMsg:
+		m.statusString = fmt.Sprintf("Updated labels on %d nodes", msg.applied)
+		if msg.err != nil {
+			m.statusString += "\n" + renderWarning(msg.err.Error())
+			alertCmd = m.alert.NewAlertCmd(bubbleup.ErrorKey, "Relabeling failed")
+			return m, alertCmd
+		}
+		return m, nil
+	case discoveryMsg:
+		if msg.err != nil {
+			m.statusString = renderWarning(msg.err.Error())
+			return m, nil
+		}
+		markAdopted(m.rootCluster, msg.devices)
+		m.discovered = msg.devices
+		m.discoveredIndex = 0
+		m.showDiscovered = true
+		m.statusString = fmt.Sprintf("Discovery found %d devices", len(msg.devices))
+		return m, nil
+	case sinkErrMsg:
+		alertCmd = m.alert.NewAlertCmd(bubbleup.ErrorKey, msg.err.Error())
+		return m, alertCmd
+	case firmwareMsg:
+		if msg.err != nil {
+			// unreachable phones already show up in the health checks
+			return m, nil
+		}
+		changed := !msg.firmware.same(msg.phone.Firmware)
+		msg.phone.Firmware = &msg.firmware
+		if changed {
+			m.persist()
+			if want := msg.phone.kernelMismatch(); want != "" {
+				alertCmd = m.alert.NewAlertCmd(bubbleup.WarnKey, fmt.Sprintf("%s runs kernel %s, needs %s", msg.phone.Name, msg.firmware.KernelVersion, want))
+			}
+		}
+		return m, alertCmd
+	case metricsMsg:
+		if msg.err == nil {
+			m.recordMetrics(msg.phone, msg.metrics, msg.at)
+		}
+		return m, nil
 	case tea.KeyMsg:
+		if m.conflict != nil {
+			m.resolveConflict(msg.String())
+			return m, nil
+		}
+		if m.queryEditing {
+			return m, m.updateQueryPrompt(msg)
+		}
+		if m.searching {
+			return m, m.updateSearch(msg)
+		}
+		if m.gotoEditing {
+			return m, m.updateGoto(msg)
+		}
+		if m.crumbFocus {
+			m.updateCrumbs(msg)
+			return m, nil
+		}
+		if m.readOnly && readOnlyBlocked(msg.String()) && !m.createNewUI.creatingItem && m.list.FilterState() != list.Filtering {
+			alertCmd = m.alert.NewAlertCmd(bubbleup.WarnKey, "Opened read-only, nothing can be changed")
+			return m, alertCmd
+		}
+		if len(m.usbPending) > 0 && !m.createNewUI.creatingItem {
+			switch msg.String() {
+			case "y", "enter":
+				var d USBDevice
+				m.usbPending, d = SlicePop(m.usbPending, 0)
+				m.attachUSBDevice(d)
+			case "n", "esc":
+				m.usbPending = m.usbPending[1:]
+			}
+			return m, nil
+		}
+		if m.showPipeline {
+			phones := m.pipelinePhones()
+			switch msg.String() {
+			case "up", "k":
+				if m.pipelineIndex > 0 {
+					m.pipelineIndex--
+				}
+			case "down", "j":
+				if m.pipelineIndex < len(phones)-1 {
+					m.pipelineIndex++
+				}
+			case "+":
+				if m.pipelineIndex < len(phones) {
+					p := phones[m.pipelineIndex]
+					if next := p.nextStage(); next != "" && p.advance(next, time.Now()) {
+						m.persist()
+						// the list is re-sorted by stage, keep the cursor on the phone
+						m.pipelineIndex = slices.Index(m.pipelinePhones(), p)
+					}
+				}
+			case "esc", "q":
+				m.showPipeline = false
+			}
+			return m, nil
+		}
+		if m.showFleet {
+			switch msg.String() {
+			case "esc", "q", "F":
+				m.showFleet = false
+			}
+			return m, nil
+		}
+		if m.showDiscovered {
+			switch msg.String() {
+			case "up", "k":
+				if m.discoveredIndex > 0 {
+					m.discoveredIndex--
+				}
+			case "down", "j":
+				if m.discoveredIndex < len(m.discovered)-1 {
+					m.discoveredIndex++
+				}
+			case "enter":
+				if m.discoveredIndex < len(m.discovered) {
+					m.showDiscovered = false
+					m.adoptDevice(m.discovered[m.discoveredIndex])
+				}
+			case "esc", "q":
+				m.showDiscovered = false
+			}
+			return m, nil
+		}
+		if m.showLabelPlan {
+			switch msg.String() {
+			case "y", "enter":
+				m.showLabelPlan = false
+				if len(m.labelPlans) == 0 {
+					return m, nil
+				}
+				m.statusString = fmt.Sprintf("Relabeling %d nodes...", len(m.labelPlans))
+				return m, m.applyLabelsCmd(m.labelPlans)
+			case "esc", "q":
+				m.showLabelPlan = false
+				m.labelPlans = nil
+			}
+			return m, nil
+		}
+		if m.showAlerts {
+			alerts := m.alerts.list()
+			switch msg.String() {
+			case "up", "k":
+				if m.alertIndex > 0 {
+					m.alertIndex--
+				}
+			case "down", "j":
+				if m.alertIndex < len(alerts)-1 {
+					m.alertIndex++
+				}
+			case "a", "enter":
+				if m.alertIndex < len(alerts) {
+					alerts[m.alertIndex].Acked = true
+				}
+				m.updateTitle()
+			case "esc", "q":
+				m.showAlerts = false
+			}
+			return m, nil
+		}
+		if m.detailItem != nil {
+			switch msg.String() {
+			case "w", "tab":
+				m.detailWindow = (m.detailWindow + 1) % len(historyWindows)
+			case "esc", "v", "q":
+				m.detailItem = nil
+			}
+			return m, nil
+		}
 		if m.deletionMode {
 			switch msg.String() {
 			case "c":
@@ -162,9 +513,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.itemsToDelete = nil
 				m.statusString = "Deleted items."
 				m.recreateList(m.currentCluster, 0)
-				if err := m.rootCluster.DeepCopy(); err != nil {
-					MarshalToFile("config_path", err)
-				}
+				m.persist()
 
 				return m, nil
 			case "esc":
@@ -196,6 +545,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						selectedItem.RAM = m.createNewUI.ramInput.Value()
 						selectedItem.CPU = m.createNewUI.cpuInput.Value()
 						selectedItem.CPUSpeed = m.createNewUI.cpuSpeedInput.Value()
+						selectedItem.Address = m.createNewUI.addressInput.Value()
 					}
 
 					m.recreateList(m.currentCluster, m.list.GlobalIndex())
@@ -206,13 +556,13 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.createNewUI.ramInput.Reset()
 					m.createNewUI.cpuInput.Reset()
 					m.createNewUI.cpuSpeedInput.Reset()
-					if err := m.rootCluster.DeepCopy(); err != nil {
-						MarshalToFile(config_path, err)
-					}
+					m.createNewUI.addressInput.Reset()
+					m.persist()
 					break
 				}
 				if m.createNewUI.shouldCreateCluster {
 					m.currentCluster.ChildrenClusters = append(m.currentCluster.ChildrenClusters, &Cluster{
+						ID:       newItemID(),
 						Name:     m.createNewUI.nameInput.Value(),
 						Parent:   m.currentCluster,
 						Desc:     m.createNewUI.descInput.Value(),
@@ -221,27 +571,31 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					})
 				} else {
 					phone := &Phone{
+						ID:            newItemID(),
 						Name:          m.createNewUI.nameInput.Value(),
 						ParentCluster: m.currentCluster,
 						Desc:          m.createNewUI.descInput.Value(),
 						RAM:           m.createNewUI.ramInput.Value(),
 						CPU:           m.createNewUI.cpuInput.Value(),
 						CPUSpeed:      m.createNewUI.cpuSpeedInput.Value(),
+						Address:       m.createNewUI.addressInput.Value(),
+						Serial:        m.createNewUI.serial,
 					}
 					m.currentCluster.ChildrenPhones = append(m.currentCluster.ChildrenPhones, phone)
 				}
 				m.recreateList(m.currentCluster, 0)
-				if err := m.rootCluster.DeepCopy(); err != nil {
-					MarshalToFile(config_path, err)
-				}
+				m.persist()
 				m.createNewUI.creatingItem = false
+				m.createNewUI.serial = ""
 				m.createNewUI.nameInput.Reset()
 				m.createNewUI.descInput.Reset()
 				m.createNewUI.ramInput.Reset()
 				m.createNewUI.cpuInput.Reset()
 				m.createNewUI.cpuSpeedInput.Reset()
+				m.createNewUI.addressInput.Reset()
 			case "esc":
 				m.createNewUI.creatingItem = false
+				m.createNewUI.serial = ""
 				m.createNewUI.status = ""
 				m.createNewUI.edit = false
 				m.createNewUI.shouldCreateCluster = false
@@ -250,6 +604,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.createNewUI.ramInput.Reset()
 				m.createNewUI.cpuInput.Reset()
 				m.createNewUI.cpuSpeedInput.Reset()
+				m.createNewUI.addressInput.Reset()
 
 			case "down":
 				if m.createNewUI.nameInput.Focused() {
@@ -270,6 +625,9 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.createNewUI.cpuSpeedInput.Focus()
 				} else if m.createNewUI.cpuSpeedInput.Focused() {
 					m.createNewUI.cpuSpeedInput.Blur()
+					m.createNewUI.addressInput.Focus()
+				} else if m.createNewUI.addressInput.Focused() {
+					m.createNewUI.addressInput.Blur()
 					m.createNewUI.nameInput.Focus()
 				}
 			case "up":
@@ -278,7 +636,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					if m.createNewUI.shouldCreateCluster {
 						m.createNewUI.descInput.Focus()
 					} else {
-						m.createNewUI.cpuSpeedInput.Focus()
+						m.createNewUI.addressInput.Focus()
 					}
 				} else if m.createNewUI.descInput.Focused() {
 					m.createNewUI.descInput.Blur()
@@ -292,6 +650,9 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				} else if m.createNewUI.cpuSpeedInput.Focused() {
 					m.createNewUI.cpuSpeedInput.Blur()
 					m.createNewUI.cpuInput.Focus()
+				} else if m.createNewUI.addressInput.Focused() {
+					m.createNewUI.addressInput.Blur()
+					m.createNewUI.cpuSpeedInput.Focus()
 				}
 			case "alt+t":
 				if m.createNewUI.edit {
@@ -303,6 +664,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.createNewUI.ramInput.Blur()
 				m.createNewUI.cpuInput.Blur()
 				m.createNewUI.cpuSpeedInput.Blur()
+				m.createNewUI.addressInput.Blur()
 				if m.createNewUI.shouldCreateCluster {
 					m.createNewUI.status = "New Cluster: " + PHONE_MESSAGE
 					alertCmd := m.alert.NewAlertCmd(bubbleup.InfoKey, "Creating Cluster")
@@ -332,6 +694,9 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.createNewUI.cpuSpeedInput, cmd = m.createNewUI.cpuSpeedInput.Update(msg)
 			cmds = append(cmds, cmd)
 
+			m.createNewUI.addressInput, cmd = m.createNewUI.addressInput.Update(msg)
+			cmds = append(cmds, cmd)
+
 			return m, tea.Batch(cmds...)
 		}
 
@@ -355,30 +720,19 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "ctrl+c", "q":
 			return m, tea.Quit
 		case "r":
-			if item, ok := m.list.SelectedItem().(*Cluster); ok {
-				item.JobPercentage = 0
-				item.JobState = "running"
-				m.statusString = fmt.Sprintf("Restarted job for cluster %s", item.Name)
-			}
+			m.controlJobs("restart", "Restarted")
 		case "s": // Start job
-			if item, ok := m.list.SelectedItem().(*Cluster); ok {
-				item.JobState = "running"
-				m.statusString = fmt.Sprintf("Started job for cluster %s", item.Name)
-			}
+			m.controlJobs("start", "Started")
 		case "x":
-			if item, ok := m.list.SelectedItem().(*Cluster); ok {
-				item.JobState = "stopped"
-				m.statusString = fmt.Sprintf("Stopped job for cluster %s", item.Name)
-			}
+			m.controlJobs("stop", "Stopped")
 		case "f":
 			m.statusString = "Sort mode not applicable."
 			m.sortMode = false
 			return m, nil
 		case "enter":
-			last_pos = m.list.Index()
 			switch selectedItem := m.list.SelectedItem().(type) {
 			case *Cluster:
-				m.recreateList(selectedItem, 0)
+				m.enterCluster(selectedItem)
 			case *Phone:
 				// No action on enter for phone
 			}
@@ -390,6 +744,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.createNewUI.ramInput.Blur()
 			m.createNewUI.cpuInput.Blur()
 			m.createNewUI.cpuSpeedInput.Blur()
+			m.createNewUI.addressInput.Blur()
 			switch selectedItem := m.list.SelectedItem().(type) {
 			case *Cluster:
 				m.createNewUI.shouldCreateCluster = true
@@ -404,11 +759,26 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.createNewUI.ramInput.SetValue(selectedItem.RAM)
 				m.createNewUI.cpuInput.SetValue(selectedItem.CPU)
 				m.createNewUI.cpuSpeedInput.SetValue(selectedItem.CPUSpeed)
+				m.createNewUI.addressInput.SetValue(selectedItem.Address)
 			}
+		case "Q":
+			m.openQueryPrompt()
+			return m, nil
+		case "ctrl+f":
+			m.openSearch()
+			return m, nil
+		case "ctrl+g":
+			m.openGoto()
+			return m, nil
+		case "tab":
+			m.focusCrumbs()
+			return m, nil
 		case "b":
-			if m.currentCluster != nil {
-				m.recreateList(m.currentCluster.Parent, last_pos)
+			if m.query != nil {
+				m.setQuery(nil)
+				return m, nil
 			}
+			m.goBack()
 			return m, nil
 		case "p":
 			switch v := m.list.SelectedItem().(type) {
@@ -419,17 +789,116 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.statusString = v.returnTree()
 
 			}
-		case "z":
-			cmd := exec.Command("bash", "-c", `ssh -T ttf@hackclub.app "nest resources" 2>/dev/null | grep -E 'Disk usage|Memory usage'`)
-			out, err := cmd.CombinedOutput()
+		case "K":
+			if m.kube == nil {
+				alertCmd = m.alert.NewAlertCmd(bubbleup.WarnKey, "No k3s API configured (-kube-api)")
+				return m, alertCmd
+			}
+			m.syncRequested = true
+			m.statusString = "Syncing k3s nodes..."
+			return m, m.kube.syncCmd()
+		case "i":
+			if m.lastSync == nil || len(m.lastSync.UnknownNodes) == 0 {
+				alertCmd = m.alert.NewAlertCmd(bubbleup.InfoKey, "No unknown nodes to import")
+				return m, alertCmd
+			}
+			for _, n := range m.lastSync.UnknownNodes {
+				m.currentCluster.ChildrenPhones = append(m.currentCluster.ChildrenPhones, phoneFromNode(n, m.currentCluster))
+			}
+			m.statusString = fmt.Sprintf("Imported %d nodes into %s", len(m.lastSync.UnknownNodes), m.currentCluster.Name)
+			m.lastSync.UnknownNodes = nil
+			m.recreateList(m.currentCluster, 0)
+			m.persist()
+			return m, nil
+		case "D":
+			if len(m.rootCluster.DiscoverySubnets) == 0 {
+				alertCmd = m.alert.NewAlertCmd(bubbleup.WarnKey, "No discovery_subnets in config")
+				return m, alertCmd
+			}
+			m.statusString = "Sweeping " + strings.Join(m.rootCluster.DiscoverySubnets, ", ") + "..."
+			return m, discoveryCmd(m.rootCluster.DiscoverySubnets)
+		case "F":
+			m.showFleet = true
+			return m, nil
+		case "P":
+			m.showPipeline = true
+			m.pipelineIndex = 0
+			return m, nil
+		case "T":
+			phone, ok := m.list.SelectedItem().(*Phone)
+			if !ok {
+				alertCmd = m.alert.NewAlertCmd(bubbleup.WarnKey, "Select a phone to enroll")
+				return m, alertCmd
+			}
+			token := phone.newEnrollmentToken(time.Now(), m.enrollTTL)
+			m.statusString = fmt.Sprintf("Enrollment token for %s (shown once, valid %s):\n\n\t%s\n\nOn the phone: TOKEN=%s ./kubersetup.sh", phone.Name, m.enrollTTL, token, token)
+			// a token that didn't get saved won't work, say so instead
+			m.persist()
+			return m, nil
+		case "L":
+			if m.kube == nil {
+				alertCmd = m.alert.NewAlertCmd(bubbleup.WarnKey, "No k3s API configured (-kube-api)")
+				return m, alertCmd
+			}
+			m.statusString = "Diffing node labels..."
+			return m, m.planLabelsCmd(nil, false)
+		case "m":
+			if m.moving == nil {
+				if m.list.SelectedItem() == nil {
+					return m, nil
+				}
+				m.moving = m.list.SelectedItem()
+				m.statusString = "Moving item: open the target cluster and press m to drop it, esc to cancel."
+				return m, nil
+			}
+			moved, err := moveInto(m.moving, m.currentCluster)
+			m.moving = nil
 			if err != nil {
-				m.statusString = fmt.Sprintf("Error: %v\n%s", err, out)
-			} else {
-				strings.Replace(strings.Replace(string(out), "15.00 GB limit", "128.00 GB limit", -1), "2.00 GB limit", "16.00 GB limit", -1)
-				m.statusString = string(strings.Replace(strings.Replace(string(out), "15.00 GB limit", "128.00 GB limit", -1), "2.00 GB limit", "16.00 GB limit", -1))
-				m.statusString = strings.Replace(m.statusString, "0.74 GB", "12.21 GB", -1)
+				alertCmd = m.alert.NewAlertCmd(bubbleup.ErrorKey, err.Error())
+				return m, alertCmd
+			}
+			m.statusString = "Moved into " + m.currentCluster.Name
+			m.recreateList(m.currentCluster, 0)
+			m.persist()
+			if m.kube != nil {
+				return m, m.planLabelsCmd(moved, true)
 			}
 			return m, nil
+		case "esc":
+			if m.moving != nil {
+				m.moving = nil
+				m.statusString = "Move cancelled."
+				return m, nil
+			}
+			if m.query != nil {
+				m.setQuery(nil)
+				return m, nil
+			}
+		case "a":
+			m.showAlerts = true
+			m.alertIndex = 0
+			return m, nil
+		case "v":
+			if m.list.SelectedItem() != nil {
+				m.detailItem = m.list.SelectedItem()
+			}
+			return m, nil
+		case "z":
+			var cfg *ProbeConfig
+			switch v := m.list.SelectedItem().(type) {
+			case *Phone:
+				cfg = v.effectiveProbe()
+			case *Cluster:
+				cfg = v.effectiveProbe()
+			default:
+				cfg = m.currentCluster.effectiveProbe()
+			}
+			if cfg == nil {
+				alertCmd = m.alert.NewAlertCmd(bubbleup.WarnKey, "No probe configured")
+				return m, alertCmd
+			}
+			m.statusString = fmt.Sprintf("Probing %s...", cfg.target())
+			return m, probeCmd(cfg)
 
 		case "d":
 			m.deletionMode = true
@@ -487,6 +956,9 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		}
 
+	case probeResultMsg:
+		m.statusString = ProbeResult(msg).print()
+		return m, nil
 	case tea.WindowSizeMsg:
 		h, v := docStyle.GetFrameSize()
 		listWidth := msg.Width - h
@@ -509,6 +981,41 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m *model) View() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conflict != nil {
+		return docStyle.Render(m.alert.Render(m.conflictView()))
+	}
+	if m.queryEditing {
+		return docStyle.Render(m.alert.Render(m.queryView()))
+	}
+	if m.searching {
+		return docStyle.Render(m.alert.Render(m.searchView()))
+	}
+	if m.gotoEditing {
+		return docStyle.Render(m.alert.Render(m.gotoView()))
+	}
+	if len(m.usbPending) > 0 && !m.createNewUI.creatingItem {
+		return docStyle.Render(m.alert.Render(m.usbPromptView()))
+	}
+	if m.showPipeline {
+		return docStyle.Render(m.alert.Render(m.pipelineView()))
+	}
+	if m.showFleet {
+		return docStyle.Render(m.alert.Render(m.fleetView()))
+	}
+	if m.showDiscovered {
+		return docStyle.Render(m.alert.Render(m.discoveredView()))
+	}
+	if m.showLabelPlan {
+		return docStyle.Render(m.alert.Render(m.labelPlanView()))
+	}
+	if m.showAlerts {
+		return docStyle.Render(m.alert.Render(m.alertsView()))
+	}
+	if m.detailItem != nil {
+		return docStyle.Render(m.alert.Render(m.detailView()))
+	}
 	if m.createNewUI.creatingItem {
 		var s string
 		if m.showHelp {
@@ -519,6 +1026,7 @@ func (m *model) View() string {
 				m.createNewUI.ramInput.View(),
 				m.createNewUI.cpuInput.View(),
 				m.createNewUI.cpuSpeedInput.View(),
+				m.createNewUI.addressInput.View(),
 				"\n"+m.help.View(createKeys),
 			)
 		} else {
@@ -533,6 +1041,8 @@ func (m *model) View() string {
 				m.createNewUI.cpuInput.View(),
 				"\n",
 				m.createNewUI.cpuSpeedInput.View(),
+				"\n",
+				m.createNewUI.addressInput.View(),
 			)
 		}
 		return docStyle.Render(m.alert.Render(s))
@@ -558,6 +1068,22 @@ func (m *model) View() string {
 
 	return m.alert.Render(s)
 }
+func (m *model) updateTitle() {
+	if m.currentCluster == nil {
+		return
+	}
+	m.list.Title = fmt.Sprintf("%s \n %s %s", m.breadcrumbs(), m.currentCluster.Stats.print(), m.currentCluster.healthSummary())
+	if m.query != nil {
+		m.list.Title = fmt.Sprintf("Query: %s \n %d phones (Q: change, esc: back to %s)", m.query.text, len(m.list.Items()), m.currentCluster.plainName())
+	}
+	if m.readOnly {
+		m.list.Title += " [read-only]"
+	}
+	if n := m.alerts.unacked(); n > 0 {
+		m.list.Title += renderWarning(fmt.Sprintf("  ⚠ %d alerts (a)", n))
+	}
+}
+
 func (m *model) recreateList(cluster *Cluster, selectedItem int) {
 	if cluster == nil {
 		return
@@ -568,17 +1094,24 @@ func (m *model) recreateList(cluster *Cluster, selectedItem int) {
 	}
 	var items []list.Item
 
-	for _, child := range cluster.ChildrenClusters {
-		if !strings.HasPrefix(child.Title(), "🌐") {
-			child.Name = "🌐 " + child.Title()
+	if m.query != nil {
+		for _, p := range m.query.selectPhones(m.rootCluster, time.Now()) {
+			items = append(items, p)
+		}
+	} else {
+		for _, child := range cluster.ChildrenClusters {
+			if !strings.HasPrefix(child.Title(), "🌐") {
+				child.Name = "🌐 " + child.Title()
+			}
+			items = append(items, child)
+		}
+		for _, child := range cluster.ChildrenPhones {
+			items = append(items, child)
 		}
-		items = append(items, child)
-	}
-	for _, child := range cluster.ChildrenPhones {
-		items = append(items, child)
 	}
+	m.list.SetDelegate(itemDelegate{showPaths: m.query != nil})
 	m.list.SetItems(items)
-	m.list.Title = fmt.Sprintf("%s \n %s", m.currentCluster.returnPath(), m.currentCluster.Stats.print())
+	m.updateTitle()
 	m.list.Select(selectedItem)
 	m.list.AdditionalShortHelpKeys = func() []key.Binding {
 		return []key.Binding{
@@ -588,12 +1121,26 @@ func (m *model) recreateList(cluster *Cluster, selectedItem int) {
 	m.list.AdditionalFullHelpKeys = func() []key.Binding {
 		return []key.Binding{
 			key.NewBinding(key.WithKeys("f"), key.WithHelp("f", "enable advanced sorting")),
-			key.NewBinding(key.WithKeys("b"), key.WithHelp("b", "go to upper level")),
+			key.NewBinding(key.WithKeys("b"), key.WithHelp("b", "go back up")),
+			key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "pick a level from the breadcrumbs")),
 			key.NewBinding(key.WithKeys("enter"), key.WithHelp("d", "enter deletion mode")),
 			key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "create new item")),
 			key.NewBinding(key.WithKeys("e"), key.WithHelp("e", "edit item")),
 			key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "enter cluster/toggle item")),
-			key.NewBinding(key.WithKeys("z"), key.WithHelp("z", "show ls -la output")),
+			key.NewBinding(key.WithKeys("z"), key.WithHelp("z", "probe resources")),
+			key.NewBinding(key.WithKeys("v"), key.WithHelp("v", "metric history")),
+			key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "alerts")),
+			key.NewBinding(key.WithKeys("K"), key.WithHelp("K", "sync k3s nodes")),
+			key.NewBinding(key.WithKeys("i"), key.WithHelp("i", "import unknown nodes")),
+			key.NewBinding(key.WithKeys("L"), key.WithHelp("L", "diff/apply node labels")),
+			key.NewBinding(key.WithKeys("m"), key.WithHelp("m", "move item")),
+			key.NewBinding(key.WithKeys("D"), key.WithHelp("D", "discover phones")),
+			key.NewBinding(key.WithKeys("T"), key.WithHelp("T", "enrollment token")),
+			key.NewBinding(key.WithKeys("P"), key.WithHelp("P", "provisioning pipeline")),
+			key.NewBinding(key.WithKeys("F"), key.WithHelp("F", "kernels by phone")),
+			key.NewBinding(key.WithKeys("Q"), key.WithHelp("Q", "select phones by query")),
+			key.NewBinding(key.WithKeys("ctrl+f"), key.WithHelp("ctrl+f", "search everything")),
+			key.NewBinding(key.WithKeys("ctrl+g"), key.WithHelp("ctrl+g", "go to path")),
 			keys.startJob,
 			keys.stopJob,
 			keys.restartJob,
@@ -603,17 +1150,133 @@ func (m *model) recreateList(cluster *Cluster, selectedItem int) {
 
 func main() {
 
+	var simulate bool
+	var seed int64
+	var failureRate, healthFailureRate float64
+	var latency time.Duration
+	var historyDir string
+	var headless bool
+	var metricsAddr string
+	var kubeAPI, kubeTokenFile, kubeCAFile string
+	var kubeInsecure bool
+	var bootstrapAddr, bootstrapTLSDir, bootstrapHostList, bootstrapLogPath string
+	var enrollTTL time.Duration
+	var usbRoot string
+	var serverURL, serverTokenFile, userName string
+	var readOnly bool
 	flag.StringVar(&config_path, "c", config_path, "config file path")
+	flag.BoolVar(&simulate, "simulate", false, "run against the deterministic simulator")
+	flag.Int64Var(&seed, "seed", 1, "simulator seed (only used with -simulate)")
+	flag.Float64Var(&failureRate, "sim-failure-rate", 0, "chance per tick that a simulated job fails")
+	flag.Float64Var(&healthFailureRate, "sim-health-failure-rate", 0, "chance per tick that a simulated health check fails")
+	flag.DurationVar(&latency, "sim-latency", 0, "delay before a simulated job starts making progress")
+	flag.StringVar(&historyDir, "history-dir", "", "directory to persist metric history in (kept in memory only if empty)")
+	flag.BoolVar(&headless, "headless", false, "run without the TUI (use with -metrics-addr)")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address, e.g. :9100")
+	flag.StringVar(&kubeAPI, "kube-api", "", "k3s API server URL, e.g. https://192.168.0.1:6443")
+	flag.StringVar(&kubeTokenFile, "kube-token-file", "", "file holding a bearer token for the k3s API")
+	flag.StringVar(&kubeCAFile, "kube-ca", "", "CA certificate of the k3s API server")
+	flag.BoolVar(&kubeInsecure, "kube-insecure", false, "skip TLS verification of the k3s API server")
+	flag.StringVar(&bootstrapAddr, "bootstrap-addr", "", "serve k3s configs to phones over HTTPS on this address, e.g. :8080")
+	flag.StringVar(&bootstrapTLSDir, "bootstrap-tls-dir", "bootstrap-tls", "directory holding the bootstrap CA")
+	flag.StringVar(&bootstrapHostList, "bootstrap-hosts", "", "comma-separated names and IPs for the bootstrap certificate (all local addresses if empty)")
+	flag.StringVar(&bootstrapLogPath, "bootstrap-log", "bootstrap.log", "file to log config fetches to (stderr if empty)")
+	flag.DurationVar(&enrollTTL, "enroll-ttl", defaultEnrollTTL, "how long enrollment tokens stay valid")
+	flag.StringVar(&usbRoot, "usb-sysfs", usbSysfs, "sysfs USB devices directory to watch for phones (empty to disable)")
+	flag.StringVar(&serverURL, "server", "", "edit the tree of a running serve daemon instead of the config file, e.g. http://localhost:8081")
+	flag.StringVar(&serverTokenFile, "server-token-file", "", "file holding the daemon's API token")
+	flag.StringVar(&userName, "user", currentUser(), "name to stamp your changes with")
+	flag.BoolVar(&readOnly, "read-only", false, "open the config without locking or saving it, following changes others save")
 	flag.Parse()
-	rand.Seed(time.Now().UnixNano())
+	if err := checkSimFlags(flag.CommandLine, simulate); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var apiAddr, apiToken string
+	if flag.Arg(0) == "serve" {
+		fs := flag.NewFlagSet("serve", flag.ExitOnError)
+		fs.StringVar(&apiAddr, "addr", "localhost:8081", "address to serve the API on")
+		tokenFile := fs.String("token-file", "", "file holding a bearer token API clients must send")
+		fs.Parse(flag.Args()[1:])
+		var err error
+		if apiToken, err = readAPIToken(*tokenFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		headless = true
+	} else if flag.NArg() > 0 {
+		os.Exit(runSubcommand(flag.Args()))
+	}
+	if !simulate {
+		seed = time.Now().UnixNano()
+	}
+	sim := newSimulator(seed)
+	if simulate {
+		sim.FailureRate = failureRate
+		sim.HealthFailureRate = healthFailureRate
+		sim.Latency = latencyTicks(latency)
+	}
 	delegate := itemDelegate{}
-	err, ferr := loadIntoCluster(config_path)
-	if ferr != nil {
-		panic(ferr)
+	var remote *remoteClient
+	var remoteETag string
+	var root *Cluster
+	var diskHash string
+	if serverURL != "" {
+		if bootstrapAddr != "" {
+			// the daemon doesn't hand out k3s tokens, so it serves the configs
+			fmt.Fprintln(os.Stderr, "-bootstrap-addr can't be used with -server, pass it to the daemon")
+			os.Exit(2)
+		}
+		token, err := readAPIToken(serverTokenFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		remote = newRemoteClient(serverURL, token, userName)
+		if root, remoteETag, err = remote.fetchConfig(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		if !readOnly {
+			lock, err := lockConfig(config_path)
+			switch {
+			case errors.Is(err, errLocked) && !headless:
+				if !askReadOnly(err) {
+					os.Exit(1)
+				}
+				readOnly = true
+			case err != nil:
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			default:
+				defer lock.Close()
+			}
+		}
+		// a config that doesn't parse must not be opened as an empty tree,
+		// the first save would write over it
+		var err error
+		if root, err = loadConfig(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if diskHash, err = fileHash(config_path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
-	root := err
 	root.Parent = nil
 	reconstructClusterFromJSON(root)
+	if err := checkAlertRules(root); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if historyDir != "" {
+		if err := loadHistory(historyDir, root); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 	ti := textinput.New()
 	t2 := textarea.New()
 	ti.Placeholder = "New Name (Mandatory)"
@@ -630,6 +1293,18 @@ func main() {
 	cpuSpeedInput := textinput.New()
 	cpuSpeedInput.Placeholder = "CPU Speed (e.g. 2.4GHz)"
 	cpuSpeedInput.Width = 100
+	addressInput := textinput.New()
+	addressInput.Placeholder = "Address (agent host[:port] or user@host)"
+	addressInput.Width = 100
+	queryInput := textinput.New()
+	queryInput.Placeholder = "Root/Lab ram>=6 cores>=8 #gpu"
+	queryInput.Width = 100
+	searchInput := textinput.New()
+	searchInput.Placeholder = "name, description, RAM, serial, kernel..."
+	searchInput.Width = 100
+	gotoInput := textinput.New()
+	gotoInput.Placeholder = "Root/Lab/ShelfA"
+	gotoInput.Width = 100
 
 	m := model{
 		list: list.New(nil, delegate, 80, 24),
@@ -639,17 +1314,86 @@ func main() {
 			ramInput:      ramInput,
 			cpuInput:      cpuInput,
 			cpuSpeedInput: cpuSpeedInput,
+			addressInput:  addressInput,
 		},
-		help:  help.New(),
-		alert: *bubbleup.NewAlertModel(20, true),
+		help:        help.New(),
+		alert:       *bubbleup.NewAlertModel(20, true),
+		sim:         sim,
+		simulate:    simulate,
+		historyDir:  historyDir,
+		enrollTTL:   enrollTTL,
+		usbRoot:     usbRoot,
+		user:        userName,
+		remote:      remote,
+		remoteETag:  remoteETag,
+		headless:    headless,
+		readOnly:    readOnly,
+		diskHash:    diskHash,
+		alerts:      newAlertEngine(root.AlertSinks),
+		queryInput:  queryInput,
+		searchInput: searchInput,
+		gotoInput:   gotoInput,
+	}
+	if kubeAPI != "" {
+		kube, err := newKubeClient(kubeAPI, kubeTokenFile, kubeCAFile, kubeInsecure)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		m.kube = kube
 	}
 	m.recreateList(root, m.list.GlobalIndex())
 	m.statusString = "Press P to preview an Item!"
 	m.list.Title = "Cluster View "
 	m.createNewUI.status = PHONE_MESSAGE
 	m.rootCluster = root
+	m.fingerprints = fingerprints(root)
+
+	if metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", m.handleMetrics)
+		go func() {
+			if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+				fmt.Println("Error serving metrics:", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if bootstrapAddr != "" {
+		hosts := bootstrapHosts()
+		if bootstrapHostList != "" {
+			hosts = strings.Split(bootstrapHostList, ",")
+		}
+		logger, err := openBootstrapLog(bootstrapLogPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		m.bootstrapLog = logger
+		if err := m.serveBootstrap(bootstrapAddr, bootstrapTLSDir, hosts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if apiAddr != "" {
+		m.events = newEventHub()
+		m.serveAPI(apiAddr, apiToken)
+	}
 
-	p := tea.NewProgram(&m)
+	var opts []tea.ProgramOption
+	if headless {
+		m.usbRoot = "" // nobody to answer the attach prompt
+		opts = append(opts, tea.WithoutRenderer(), tea.WithInput(nil))
+	}
+	if readOnly {
+		m.usbRoot = "" // attaching would need saving
+	}
+	p := tea.NewProgram(&m, opts...)
+	if m.remote != nil {
+		go m.remote.watch(p.Send)
+	}
 
 	if _, err := p.Run(); err != nil {
 		fmt.Println("Error running program:", err)
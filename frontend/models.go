@@ -1,15 +1,16 @@
 package main
 
 import (
+	"ToDoIt/resources"
 	"fmt"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/progress"
 	tea "github.com/charmbracelet/bubbletea"
-	"math/rand"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type listKeyMap struct {
@@ -25,6 +26,21 @@ type listKeyMap struct {
 	startJob     key.Binding
 	stopJob      key.Binding
 	restartJob   key.Binding
+	runProbe     key.Binding
+	viewDetail   key.Binding
+	showAlerts   key.Binding
+	syncNodes    key.Binding
+	importNodes  key.Binding
+	planLabels   key.Binding
+	moveItem     key.Binding
+	discover     key.Binding
+	enrollPhone  key.Binding
+	pipeline     key.Binding
+	fleet        key.Binding
+	query        key.Binding
+	search       key.Binding
+	crumbs       key.Binding
+	gotoPath     key.Binding
 }
 type itemKeyMap struct {
 	goUp   key.Binding
@@ -47,19 +63,46 @@ func newListKeyMap() *listKeyMap {
 		startJob:     key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "start job")),
 		stopJob:      key.NewBinding(key.WithKeys("x"), key.WithHelp("x", "stop job")),
 		restartJob:   key.NewBinding(key.WithKeys("ctrl+r"), key.WithHelp("ctrl+r", "restart job")),
+		runProbe:     key.NewBinding(key.WithKeys("z"), key.WithHelp("z", "probe resources")),
+		viewDetail:   key.NewBinding(key.WithKeys("v"), key.WithHelp("v", "metric history")),
+		showAlerts:   key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "alerts")),
+		syncNodes:    key.NewBinding(key.WithKeys("K"), key.WithHelp("K", "sync k3s nodes")),
+		importNodes:  key.NewBinding(key.WithKeys("i"), key.WithHelp("i", "import unknown nodes")),
+		planLabels:   key.NewBinding(key.WithKeys("L"), key.WithHelp("L", "diff/apply node labels")),
+		moveItem:     key.NewBinding(key.WithKeys("m"), key.WithHelp("m", "move item")),
+		discover:     key.NewBinding(key.WithKeys("D"), key.WithHelp("D", "discover phones")),
+		enrollPhone:  key.NewBinding(key.WithKeys("T"), key.WithHelp("T", "enrollment token")),
+		pipeline:     key.NewBinding(key.WithKeys("P"), key.WithHelp("P", "provisioning pipeline")),
+		fleet:        key.NewBinding(key.WithKeys("F"), key.WithHelp("F", "kernels by phone")),
+		query:        key.NewBinding(key.WithKeys("Q"), key.WithHelp("Q", "select phones by query")),
+		search:       key.NewBinding(key.WithKeys("ctrl+f"), key.WithHelp("ctrl+f", "search everything")),
+		crumbs:       key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "pick a level from the breadcrumbs")),
+		gotoPath:     key.NewBinding(key.WithKeys("ctrl+g"), key.WithHelp("ctrl+g", "go to path")),
 	}
 }
 
 type Cluster struct {
+	ID               string `json:"id,omitempty"`
 	Name             string `json:"Name,omitempty"`
 	Desc             string `json:"Desc,omitempty"`
 	Progress         progress.Model
-	Parent           *Cluster   `json:"Parent,omitempty"`
-	ChildrenPhones   []*Phone   `json:"children_phones,omitempty"`
-	ChildrenClusters []*Cluster `json:"children_clusters,omitempty"`
-	Stats            Stats      `json:"Stats"`
-	JobPercentage    float64    `json:"-"`
-	JobState         string     `json:"-"` // "stopped", "running"
+	Parent           *Cluster          `json:"Parent,omitempty"`
+	ChildrenPhones   []*Phone          `json:"children_phones,omitempty"`
+	ChildrenClusters []*Cluster        `json:"children_clusters,omitempty"`
+	Stats            Stats             `json:"Stats"`
+	Probe            *ProbeConfig      `json:"probe,omitempty"`
+	JobPercentage    float64           `json:"-"`
+	JobState         string            `json:"-"` // "stopped", "running", "failed"
+	AlertRules       []AlertRule       `json:"alert_rules,omitempty"`
+	AlertSinks       []AlertSinkConfig `json:"alert_sinks,omitempty"`
+	Taints           []kubeTaint       `json:"taints,omitempty"` // applied to every node below this cluster
+	DiscoverySubnets []string          `json:"discovery_subnets,omitempty"`
+	K3s              *K3sConfig        `json:"k3s,omitempty"`
+	Wifi             []WifiProfile     `json:"wifi,omitempty"`
+	RequiredKernel   string            `json:"required_kernel,omitempty"` // kernel version or build hash phones below must run
+	Changed          *ChangeStamp      `json:"changed,omitempty"`
+	History          MetricHistory     `json:"-"`
+	jobDelay         int
 }
 
 func (i *Cluster) Title() string       { return "🌐" + i.Name }
@@ -86,23 +129,38 @@ func (i *Cluster) returnTree() string {
 }
 func (i *Cluster) returnPath() string {
 	var pathParts []string
-	current := i
-	pathParts = append(pathParts, "Root")
-	for current != nil {
-		pathParts = append(pathParts, current.Title())
-		current = current.Parent
+	for current := i; current != nil; current = current.Parent {
+		pathParts = append(pathParts, current.plainName())
 	}
-
 	slices.Reverse(pathParts)
 	return strings.Join(pathParts, " > ")
 }
 func (t *Phone) returnStatusString() string {
 	var s string
-	s += "📱 " + t.Title() + "\n"
-	if t.Description() != "" {
-		s += "\t" + t.Description() + "\n"
+	s += "📱 " + t.Title() + " " + t.Health.badge()
+	if badge := t.Enrollment.badge(time.Now()); badge != "" {
+		s += " " + badge
+	}
+	s += "\n"
+	if t.Description() != "" || t.Changed != nil {
+		s += "\t" + strings.TrimSpace(t.Description()+"  "+t.Changed.print(time.Now())) + "\n"
 	}
 	s += fmt.Sprintf("\tRAM: %s, CPU: %s, CPU Speed: %s", t.RAM, t.CPU, t.CPUSpeed)
+	if len(t.Tags) > 0 {
+		s += "  #" + strings.Join(t.Tags, " #")
+	}
+	if t.Node != nil {
+		s += "\n\t" + t.Node.print()
+	}
+	if t.Unlock != nil {
+		s += "\n\t" + t.Unlock.print()
+	}
+	if t.Firmware != nil {
+		s += "\n\t" + t.Firmware.print()
+		if want := t.kernelMismatch(); want != "" {
+			s += " " + renderWarning("needs "+want)
+		}
+	}
 	return s
 }
 
@@ -126,12 +184,29 @@ func (i *Cluster) View() string {
 }
 
 type Phone struct {
-	ParentCluster *Cluster
+	ParentCluster *Cluster `json:"-"`
+	ID            string   `json:"id,omitempty"`
 	Name          string
 	Desc          string
 	RAM           string
 	CPU           string
 	CPUSpeed      string
+	Probe         *ProbeConfig       `json:"probe,omitempty"`
+	Address       string             `json:"address,omitempty"`  // agent host[:port], or user@host for ssh
+	Check         string             `json:"check,omitempty"`    // "agent" (default), "ssh" or "k3s"
+	Hostname      string             `json:"hostname,omitempty"` // k3s node name
+	Role          string             `json:"role,omitempty"`     // k3s role, "agent" (default) or "server"
+	Tags          []string           `json:"tags,omitempty"`
+	Node          *NodeInfo          `json:"node,omitempty"`
+	Serial        string             `json:"serial,omitempty"` // adb serial number
+	Unlock        *UnlockRecord      `json:"unlock,omitempty"`
+	Enrollment    *EnrollmentToken   `json:"enrollment,omitempty"`
+	Pipeline      *Pipeline          `json:"pipeline,omitempty"`
+	Firmware      *FirmwareInfo      `json:"firmware,omitempty"`
+	Changed       *ChangeStamp       `json:"changed,omitempty"`
+	Health        PhoneHealth        `json:"-"`
+	Metrics       *resources.Metrics `json:"-"`
+	History       MetricHistory      `json:"-"`
 }
 
 func (k listKeyMap) ShortHelp() []key.Binding {
@@ -141,7 +216,8 @@ func (k listKeyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.enterCluster, k.goBack, k.newPhone, k.editItem},              // first column
 		{k.deleteItem, k.previewItem, k.reloadData, k.showHelp, k.quit}, // second column
-		{k.startJob, k.stopJob, k.restartJob},                           // third column
+		{k.startJob, k.stopJob, k.restartJob, k.runProbe, k.viewDetail, k.showAlerts},
+		{k.syncNodes, k.importNodes, k.planLabels, k.moveItem, k.discover, k.enrollPhone, k.pipeline, k.fleet, k.query, k.search}, // third column
 	}
 }
 
@@ -256,16 +332,6 @@ func (c *Cluster) calculateStats() (totalRAM float64, totalCPU float64, totalPho
 	return currentRAM, currentCPU, currentPhones
 }
 
-func (c *Cluster) updateJobPercentages() {
-	if c.JobState == "running" {
-		if c.JobPercentage < 1.0 {
-			c.JobPercentage += rand.Float64() / 20.0 // Slower increment
-		}
-		if c.JobPercentage > 1.0 {
-			c.JobPercentage = 1.0
-		}
-	}
-	for _, child := range c.ChildrenClusters {
-		child.updateJobPercentages()
-	}
+func (c *Cluster) updateJobPercentages(sim *Simulator) {
+	sim.step(c)
 }
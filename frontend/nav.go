@@ -0,0 +1,276 @@
+package main
+
+import (
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"slices"
+	"strings"
+)
+
+var crumbStyle = lipgloss.NewStyle().Reverse(true)
+
+// navFrame is a cluster above the current one and what was selected in it
+// on the way down.
+type navFrame struct {
+	cluster *Cluster
+	item    list.Item
+	index   int // in case item is gone by the time we come back
+}
+
+// pathFrames is the way down from the root to c as if it had been walked,
+// each level selecting the cluster below it.
+func pathFrames(c *Cluster) []navFrame {
+	var frames []navFrame
+	for child := c; child.Parent != nil; child = child.Parent {
+		frames = append(frames, navFrame{cluster: child.Parent, item: child, index: slices.Index(child.Parent.ChildrenClusters, child)})
+	}
+	slices.Reverse(frames)
+	return frames
+}
+
+func (m *model) selectItem(item list.Item) bool {
+	for i, it := range m.list.VisibleItems() {
+		if it == item {
+			m.list.Select(i)
+			return true
+		}
+	}
+	return false
+}
+
+// enterCluster opens c, a child of the current cluster, remembering what
+// was selected to come back to.
+func (m *model) enterCluster(c *Cluster) {
+	m.navStack = append(m.navStack, navFrame{m.currentCluster, m.list.SelectedItem(), m.list.Index()})
+	m.recreateList(c, 0)
+}
+
+// backTo goes up to level i of the breadcrumbs, 0 being the root, and
+// selects what was selected there.
+func (m *model) backTo(i int) {
+	if i < 0 || i >= len(m.navStack) {
+		return
+	}
+	f := m.navStack[i]
+	m.navStack = m.navStack[:i]
+	m.recreateList(f.cluster, f.index)
+	m.selectItem(f.item)
+}
+
+func (m *model) goBack() {
+	if m.currentCluster == nil || m.currentCluster.Parent == nil {
+		return
+	}
+	if n := len(m.navStack); n == 0 || m.navStack[n-1].cluster != m.currentCluster.Parent {
+		m.navStack = pathFrames(m.currentCluster)
+	}
+	m.backTo(len(m.navStack) - 1)
+}
+
+// openCluster jumps straight to c with item selected.
+func (m *model) openCluster(c *Cluster, item list.Item) {
+	m.query = nil
+	m.crumbFocus = false
+	m.list.ResetFilter()
+	m.navStack = pathFrames(c)
+	m.recreateList(c, 0)
+	if item != nil {
+		m.selectItem(item)
+	}
+}
+
+// reveal opens the cluster holding item with item selected.
+func (m *model) reveal(item list.Item) {
+	switch v := item.(type) {
+	case *Phone:
+		m.openCluster(v.ParentCluster, v)
+	case *Cluster:
+		if v.Parent == nil {
+			m.openCluster(v, nil)
+		} else {
+			m.openCluster(v.Parent, v)
+		}
+	}
+}
+
+// breadcrumbs renders the way down to the current cluster, highlighting the
+// picked level while the bar has focus.
+func (m *model) breadcrumbs() string {
+	var crumbs []string
+	for i, f := range append(m.navStack, navFrame{cluster: m.currentCluster}) {
+		name := f.cluster.plainName()
+		if m.crumbFocus && i == m.crumbIndex {
+			name = crumbStyle.Render(name)
+		}
+		crumbs = append(crumbs, name)
+	}
+	s := strings.Join(crumbs, " › ")
+	if m.crumbFocus {
+		s += "  (←/→: pick, enter: go there, esc: back to the list)"
+	}
+	return s
+}
+
+func (m *model) focusCrumbs() {
+	if m.query != nil {
+		return
+	}
+	m.crumbFocus = true
+	m.crumbIndex = max(len(m.navStack)-1, 0)
+	m.updateTitle()
+}
+
+// updateCrumbs handles keys while the breadcrumb bar has focus.
+func (m *model) updateCrumbs(msg tea.KeyMsg) {
+	m.crumbIndex = min(m.crumbIndex, len(m.navStack))
+	switch msg.String() {
+	case "left", "h":
+		if m.crumbIndex > 0 {
+			m.crumbIndex--
+		}
+	case "right", "l":
+		if m.crumbIndex < len(m.navStack) {
+			m.crumbIndex++
+		}
+	case "home":
+		m.crumbIndex = 0
+	case "end":
+		m.crumbIndex = len(m.navStack)
+	case "enter":
+		m.crumbFocus = false
+		m.backTo(m.crumbIndex)
+	case "esc", "tab", "q":
+		m.crumbFocus = false
+	}
+	m.updateTitle()
+}
+
+func (m *model) openGoto() {
+	m.gotoEditing = true
+	m.gotoErr = ""
+	m.gotoMatches = nil
+	m.gotoInput.SetValue("")
+	m.gotoInput.Focus()
+}
+
+// gotoDir resolves the cluster part of a typed path, from the root or from
+// the current cluster.
+func (m *model) gotoDir(dir string) (*Cluster, error) {
+	if c, err := findCluster(m.rootCluster, dir); err == nil {
+		return c, nil
+	}
+	return findCluster(m.rootCluster, m.currentCluster.labelPath()+"/"+dir)
+}
+
+// completeGoto extends the last segment of the typed path as far as the
+// clusters and phones it could name agree.
+func (m *model) completeGoto() {
+	text := m.gotoInput.Value()
+	dir, prefix := "", text
+	var names []string
+	if i := strings.LastIndex(text, "/"); i >= 0 {
+		dir, prefix = text[:i+1], text[i+1:]
+		c, err := m.gotoDir(dir)
+		if err != nil {
+			m.gotoErr = err.Error()
+			return
+		}
+		names = childNames(c)
+	} else {
+		names = append([]string{m.rootCluster.plainName() + "/"}, childNames(m.currentCluster)...)
+	}
+	m.gotoErr = ""
+	m.gotoMatches = nil
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			m.gotoMatches = append(m.gotoMatches, name)
+		}
+	}
+	if len(m.gotoMatches) == 0 {
+		m.gotoErr = "Nothing starts with " + prefix
+		return
+	}
+	m.gotoInput.SetValue(dir + commonPrefix(m.gotoMatches))
+	m.gotoInput.CursorEnd()
+	if len(m.gotoMatches) == 1 {
+		m.gotoMatches = nil
+	}
+}
+
+func childNames(c *Cluster) []string {
+	var names []string
+	for _, child := range c.ChildrenClusters {
+		names = append(names, child.plainName()+"/")
+	}
+	for _, p := range c.ChildrenPhones {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func commonPrefix(names []string) string {
+	prefix := []rune(names[0])
+	for _, name := range names[1:] {
+		r := []rune(name)
+		n := 0
+		for n < len(prefix) && n < len(r) && prefix[n] == r[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
+
+// updateGoto handles keys while a path is being typed.
+func (m *model) updateGoto(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "tab":
+		m.completeGoto()
+		return nil
+	case "enter":
+		text := strings.ReplaceAll(strings.TrimSpace(m.gotoInput.Value()), " > ", "/")
+		text = strings.TrimSuffix(text, "/")
+		if text == "" {
+			m.gotoEditing = false
+			return nil
+		}
+		item, err := findItem(m.rootCluster, text)
+		if err != nil {
+			if relative, rerr := findItem(m.rootCluster, m.currentCluster.labelPath()+"/"+text); rerr == nil {
+				item, err = relative, nil
+			}
+		}
+		if err != nil {
+			m.gotoErr = err.Error()
+			return nil
+		}
+		m.gotoEditing = false
+		switch v := item.(type) {
+		case *Cluster:
+			m.openCluster(v, nil)
+		case *Phone:
+			m.reveal(v)
+		}
+		return nil
+	case "esc":
+		m.gotoEditing = false
+		return nil
+	}
+	var cmd tea.Cmd
+	m.gotoInput, cmd = m.gotoInput.Update(msg)
+	m.gotoMatches = nil
+	return cmd
+}
+
+func (m *model) gotoView() string {
+	s := "Go to a cluster or phone\n\n" + m.gotoInput.View() + "\n"
+	if m.gotoErr != "" {
+		s += "\n" + renderWarning(m.gotoErr) + "\n"
+	}
+	if len(m.gotoMatches) > 0 {
+		s += "\n" + strings.Join(m.gotoMatches, "  ") + "\n"
+	}
+	return s + "\nPaths start at " + m.rootCluster.plainName() + " or at " + m.currentCluster.labelPath() +
+		"; phones can also be given by ID, serial or unique name.\n\ntab: complete • enter: go there • esc: cancel"
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+func navTestModel() (*model, *Cluster) {
+	root := &Cluster{ID: "root", ChildrenClusters: []*Cluster{
+		{ID: "a", Name: "A"},
+		{ID: "b", Name: "B", ChildrenClusters: []*Cluster{
+			{ID: "b1", Name: "B1"},
+			{ID: "b2", Name: "B2", ChildrenClusters: []*Cluster{
+				{ID: "x", Name: "X"},
+				{ID: "y", Name: "Y"},
+			}},
+			{ID: "b3", Name: "B3"},
+		}},
+		{ID: "c", Name: "C"},
+	}}
+	reconstructClusterFromJSON(root)
+	m := &model{list: list.New(nil, itemDelegate{}, 80, 24), rootCluster: root, alerts: newAlertEngine(nil)}
+	m.recreateList(root, 0)
+	return m, root
+}
+
+func TestNavBackSelectsWhereYouCameFrom(t *testing.T) {
+	m, root := navTestModel()
+	b := root.ChildrenClusters[1]
+	b2 := b.ChildrenClusters[1]
+	check := func(step string, current *Cluster, selected list.Item) {
+		t.Helper()
+		if m.currentCluster != current || m.list.SelectedItem() != selected {
+			t.Errorf("%s: in %s with %s selected, want %s with %s", step, m.currentCluster.plainName(), m.list.SelectedItem().FilterValue(), current.plainName(), selected.FilterValue())
+		}
+	}
+
+	m.list.Select(1)
+	m.enterCluster(b)
+	check("into B", b, b.ChildrenClusters[0])
+	m.list.Select(1)
+	m.enterCluster(b2)
+	check("into B2", b2, b2.ChildrenClusters[0])
+	if len(m.navStack) != 2 {
+		t.Errorf("%d frames, want 2", len(m.navStack))
+	}
+
+	m.goBack()
+	check("back once", b, b2)
+	m.goBack()
+	check("back twice", root, b)
+	m.goBack()
+	check("back at the root", root, b)
+
+	// jumping straight down leaves no trail, so back walks the tree instead
+	m.openCluster(b2, b2.ChildrenClusters[1])
+	check("jump to Y", b2, b2.ChildrenClusters[1])
+	m.goBack()
+	check("back from the jump", b, b2)
+	m.goBack()
+	check("back to the root", root, b)
+}
+
+func TestReturnPath(t *testing.T) {
+	_, root := navTestModel()
+	if got := root.returnPath(); got != "Root" {
+		t.Errorf("root path = %q", got)
+	}
+	if got := root.ChildrenClusters[1].ChildrenClusters[1].returnPath(); got != "Root > B > B2" {
+		t.Errorf("B2 path = %q", got)
+	}
+}
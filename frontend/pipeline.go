@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The steps that take a phone from the box to a Ready node, in order.
+const (
+	StageNew       = "new"
+	StageUnlocked  = "unlocked"
+	StageFlashed   = "flashed"
+	StageBooted    = "booted"
+	StageWifi      = "wifi"
+	StageK3s       = "k3s"
+	StageNodeReady = "ready"
+)
+
+var pipelineStages = []string{StageNew, StageUnlocked, StageFlashed, StageBooted, StageWifi, StageK3s, StageNodeReady}
+
+// stuckAfter is how long a phone can sit in one stage before the pipeline
+// view flags it.
+const stuckAfter = 24 * time.Hour
+
+// Pipeline records how far a phone has got through provisioning. Stages
+// only move forward; a failure is kept until the next stage is reached.
+type Pipeline struct {
+	Stage   string               `json:"stage"`
+	Since   time.Time            `json:"since"`
+	Reached map[string]time.Time `json:"reached,omitempty"`
+	Error   string               `json:"error,omitempty"`
+	ErrorAt time.Time            `json:"error_at,omitzero"`
+}
+
+func (p *Pipeline) deepCopy() *Pipeline {
+	if p == nil {
+		return nil
+	}
+	newP := *p
+	newP.Reached = make(map[string]time.Time, len(p.Reached))
+	for k, v := range p.Reached {
+		newP.Reached[k] = v
+	}
+	return &newP
+}
+
+func stageIndex(stage string) int {
+	return slices.Index(pipelineStages, stage)
+}
+
+func (t *Phone) stage() string {
+	if t.Pipeline == nil {
+		return StageNew
+	}
+	return t.Pipeline.Stage
+}
+
+// advance moves the phone to stage if it isn't there or further already,
+// reporting whether anything changed.
+func (t *Phone) advance(stage string, now time.Time) bool {
+	if stageIndex(stage) <= stageIndex(t.stage()) {
+		return false
+	}
+	if t.Pipeline == nil {
+		t.Pipeline = &Pipeline{}
+	}
+	if t.Pipeline.Reached == nil {
+		t.Pipeline.Reached = map[string]time.Time{}
+	}
+	t.Pipeline.Stage = stage
+	t.Pipeline.Since = now
+	t.Pipeline.Reached[stage] = now
+	t.Pipeline.Error = ""
+	t.Pipeline.ErrorAt = time.Time{}
+	return true
+}
+
+// failStage notes that the step towards stage went wrong.
+func (t *Phone) failStage(stage string, err error, now time.Time) {
+	if t.Pipeline == nil {
+		t.Pipeline = &Pipeline{Stage: StageNew, Since: now}
+	}
+	t.Pipeline.Error = stage + ": " + err.Error()
+	t.Pipeline.ErrorAt = now
+}
+
+func (t *Phone) nextStage() string {
+	i := stageIndex(t.stage())
+	if i+1 >= len(pipelineStages) {
+		return ""
+	}
+	return pipelineStages[i+1]
+}
+
+func (t *Phone) pipelineRow(now time.Time) string {
+	s := fmt.Sprintf("%-24.24s", t.Name)
+	current := stageIndex(t.stage())
+	for i, stage := range pipelineStages[1:] {
+		cell := "·"
+		if at, ok := t.Pipeline.reached(stage); ok {
+			cell = "✓ " + at.Format("01-02 15:04")
+		} else if i+1 <= current {
+			cell = "✓"
+		}
+		s += fmt.Sprintf(" %-13s", cell)
+	}
+	if t.Pipeline != nil && t.Pipeline.Error != "" {
+		s += " " + renderWarning(t.Pipeline.Error)
+	} else if t.Pipeline != nil && t.stage() != StageNodeReady && now.Sub(t.Pipeline.Since) > stuckAfter {
+		s += " " + renderWarning(fmt.Sprintf("stuck for %s", now.Sub(t.Pipeline.Since).Round(time.Hour)))
+	}
+	return s
+}
+
+func (p *Pipeline) reached(stage string) (time.Time, bool) {
+	if p == nil {
+		return time.Time{}, false
+	}
+	at, ok := p.Reached[stage]
+	return at, ok
+}
+
+// pipelinePhones lists the phones under the current cluster, least far along
+// first and then longest waiting.
+func (m *model) pipelinePhones() []*Phone {
+	var phones []*Phone
+	m.currentCluster.walkPhones(func(p *Phone) { phones = append(phones, p) })
+	slices.SortStableFunc(phones, func(a, b *Phone) int {
+		if d := stageIndex(a.stage()) - stageIndex(b.stage()); d != 0 {
+			return d
+		}
+		var sa, sb time.Time
+		if a.Pipeline != nil {
+			sa = a.Pipeline.Since
+		}
+		if b.Pipeline != nil {
+			sb = b.Pipeline.Since
+		}
+		return sa.Compare(sb)
+	})
+	return phones
+}
+
+func (m *model) pipelineView() string {
+	now := time.Now()
+	phones := m.pipelinePhones()
+	counts := map[string]int{}
+	for _, p := range phones {
+		counts[p.stage()]++
+	}
+	var summary []string
+	for _, stage := range pipelineStages {
+		summary = append(summary, fmt.Sprintf("%s %d", stage, counts[stage]))
+	}
+	s := "Provisioning pipeline for " + m.currentCluster.Name + "\n" + strings.Join(summary, " • ") + "\n\n"
+	s += fmt.Sprintf("  %-24s", "phone")
+	for _, stage := range pipelineStages[1:] {
+		s += fmt.Sprintf(" %-13s", stage)
+	}
+	s += "\n"
+	for i, p := range phones {
+		prefix := "  "
+		if i == m.pipelineIndex {
+			prefix = "> "
+		}
+		s += prefix + p.pipelineRow(now) + "\n"
+	}
+	return s + "\nup/down: select • +: mark next stage done • esc: back"
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const probeTimeout = 10 * time.Second
+
+// ProbeConfig describes how to collect resource metrics for a cluster or phone.
+// Phones and clusters without one inherit the nearest ancestor's config.
+type ProbeConfig struct {
+	Command   string      `json:"command,omitempty"`
+	SSHTarget string      `json:"ssh_target,omitempty"` // user@host, empty runs the command locally
+	Rules     []ProbeRule `json:"rules,omitempty"`
+}
+
+// ProbeRule pulls a single number out of the probe output. The first capture
+// group of Pattern must match the value.
+type ProbeRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Unit    string `json:"unit,omitempty"`
+}
+
+type ProbeMetric struct {
+	Name  string
+	Value float64
+	Unit  string
+	Found bool
+}
+
+type ProbeResult struct {
+	Target  string
+	At      time.Time
+	Metrics []ProbeMetric
+	Raw     string
+	Err     error
+}
+
+type probeResultMsg ProbeResult
+
+// runProbeCommand is swapped out by tests, which have no shell to run against.
+var runProbeCommand = func(ctx context.Context, cfg *ProbeConfig) ([]byte, error) {
+	var cmd *exec.Cmd
+	if cfg.SSHTarget != "" {
+		cmd = exec.CommandContext(ctx, "ssh", "-T", "-o", "BatchMode=yes", cfg.SSHTarget, cfg.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", cfg.Command)
+	}
+	return cmd.CombinedOutput()
+}
+
+func (c *ProbeConfig) deepCopy() *ProbeConfig {
+	if c == nil {
+		return nil
+	}
+	newC := *c
+	newC.Rules = append([]ProbeRule(nil), c.Rules...)
+	return &newC
+}
+
+func (c *ProbeConfig) target() string {
+	if c.SSHTarget != "" {
+		return c.SSHTarget
+	}
+	return "localhost"
+}
+
+func (i *Cluster) effectiveProbe() *ProbeConfig {
+	for current := i; current != nil; current = current.Parent {
+		if current.Probe != nil {
+			return current.Probe
+		}
+	}
+	return nil
+}
+
+func (t *Phone) effectiveProbe() *ProbeConfig {
+	if t.Probe != nil {
+		return t.Probe
+	}
+	return t.ParentCluster.effectiveProbe()
+}
+
+// parseProbeOutput applies every rule to out. Rules that don't match are kept
+// with Found unset so the view can say so instead of showing a zero.
+func parseProbeOutput(rules []ProbeRule, out string) ([]ProbeMetric, error) {
+	var metrics []ProbeMetric
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("probe rule %q: %w", rule.Name, err)
+		}
+		metric := ProbeMetric{Name: rule.Name, Unit: rule.Unit}
+		if match := re.FindStringSubmatch(out); len(match) > 1 {
+			if v, err := strconv.ParseFloat(match[1], 64); err == nil {
+				metric.Value, metric.Found = v, true
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+func runProbe(cfg *ProbeConfig) ProbeResult {
+	res := ProbeResult{Target: cfg.target(), At: time.Now()}
+	if strings.TrimSpace(cfg.Command) == "" {
+		res.Err = errors.New("probe has no command")
+		return res
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	out, err := runProbeCommand(ctx, cfg)
+	res.Raw = strings.TrimSpace(string(out))
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Metrics, res.Err = parseProbeOutput(cfg.Rules, res.Raw)
+	return res
+}
+
+func probeCmd(cfg *ProbeConfig) tea.Cmd {
+	return func() tea.Msg {
+		return probeResultMsg(runProbe(cfg))
+	}
+}
+
+func (r ProbeResult) print() string {
+	s := fmt.Sprintf("Probe %s at %s\n", r.Target, r.At.Format(time.TimeOnly))
+	if r.Err != nil {
+		s += renderWarning(fmt.Sprintf("Error: %v", r.Err)) + "\n"
+		if r.Raw != "" {
+			s += r.Raw + "\n"
+		}
+		return s
+	}
+	if len(r.Metrics) == 0 {
+		return s + r.Raw + "\n"
+	}
+	for _, metric := range r.Metrics {
+		if !metric.Found {
+			s += fmt.Sprintf("\t%s: %s\n", metric.Name, renderWarning("no match"))
+			continue
+		}
+		s += fmt.Sprintf("\t%s: %.2f %s\n", metric.Name, metric.Value, metric.Unit)
+	}
+	return s
+}
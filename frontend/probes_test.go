@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func stubProbeCommand(t *testing.T, out string, err error) {
+	t.Helper()
+	old := runProbeCommand
+	runProbeCommand = func(ctx context.Context, cfg *ProbeConfig) ([]byte, error) {
+		return []byte(out), err
+	}
+	t.Cleanup(func() { runProbeCommand = old })
+}
+
+func TestRunProbe(t *testing.T) {
+	stubProbeCommand(t, "MemAvailable: 2048 kB\nload 0.75\n", nil)
+	res := runProbe(&ProbeConfig{
+		Command:   "cat /proc/meminfo",
+		SSHTarget: "pi@shelf",
+		Rules: []ProbeRule{
+			{Name: "mem", Pattern: `MemAvailable:\s+(\d+)`, Unit: "kB"},
+			{Name: "load", Pattern: `load ([\d.]+)`},
+			{Name: "temp", Pattern: `temp (\d+)`},
+		},
+	})
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if res.Target != "pi@shelf" {
+		t.Errorf("target = %q", res.Target)
+	}
+	want := []ProbeMetric{
+		{Name: "mem", Value: 2048, Unit: "kB", Found: true},
+		{Name: "load", Value: 0.75, Found: true},
+		{Name: "temp"},
+	}
+	if len(res.Metrics) != len(want) {
+		t.Fatalf("metrics = %+v", res.Metrics)
+	}
+	for i := range want {
+		if res.Metrics[i] != want[i] {
+			t.Errorf("metric %d = %+v, want %+v", i, res.Metrics[i], want[i])
+		}
+	}
+}
+
+func TestRunProbeErrors(t *testing.T) {
+	stubProbeCommand(t, "ssh: connect to host shelf: Connection refused", errors.New("exit status 255"))
+	res := runProbe(&ProbeConfig{Command: "uptime"})
+	if res.Err == nil || res.Raw != "ssh: connect to host shelf: Connection refused" {
+		t.Errorf("failed command: err %v, raw %q", res.Err, res.Raw)
+	}
+
+	if res := runProbe(&ProbeConfig{Command: " "}); res.Err == nil {
+		t.Error("empty command ran")
+	}
+
+	stubProbeCommand(t, "1", nil)
+	if res := runProbe(&ProbeConfig{Command: "x", Rules: []ProbeRule{{Name: "bad", Pattern: "("}}}); res.Err == nil {
+		t.Error("bad pattern accepted")
+	}
+}
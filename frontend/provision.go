@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// UnlockRecord is the outcome of the last bootloader unlock attempt.
+type UnlockRecord struct {
+	Result string    `json:"result"` // "unlocked", "failed", "declined" or "unsupported"
+	Vendor string    `json:"vendor,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+func (u *UnlockRecord) deepCopy() *UnlockRecord {
+	if u == nil {
+		return nil
+	}
+	newU := *u
+	return &newU
+}
+
+func (u *UnlockRecord) print() string {
+	s := fmt.Sprintf("bootloader: %s at %s", u.Result, u.At.Format(time.DateTime))
+	if u.Error != "" {
+		s += renderWarning(" (" + u.Error + ")")
+	}
+	return s
+}
+
+type unlocker struct {
+	tools   DeviceTools
+	in      *bufio.Reader
+	out     io.Writer
+	vendor  string // forced vendor, for devices already in fastboot
+	timeout time.Duration
+}
+
+// confirm makes the operator type the serial back before anything is wiped.
+func (u *unlocker) confirm(d AndroidDevice, v Vendor) bool {
+	fmt.Fprintf(u.out, "Unlocking %s (%s %s) wipes all data on it.\nType the serial to continue, anything else skips: ", d.Serial, v.Name, d.Model)
+	line, err := u.in.ReadString('\n')
+	if err != nil && line == "" {
+		return false
+	}
+	return strings.TrimSpace(line) == d.Serial
+}
+
+// askCode asks for the vendor's unlock code for the device, empty if the
+// operator has none.
+func (u *unlocker) askCode(d AndroidDevice, v Vendor) string {
+	fmt.Fprintf(u.out, "%s needs an unlock code for %s (%s).\nUnlock code, empty skips: ", v.Name, d.Serial, v.Note)
+	line, _ := u.in.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// check looks the device's vendor up, returning a finished record when it
+// can't be unlocked at all.
+func (u *unlocker) check(d AndroidDevice) (Vendor, *UnlockRecord) {
+	manufacturer := d.Manufacturer
+	if u.vendor != "" {
+		manufacturer = u.vendor
+	}
+	v, ok := findVendor(manufacturer)
+	if !ok {
+		record := &UnlockRecord{Result: "unsupported", Error: fmt.Sprintf("unknown vendor %q", manufacturer), At: time.Now()}
+		if manufacturer == "" {
+			record.Error = "vendor unknown, pass -vendor"
+		}
+		return v, record
+	}
+	if !v.canUnlock() {
+		return v, &UnlockRecord{Result: "unsupported", Vendor: v.Name, Error: v.Note, At: time.Now()}
+	}
+	return v, nil
+}
+
+// prepare identifies the device and asks for everything its unlock needs,
+// so the unlocks can then run unattended. Devices that won't be unlocked
+// come back with their record already set.
+func (u *unlocker) prepare(ctx context.Context, d AndroidDevice) *unlockJob {
+	identify(ctx, u.tools, &d)
+	fmt.Fprintf(u.out, "%s\t%s\t%s %s\n", d.Serial, d.Mode, d.Manufacturer, d.Model)
+	v, record := u.check(d)
+	job := &unlockJob{device: d, vendor: v, record: record}
+	switch {
+	case record != nil:
+	case !u.confirm(d, v):
+		job.record = &UnlockRecord{Result: "declined", Vendor: v.Name, At: time.Now()}
+	case v.NeedsCode:
+		if job.code = u.askCode(d, v); job.code == "" {
+			job.record = &UnlockRecord{Result: "declined", Vendor: v.Name, Error: "no unlock code", At: time.Now()}
+		}
+	}
+	return job
+}
+
+// unlockSteps is how many times run calls step for a phone booted into
+// Android, for progress bars.
+const unlockSteps = 4
+
+// run unlocks a device that has already been confirmed, reporting each step.
+func (u *unlocker) run(ctx context.Context, tools DeviceTools, d AndroidDevice, v Vendor, code string, step func(string)) UnlockRecord {
+	record := UnlockRecord{Vendor: v.Name}
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+	fail := func(err error) UnlockRecord {
+		record.Result = "failed"
+		record.Error = err.Error()
+		record.At = time.Now()
+		return record
+	}
+	if d.Mode == "adb" {
+		step("checking OEM unlock setting")
+		if allowed, _ := tools.Getprop(ctx, d.Serial, "sys.oem_unlock_allowed"); allowed == "0" {
+			return fail(fmt.Errorf("OEM unlocking is disabled in developer options"))
+		}
+		step("rebooting into the bootloader")
+		if err := tools.RebootBootloader(ctx, d.Serial); err != nil {
+			return fail(err)
+		}
+		step("waiting for fastboot")
+		if err := waitForFastboot(ctx, tools, d.Serial); err != nil {
+			return fail(err)
+		}
+	}
+	step("unlocking, confirm on the phone with the volume and power keys")
+	if out, err := tools.Fastboot(ctx, d.Serial, v.unlockArgs(code)...); err != nil {
+		return fail(err)
+	} else if strings.Contains(strings.ToUpper(out), "FAILED") {
+		return fail(fmt.Errorf("fastboot: %s", strings.TrimSpace(out)))
+	}
+	record.Result = "unlocked"
+	record.At = time.Now()
+	return record
+}
+
+// recordUnlock stores the result on the phone with the same serial.
+func recordUnlock(root *Cluster, serial string, record UnlockRecord) *Phone {
+	var found *Phone
+	root.walkPhones(func(p *Phone) {
+		if found == nil && p.Serial == serial {
+			found = p
+		}
+	})
+	if found != nil {
+		found.Unlock = &record
+		switch record.Result {
+		case "unlocked":
+			found.advance(StageUnlocked, record.At)
+		case "failed":
+			found.failStage(StageUnlocked, errors.New(record.Error), record.At)
+		}
+	}
+	return found
+}
+
+func provisionUsage() {
+	fmt.Fprintln(os.Stderr, `usage: frontend [-c config] provision unlock [-adb path] [-fastboot path] [-serial serial] [-vendor name] [-workers n] [-log-dir dir]
+       frontend [-c config] provision wifi-add [-cluster path] -ssid name [-key file]
+       frontend [-c config] provision wifi -phone ref [-via adb|ssh] [-adb path] [-ssh path] [-key file]`)
+}
+
+func runProvision(args []string) int {
+	if len(args) == 0 {
+		provisionUsage()
+		return 2
+	}
+	switch args[0] {
+	case "unlock":
+		return runUnlock(args[1:])
+	case "wifi":
+		return runWifi(args[1:])
+	case "wifi-add":
+		return runWifiAdd(args[1:])
+	}
+	provisionUsage()
+	return 2
+}
+
+func runUnlock(args []string) int {
+	fs := flag.NewFlagSet("provision unlock", flag.ExitOnError)
+	adb := fs.String("adb", "adb", "adb binary")
+	fastboot := fs.String("fastboot", "fastboot", "fastboot binary")
+	only := fs.String("serial", "", "only unlock this serial")
+	vendor := fs.String("vendor", "", "vendor to assume, for devices already in fastboot mode")
+	timeout := fs.Duration("timeout", 2*time.Minute, "time allowed per device")
+	workers := fs.Int("workers", 4, "devices to unlock at the same time")
+	logDir := fs.String("log-dir", "provision-logs", "directory for per-device logs")
+	sysfs := fs.String("usb-sysfs", usbSysfs, "sysfs USB devices directory")
+	fs.Parse(args)
+
+	u := &unlocker{
+		tools:   newExecTools(*adb, *fastboot),
+		in:      bufio.NewReader(os.Stdin),
+		out:     os.Stdout,
+		vendor:  *vendor,
+		timeout: *timeout,
+	}
+	ctx := context.Background()
+	devices, err := u.tools.Devices(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "listing devices: %v\n", err)
+		return 1
+	}
+	if usb, err := enumerateUSB(*sysfs); err == nil {
+		for _, hint := range usbHints(usb) {
+			fmt.Fprintln(os.Stderr, hint)
+		}
+	}
+	if len(devices) == 0 {
+		fmt.Fprintln(os.Stderr, "no devices found; check that USB debugging is enabled")
+		return 1
+	}
+
+	// Confirm everything up front so the unlocks can then run unattended.
+	var jobs []*unlockJob
+	for _, d := range devices {
+		if *only != "" && d.Serial != *only {
+			continue
+		}
+		jobs = append(jobs, u.prepare(ctx, d))
+	}
+	if len(jobs) == 0 {
+		fmt.Fprintf(os.Stderr, "no device with serial %s found\n", *only)
+		return 1
+	}
+
+	// an interrupt stops the run; what finished before it is still recorded
+	runCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	runErr := runUnlockJobs(runCtx, u, jobs, *workers, *logDir)
+	stop()
+	fmt.Print(unlockSummary(jobs))
+
+	status := 0
+	if runErr != nil {
+		fmt.Fprintln(os.Stderr, runErr)
+		status = 1
+	}
+	err = updateConfig(func(root *Cluster) error {
+		for _, job := range jobs {
+			if job.record == nil {
+				continue
+			}
+			if job.record.Result == "failed" {
+				status = 1
+			}
+			if p := recordUnlock(root, job.device.Serial, *job.record); p == nil {
+				fmt.Printf("no phone with serial %s in %s, result not recorded\n", job.device.Serial, config_path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "results not recorded:", err)
+		return 1
+	}
+	return status
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The stubs keep their state in files next to them: adb-devices and
+// fastboot-devices list devices, prop-<name> holds a getprop value and every
+// call is appended to calls. Rebooting into the bootloader moves the device
+// to fastboot-devices; a fastboot-fails file makes fastboot refuse.
+const stubADB = `#!/bin/sh
+dir=$(dirname "$0")
+echo "adb $*" >> "$dir/calls"
+if [ "$1" = devices ]; then
+	echo "List of devices attached"
+	cat "$dir/adb-devices" 2>/dev/null
+	exit 0
+fi
+serial=$2
+shift 2
+case "$1 $2" in
+"shell getprop") cat "$dir/prop-$3" 2>/dev/null; exit 0 ;;
+"reboot bootloader") printf '%s\tfastboot\n' "$serial" >> "$dir/fastboot-devices"; exit 0 ;;
+esac
+echo "unknown command $*"
+exit 1
+`
+
+const stubFastboot = `#!/bin/sh
+dir=$(dirname "$0")
+echo "fastboot $*" >> "$dir/calls"
+if [ "$1" = devices ]; then
+	cat "$dir/fastboot-devices" 2>/dev/null
+	exit 0
+fi
+if [ -f "$dir/fastboot-fails" ]; then
+	cat "$dir/fastboot-fails"
+	exit 1
+fi
+echo "OKAY [  0.040s]"
+`
+
+type stubDevices struct {
+	dir   string
+	tools *execTools
+}
+
+func newStubDevices(t *testing.T, files map[string]string) *stubDevices {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("stub adb and fastboot are shell scripts")
+	}
+	dir := t.TempDir()
+	for name, content := range map[string]string{"adb": stubADB, "fastboot": stubFastboot} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &stubDevices{dir, newExecTools(filepath.Join(dir, "adb"), filepath.Join(dir, "fastboot"))}
+}
+
+func (s *stubDevices) calls() string {
+	b, _ := os.ReadFile(filepath.Join(s.dir, "calls"))
+	return string(b)
+}
+
+func phoneProps(manufacturer, oemUnlock string) map[string]string {
+	return map[string]string{
+		"adb-devices":                  "S1\tdevice\n",
+		"prop-ro.product.manufacturer": manufacturer + "\n",
+		"prop-ro.product.model":        "Phone\n",
+		"prop-sys.oem_unlock_allowed":  oemUnlock + "\n",
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		files  map[string]string
+		input  string
+		result string
+		err    string
+		call   string // a call the stubs must have seen
+	}{
+		{name: "unlocked", files: phoneProps("Google", "1"), input: "S1\n", result: "unlocked", call: "fastboot -s S1 flashing unlock"},
+		{name: "declined", files: phoneProps("Google", "1"), input: "S2\n", result: "declined"},
+		{name: "unsupported", files: phoneProps("samsung", "1"), result: "unsupported", err: "download mode"},
+		{name: "unknown vendor", files: phoneProps("Acme", "1"), result: "unsupported", err: `unknown vendor "Acme"`},
+		{name: "oem unlock off", files: phoneProps("Google", "0"), input: "S1\n", result: "failed", err: "OEM unlocking is disabled"},
+		{name: "fastboot refuses", files: func() map[string]string {
+			files := phoneProps("OnePlus", "1")
+			files["fastboot-fails"] = "FAILED (remote: 'unlock is not allowed')\n"
+			return files
+		}(), input: "S1\n", result: "failed", err: "unlock is not allowed"},
+		{name: "motorola with code", files: phoneProps("motorola", "1"), input: "S1\nABC123\n", result: "unlocked", call: "fastboot -s S1 oem unlock ABC123"},
+		{name: "motorola without code", files: phoneProps("motorola", "1"), input: "S1\n\n", result: "declined", err: "no unlock code"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStubDevices(t, tt.files)
+			u := &unlocker{tools: stub.tools, in: bufio.NewReader(strings.NewReader(tt.input)), out: io.Discard, timeout: 10 * time.Second}
+			ctx := context.Background()
+			devices, err := u.tools.Devices(ctx)
+			if err != nil || len(devices) != 1 {
+				t.Fatalf("devices = %+v, %v", devices, err)
+			}
+			job := u.prepare(ctx, devices[0])
+			if job.record == nil {
+				record := u.run(ctx, u.tools, job.device, job.vendor, job.code, func(string) {})
+				job.record = &record
+			}
+			if job.record.Result != tt.result || !strings.Contains(job.record.Error, tt.err) {
+				t.Errorf("got %s %q, want %s %q", job.record.Result, job.record.Error, tt.result, tt.err)
+			}
+			calls := stub.calls()
+			if tt.call != "" && !strings.Contains(calls, tt.call) {
+				t.Errorf("no %q in calls:\n%s", tt.call, calls)
+			}
+			if tt.result != "unlocked" && tt.result != "failed" && strings.Contains(calls, "unlock") {
+				t.Errorf("tried to unlock:\n%s", calls)
+			}
+		})
+	}
+}
+
+func TestUnlockUnknownSerial(t *testing.T) {
+	stub := newStubDevices(t, phoneProps("Google", "1"))
+	dir := t.TempDir()
+	old := config_path
+	config_path = filepath.Join(dir, "config.json")
+	t.Cleanup(func() { config_path = old })
+	const config = `{"children_phones":[{"id":"p1","Name":"p1","serial":"S1"}]}`
+	os.WriteFile(config_path, []byte(config), 0644)
+
+	status := runUnlock([]string{"-adb", stub.tools.ADBPath, "-fastboot", stub.tools.FastbootPath, "-serial", "NOPE", "-usb-sysfs", dir, "-log-dir", filepath.Join(dir, "logs")})
+	if status == 0 {
+		t.Error("exit status 0 for a serial that isn't connected")
+	}
+	if b, _ := os.ReadFile(config_path); string(b) != config {
+		t.Errorf("config rewritten:\n%s", b)
+	}
+}
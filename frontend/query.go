@@ -0,0 +1,396 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"math"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A phone query is a list of terms that must all hold, for example
+//
+//	Root/Lab ram>=6 cores>=8 #gpu battery<20 !status=offline
+//
+// A term without an operator is a path glob: it selects the phones under
+// every matching cluster, "*" matches one path segment and "**" any number.
+// A glob that doesn't start at the root matches anywhere in the tree. Other
+// terms compare a field with = != < <= > >= or ~ (contains); = and != take
+// globs and comma-separated alternatives. "#x" is short for tag=x, and "!"
+// or "not" negates a term.
+type phoneQuery struct {
+	text  string
+	terms []queryTerm
+}
+
+type queryTerm struct {
+	negate bool
+	glob   []string // path segments, for path terms
+	field  *queryField
+	op     string
+	values []string
+	number float64
+}
+
+type queryField struct {
+	name   string
+	live   bool // only known to a running frontend
+	text   func(p *Phone) []string
+	number func(p *Phone, now time.Time) float64
+}
+
+func textField(name string, fn func(p *Phone) string) queryField {
+	return queryField{name: name, text: func(p *Phone) []string { return []string{fn(p)} }}
+}
+
+func hardwareField(name string, fn func(p *Phone) string) queryField {
+	return queryField{name: name, number: func(p *Phone, now time.Time) float64 { return firstNumber(fn(p)) }}
+}
+
+func metricField(name, metric string) queryField {
+	return queryField{name: name, live: true, number: func(p *Phone, now time.Time) float64 { return metricValue(p, metric, now) }}
+}
+
+var queryFields = []queryField{
+	textField("name", func(p *Phone) string { return p.Name }),
+	textField("desc", func(p *Phone) string { return p.Desc }),
+	textField("id", func(p *Phone) string { return p.ID }),
+	textField("serial", func(p *Phone) string { return p.Serial }),
+	textField("address", func(p *Phone) string { return p.Address }),
+	textField("hostname", func(p *Phone) string { return p.Hostname }),
+	textField("check", func(p *Phone) string { return p.healthCheck() }),
+	textField("role", func(p *Phone) string { return p.role() }),
+	textField("stage", func(p *Phone) string { return p.stage() }),
+	textField("cluster", func(p *Phone) string { return p.ParentCluster.plainName() }),
+	textField("kernel", func(p *Phone) string { return p.Firmware.kernel() }),
+	textField("os", func(p *Phone) string {
+		if p.Firmware == nil {
+			return ""
+		}
+		return p.Firmware.OSImage
+	}),
+	textField("model", func(p *Phone) string {
+		if p.Firmware == nil {
+			return ""
+		}
+		return p.Firmware.Model
+	}),
+	{name: "tag", text: func(p *Phone) []string { return p.Tags }},
+	{name: "status", live: true, text: func(p *Phone) []string {
+		if p.Health.Status == "" {
+			return []string{string(HealthUnknown)}
+		}
+		return []string{string(p.Health.Status)}
+	}},
+	hardwareField("ram", func(p *Phone) string { return p.RAM }),
+	hardwareField("cores", func(p *Phone) string { return p.CPU }),
+	hardwareField("ghz", func(p *Phone) string { return p.CPUSpeed }),
+	metricField("cpu%", "cpu"),
+	metricField("ram%", "ram"),
+	metricField("temp", "temp"),
+	metricField("battery", "battery"),
+	metricField("offline", "offline_seconds"),
+}
+
+func queryFieldNames() string {
+	var names []string
+	for _, f := range queryFields {
+		names = append(names, f.name)
+	}
+	return strings.Join(names, " ")
+}
+
+// queryWords splits on spaces outside double quotes.
+func queryWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	quoted, inWord := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case r == ' ' && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func parseQuery(s string) (*phoneQuery, error) {
+	words, err := queryWords(s)
+	if err != nil {
+		return nil, err
+	}
+	q := &phoneQuery{text: strings.TrimSpace(s)}
+	negate := false
+	for _, word := range words {
+		switch strings.ToLower(word) {
+		case "and":
+			continue
+		case "not", "!":
+			negate = !negate
+			continue
+		}
+		if rest, ok := strings.CutPrefix(word, "!"); ok && !strings.HasPrefix(rest, "=") {
+			negate, word = !negate, rest
+		}
+		term, err := parseTerm(word)
+		if err != nil {
+			return nil, err
+		}
+		term.negate = negate
+		negate = false
+		q.terms = append(q.terms, term)
+	}
+	if negate {
+		return nil, errors.New("nothing after not")
+	}
+	return q, nil
+}
+
+func parseTerm(word string) (queryTerm, error) {
+	if tag, ok := strings.CutPrefix(word, "#"); ok {
+		word = "tag=" + tag
+	}
+	i := strings.IndexAny(word, "=<>!~")
+	if i < 0 {
+		if _, err := path.Match(word, ""); err != nil {
+			return queryTerm{}, fmt.Errorf("bad path glob %q", word)
+		}
+		return queryTerm{glob: strings.Split(strings.Trim(word, "/"), "/")}, nil
+	}
+	name, rest := strings.ToLower(word[:i]), word[i:]
+	op := rest[:1]
+	if len(rest) > 1 && rest[1] == '=' && op != "=" && op != "~" {
+		op += "="
+	}
+	if op == "!" {
+		return queryTerm{}, fmt.Errorf("%q: did you mean !=?", word)
+	}
+	value := rest[len(op):]
+	if value == "" {
+		return queryTerm{}, fmt.Errorf("%q: missing value", word)
+	}
+	term := queryTerm{op: op}
+	for i := range queryFields {
+		if queryFields[i].name == name {
+			term.field = &queryFields[i]
+		}
+	}
+	if term.field == nil {
+		return queryTerm{}, fmt.Errorf("unknown field %q, try one of: %s", name, queryFieldNames())
+	}
+	if term.field.number != nil {
+		if op == "~" {
+			return queryTerm{}, fmt.Errorf("%s is a number, compare it with = != < <= > >=", name)
+		}
+		term.number = firstNumber(value)
+		if math.IsNaN(term.number) {
+			return queryTerm{}, fmt.Errorf("%s wants a number, not %q", name, value)
+		}
+		return term, nil
+	}
+	if op != "=" && op != "!=" && op != "~" {
+		return queryTerm{}, fmt.Errorf("%s is text, compare it with = != or ~", name)
+	}
+	term.values = strings.Split(strings.ToLower(value), ",")
+	return term, nil
+}
+
+// live reports whether the query needs health or metrics, which only a
+// running frontend has.
+func (q *phoneQuery) live() bool {
+	return slices.ContainsFunc(q.terms, func(t queryTerm) bool { return t.field != nil && t.field.live })
+}
+
+func (q *phoneQuery) match(p *Phone, now time.Time) bool {
+	for _, t := range q.terms {
+		if t.match(p, now) == t.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (t queryTerm) match(p *Phone, now time.Time) bool {
+	switch {
+	case t.glob != nil:
+		return globMatch(t.glob, strings.Split(p.labelPath(), "/"))
+	case t.field.number != nil:
+		v := t.field.number(p, now)
+		switch t.op {
+		case "=":
+			return v == t.number
+		case "!=":
+			return v != t.number
+		case "<":
+			return v < t.number
+		case "<=":
+			return v <= t.number
+		case ">":
+			return v > t.number
+		case ">=":
+			return v >= t.number
+		}
+		return false
+	}
+	found := false
+	for _, have := range t.field.text(p) {
+		have = strings.ToLower(have)
+		for _, want := range t.values {
+			if t.op == "~" {
+				found = found || strings.Contains(have, want)
+			} else if ok, _ := path.Match(want, have); ok {
+				found = true
+			}
+		}
+	}
+	return found == (t.op != "!=")
+}
+
+// globMatch reports whether glob matches segs or a cluster above it. A glob
+// that doesn't start with the root's name may start anywhere, so "*/ShelfA"
+// finds every ShelfA one level below some cluster.
+func globMatch(glob, segs []string) bool {
+	if glob[0] != segs[0] && glob[0] != "**" {
+		glob = append([]string{"**"}, glob...)
+	}
+	var match func(glob, segs []string) bool
+	match = func(glob, segs []string) bool {
+		switch {
+		case len(glob) == 0:
+			// a cluster matched, everything below it is selected
+			return true
+		case glob[0] == "**":
+			for i := 0; i <= len(segs); i++ {
+				if match(glob[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		case len(segs) == 0:
+			return false
+		}
+		ok, _ := path.Match(glob[0], segs[0])
+		return ok && match(glob[1:], segs[1:])
+	}
+	return match(glob, segs)
+}
+
+// jobClusters are the clusters holding phones, which is where jobs run, so
+// a query's jobs are theirs.
+func jobClusters(phones []*Phone) []*Cluster {
+	var clusters []*Cluster
+	for _, p := range phones {
+		if !slices.Contains(clusters, p.ParentCluster) {
+			clusters = append(clusters, p.ParentCluster)
+		}
+	}
+	return clusters
+}
+
+// controlJobs starts, stops or restarts the selected cluster's job, or while
+// a query is shown, the jobs of the clusters its phones are in.
+func (m *model) controlJobs(action, done string) {
+	if m.query == nil {
+		if c, ok := m.list.SelectedItem().(*Cluster); ok {
+			m.sim.controlJob(c, action)
+			m.statusString = fmt.Sprintf("%s job for cluster %s", done, c.Name)
+		}
+		return
+	}
+	var phones []*Phone
+	for _, item := range m.list.Items() {
+		if p, ok := item.(*Phone); ok {
+			phones = append(phones, p)
+		}
+	}
+	clusters := jobClusters(phones)
+	for _, c := range clusters {
+		m.sim.controlJob(c, action)
+	}
+	m.statusString = fmt.Sprintf("%s jobs for the %d clusters holding the matched phones", done, len(clusters))
+}
+
+func (q *phoneQuery) selectPhones(root *Cluster, now time.Time) []*Phone {
+	var phones []*Phone
+	root.walkPhones(func(p *Phone) {
+		if q.match(p, now) {
+			phones = append(phones, p)
+		}
+	})
+	return phones
+}
+
+func (m *model) openQueryPrompt() {
+	m.queryEditing = true
+	m.queryErr = ""
+	if m.query != nil {
+		m.queryInput.SetValue(m.query.text)
+	}
+	m.queryInput.CursorEnd()
+	m.queryInput.Focus()
+}
+
+// updateQueryPrompt handles keys while a query is being typed.
+func (m *model) updateQueryPrompt(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "enter":
+		text := strings.TrimSpace(m.queryInput.Value())
+		if text == "" {
+			m.queryEditing = false
+			m.setQuery(nil)
+			return nil
+		}
+		q, err := parseQuery(text)
+		if err != nil {
+			m.queryErr = err.Error()
+			return nil
+		}
+		m.queryEditing = false
+		m.setQuery(q)
+		return nil
+	case "esc":
+		m.queryEditing = false
+		return nil
+	}
+	var cmd tea.Cmd
+	m.queryInput, cmd = m.queryInput.Update(msg)
+	return cmd
+}
+
+// setQuery shows the phones q selects from the whole tree in place of the
+// current cluster, or the cluster again when q is nil.
+func (m *model) setQuery(q *phoneQuery) {
+	m.query = q
+	m.list.ResetFilter()
+	m.recreateList(m.currentCluster, 0)
+}
+
+func (m *model) queryView() string {
+	s := "Select phones across the whole tree\n\n" + m.queryInput.View() + "\n"
+	if m.queryErr != "" {
+		s += "\n" + renderWarning(m.queryErr) + "\n"
+	}
+	return s + "\nA path glob selects everything under the clusters it matches: Root/Lab, */ShelfA, Lab.\n" +
+		"Compare fields with = != < <= > >= ~, e.g. ram>=6 cores>=8 kernel=6.1* status=online,degraded battery<20.\n" +
+		"#gpu is short for tag=gpu, ! or not negates a term.\n\nFields: " + queryFieldNames() +
+		"\n\nenter: apply (empty to clear) • esc: cancel"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func queryTestTree() *Cluster {
+	root := &Cluster{ID: "root", ChildrenClusters: []*Cluster{
+		{ID: "lab", Name: "Lab", ChildrenClusters: []*Cluster{
+			{ID: "lab-a", Name: "ShelfA", ChildrenPhones: []*Phone{
+				{ID: "a1", Name: "a1", RAM: "8GB", CPU: "8", Desc: "top shelf", Tags: []string{"gpu"}},
+				{ID: "a2", Name: "a2", RAM: "6GB", CPU: "4"},
+			}},
+			// b1's hardware was never filled in
+			{ID: "lab-b", Name: "ShelfB", ChildrenPhones: []*Phone{{ID: "b1", Name: "b1"}}},
+		}},
+		{ID: "office", Name: "Office", ChildrenClusters: []*Cluster{
+			{ID: "office-a", Name: "ShelfA", ChildrenPhones: []*Phone{
+				{ID: "o1", Name: "o1", RAM: "4GB", CPU: "8", Tags: []string{"gpu"}},
+			}},
+		}},
+	}}
+	reconstructClusterFromJSON(root)
+	return root
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, tt := range []struct{ query, err string }{
+		{"!", "nothing after not"},
+		{"ram>=6 not", "nothing after not"},
+		{"ram>=", "missing value"},
+		{"name=", "missing value"},
+		{"colour=red", `unknown field "colour"`},
+		{"ram~6", "ram is a number"},
+		{"ram>=lots", "ram wants a number"},
+		{"name<a", "name is text"},
+		{"ram!6", "did you mean !=?"},
+		{`name="pixel 7`, "unterminated quote"},
+		{"Lab/[", "bad path glob"},
+	} {
+		if _, err := parseQuery(tt.query); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: err = %v, want %q", tt.query, err, tt.err)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	root := queryTestTree()
+	for _, tt := range []struct{ query, want string }{
+		{"Root/Lab", "a1 a2 b1"},
+		{"Root/Lab/ShelfA", "a1 a2"},
+		{"*/ShelfA", "a1 a2 o1"},
+		{"Lab", "a1 a2 b1"},
+		{"ShelfA", "a1 a2 o1"},
+		{"Root/ShelfA", ""},
+		{"**", "a1 a2 b1 o1"},
+		{"Root/**/o1", "o1"},
+		{"#gpu", "a1 o1"},
+		{"!#gpu", "a2 b1"},
+		{"not #gpu", "a2 b1"},
+		{"! #gpu", "a2 b1"},
+		{"not not #gpu", "a1 o1"},
+		{"Lab and not #gpu", "a2 b1"},
+		{"ram>=6", "a1 a2"},
+		{"ram=6", "a2"},
+		// b1 has no RAM, so it isn't 6 either
+		{"ram!=6", "a1 b1 o1"},
+		{"!ram=6", "a1 b1 o1"},
+		{"cores<8", "a2"},
+		{"!cores<8", "a1 b1 o1"},
+		{"ram>=6 cores>=8", "a1"},
+		{"name=a1,o1", "a1 o1"},
+		{"name=a*", "a1 a2"},
+		{"name!=a*", "b1 o1"},
+		{"!name=a*", "b1 o1"},
+		{"name~1", "a1 b1 o1"},
+		{`desc~"top sh"`, "a1"},
+		{"cluster=shelfa", "a1 a2 o1"},
+	} {
+		q, err := parseQuery(tt.query)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		var names []string
+		for _, p := range q.selectPhones(root, time.Now()) {
+			names = append(names, p.Name)
+		}
+		if got := strings.Join(names, " "); got != tt.want {
+			t.Errorf("%s selected %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
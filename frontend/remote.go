@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const userHeader = "X-Powercluster-User"
+
+// remoteClient talks to a frontend running `serve`, for a TUI that edits
+// the daemon's tree instead of the config file.
+type remoteClient struct {
+	base   string
+	token  string
+	user   string
+	client *http.Client
+	stream *http.Client // for the event stream, which stays open
+}
+
+// remoteTimeout bounds a call to the daemon. Saving waits for one in Update,
+// so a daemon that stopped answering would freeze the TUI otherwise.
+const remoteTimeout = 10 * time.Second
+
+func newRemoteClient(base, token, user string) *remoteClient {
+	return &remoteClient{
+		base:   strings.TrimRight(base, "/"),
+		token:  token,
+		user:   user,
+		client: &http.Client{Timeout: remoteTimeout},
+		stream: &http.Client{},
+	}
+}
+
+func (c *remoteClient) request(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set(userHeader, c.user)
+	return req, nil
+}
+
+func remoteError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if body.Error == "" {
+		body.Error = resp.Status
+	}
+	return fmt.Errorf("daemon: %s", body.Error)
+}
+
+// fetchConfig loads the daemon's tree, ready to use like loadConfig's.
+func (c *remoteClient) fetchConfig() (*Cluster, string, error) {
+	req, err := c.request(http.MethodGet, "/api/config", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("reaching daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", remoteError(resp)
+	}
+	var root Cluster
+	if err := json.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return nil, "", fmt.Errorf("daemon: %w", err)
+	}
+	reconstructClusterFromJSON(&root)
+	return &root, resp.Header.Get("ETag"), nil
+}
+
+// putConfig replaces the daemon's tree if it's still at etag.
+func (c *remoteClient) putConfig(root *Cluster, etag string) (string, error) {
+	b, err := json.Marshal(root)
+	if err != nil {
+		return "", err
+	}
+	req, err := c.request(http.MethodPut, "/api/config", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("If-Match", etag)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("reaching daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("ETag"), nil
+	case http.StatusPreconditionFailed:
+		return "", errConflict
+	}
+	return "", remoteError(resp)
+}
+
+// queryPhones asks the daemon which phones a query selects, with their
+// health and metrics taken into account.
+func (c *remoteClient) queryPhones(expr string) ([]phoneJSON, error) {
+	req, err := c.request(http.MethodGet, "/api/phones?q="+url.QueryEscape(expr), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reaching daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, remoteError(resp)
+	}
+	var phones []phoneJSON
+	if err := json.NewDecoder(resp.Body).Decode(&phones); err != nil {
+		return nil, fmt.Errorf("daemon: %w", err)
+	}
+	return phones, nil
+}
+
+// controlJobs starts, stops or restarts the job of the cluster at path, or
+// with a query, the jobs of the clusters holding its phones.
+func (c *remoteClient) controlJobs(path, query, action string) ([]jobJSON, error) {
+	target := "/api/jobs/" + (&url.URL{Path: path}).EscapedPath()
+	if query != "" {
+		target = "/api/jobs?q=" + url.QueryEscape(query)
+	}
+	b, err := json.Marshal(map[string]string{"action": action})
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.request(http.MethodPost, target, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reaching daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, remoteError(resp)
+	}
+	var jobs []jobJSON
+	if query == "" {
+		jobs = make([]jobJSON, 1)
+		err = json.NewDecoder(resp.Body).Decode(&jobs[0])
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(&jobs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("daemon: %w", err)
+	}
+	return jobs, nil
+}
+
+type remoteChangeMsg changeEvent
+
+type remoteStatusMsg struct{ err error }
+
+// watch follows the daemon's change events until the program exits,
+// reconnecting when the stream drops. Every (re)connect is reported so the
+// TUI can catch up on what it missed.
+func (c *remoteClient) watch(send func(tea.Msg)) {
+	for {
+		err := c.follow(send)
+		send(remoteStatusMsg{err})
+		time.Sleep(2 * time.Second)
+	}
+}
+
+func (c *remoteClient) follow(send func(tea.Msg)) error {
+	req, err := c.request(http.MethodGet, "/api/events", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return fmt.Errorf("reaching daemon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return remoteError(resp)
+	}
+	send(remoteStatusMsg{nil})
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e changeEvent
+		if json.Unmarshal([]byte(data), &e) == nil {
+			send(remoteChangeMsg(e))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("daemon closed the event stream")
+}
+
+func (m *model) busy() bool {
+	return m.createNewUI.creatingItem || m.deletionMode || m.moving != nil || m.conflict != nil || m.searching
+}
+
+// reloadRemote fetches the daemon's tree if it moved on from ours.
+func (m *model) reloadRemote() {
+	root, etag, err := m.remote.fetchConfig()
+	if err != nil {
+		m.statusString = renderWarning(err.Error())
+		return
+	}
+	m.remotePending = false
+	if etag == m.remoteETag {
+		return
+	}
+	m.remoteETag = etag
+	m.replaceTree(root)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestRemoteClientTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/events" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+			fmt.Fprintf(w, "event: change\ndata: {\"by\":\"ann\",\"etag\":\"\\\"x\\\"\"}\n\n")
+			return
+		}
+		<-hang
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	c := newRemoteClient(srv.URL, "", "me")
+	if c.client.Timeout == 0 {
+		t.Fatal("no timeout on calls to the daemon")
+	}
+	c.client.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	if _, err := c.putConfig(&Cluster{}, `"x"`); err == nil || time.Since(start) > time.Second {
+		t.Errorf("putConfig to a stuck daemon = %v after %s", err, time.Since(start))
+	}
+
+	// the event stream outlives the timeout
+	var got []tea.Msg
+	err := c.follow(func(msg tea.Msg) { got = append(got, msg) })
+	if len(got) != 2 || got[1].(remoteChangeMsg).By != "ann" {
+		t.Errorf("follow sent %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,108 @@
+// Package resources holds the types shared between the frontend and the
+// agent that runs on each phone.
+package resources
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Metrics is a single reading taken by the phone agent.
+type Metrics struct {
+	Hostname    string        `json:"hostname"`
+	CollectedAt time.Time     `json:"collected_at"`
+	CPUUsage    float64       `json:"cpu_usage"` // percent across all cores
+	Load        [3]float64    `json:"load"`      // 1, 5 and 15 minute averages
+	Memory      Memory        `json:"memory"`
+	Storage     []Storage     `json:"storage,omitempty"`
+	Battery     *Battery      `json:"battery,omitempty"`
+	Thermal     []ThermalZone `json:"thermal,omitempty"`
+}
+
+type Memory struct {
+	TotalBytes     uint64 `json:"total_bytes"`
+	AvailableBytes uint64 `json:"available_bytes"`
+}
+
+// UsedPercent is the share of memory that is not available.
+func (m Memory) UsedPercent() float64 {
+	if m.TotalBytes == 0 {
+		return 0
+	}
+	return 100 * float64(m.TotalBytes-m.AvailableBytes) / float64(m.TotalBytes)
+}
+
+type Storage struct {
+	Path       string `json:"path"`
+	TotalBytes uint64 `json:"total_bytes"`
+	FreeBytes  uint64 `json:"free_bytes"`
+	Error      string `json:"error,omitempty"` // the mount point couldn't be read
+}
+
+type Battery struct {
+	Level    int    `json:"level"` // percent
+	Charging bool   `json:"charging"`
+	Status   string `json:"status"` // as reported by the kernel, e.g. "Charging", "Full"
+}
+
+type ThermalZone struct {
+	Name  string  `json:"name"`
+	TempC float64 `json:"temp_c"`
+}
+
+// MaxTempC is the hottest thermal zone, or 0 if none were read.
+func (m Metrics) MaxTempC() float64 {
+	var hottest float64
+	for _, zone := range m.Thermal {
+		if zone.TempC > hottest {
+			hottest = zone.TempC
+		}
+	}
+	return hottest
+}
+
+// Info describes the phone's hardware, for discovery and inventory.
+type Info struct {
+	Hostname    string  `json:"hostname"`
+	Model       string  `json:"model,omitempty"`
+	CPUCores    int     `json:"cpu_cores"`
+	CPUMaxGHz   float64 `json:"cpu_max_ghz,omitempty"`
+	MemoryBytes uint64  `json:"memory_bytes"`
+
+	OSImage       string `json:"os_image,omitempty"`       // PRETTY_NAME from os-release
+	KernelVersion string `json:"kernel_version,omitempty"` // uname -r
+	KernelBuild   string `json:"kernel_build,omitempty"`   // see KernelBuildHash
+}
+
+// OSImage picks a readable OS name out of an os-release file.
+func OSImage(osRelease string) string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(osRelease, "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			fields[k] = strings.Trim(v, `"'`)
+		}
+	}
+	if fields["PRETTY_NAME"] != "" {
+		return fields["PRETTY_NAME"]
+	}
+	return strings.TrimSpace(fields["NAME"] + " " + fields["VERSION_ID"])
+}
+
+var gitSuffix = regexp.MustCompile(`-g([0-9a-f]{7,40})\b`)
+
+// KernelBuildHash identifies the exact kernel build: the git hash when the
+// release carries one ("-g1a2b3c4"), otherwise a digest of /proc/version,
+// which includes the compiler and build timestamp.
+func KernelBuildHash(release, procVersion string) string {
+	if m := gitSuffix.FindStringSubmatch(release); m != nil {
+		return m[1]
+	}
+	if procVersion == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(procVersion)))
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"strings"
+)
+
+const maxSearchResults = 20
+
+var matchStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("201")).Underline(true)
+
+type searchField struct {
+	label string // empty for the name
+	value string
+}
+
+// searchFields are what global search looks at on an item.
+func searchFields(item list.Item) []searchField {
+	var fields []searchField
+	add := func(label, value string) {
+		if value != "" {
+			fields = append(fields, searchField{label, value})
+		}
+	}
+	switch v := item.(type) {
+	case *Cluster:
+		add("", v.plainName())
+		add("desc", v.Desc)
+		add("required kernel", v.RequiredKernel)
+	case *Phone:
+		add("", v.Name)
+		add("desc", v.Desc)
+		add("RAM", v.RAM)
+		add("CPU", v.CPU)
+		add("CPU speed", v.CPUSpeed)
+		add("address", v.Address)
+		add("serial", v.Serial)
+		add("hostname", v.Hostname)
+		add("ID", v.ID)
+		for _, tag := range v.Tags {
+			add("tag", tag)
+		}
+		if v.Firmware != nil {
+			add("model", v.Firmware.Model)
+			add("kernel", v.Firmware.kernel())
+			add("OS", v.Firmware.OSImage)
+		}
+	}
+	return fields
+}
+
+type searchResult struct {
+	item    list.Item
+	field   searchField
+	matched []int
+}
+
+// searchTree fuzzy-matches term against every field of every item in the
+// tree, best match first, listing each item once for its best field.
+func searchTree(root *Cluster, term string) []searchResult {
+	var items []list.Item
+	var fields []searchField
+	var targets []string
+	var walk func(c *Cluster)
+	walk = func(c *Cluster) {
+		for _, f := range searchFields(c) {
+			items, fields, targets = append(items, c), append(fields, f), append(targets, f.value)
+		}
+		for _, p := range c.ChildrenPhones {
+			for _, f := range searchFields(p) {
+				items, fields, targets = append(items, p), append(fields, f), append(targets, f.value)
+			}
+		}
+		for _, child := range c.ChildrenClusters {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	var results []searchResult
+	seen := map[list.Item]bool{}
+	for _, rank := range list.DefaultFilter(term, targets) {
+		item := items[rank.Index]
+		if seen[item] {
+			continue
+		}
+		seen[item] = true
+		results = append(results, searchResult{item, fields[rank.Index], rank.MatchedIndexes})
+		if len(results) == maxSearchResults {
+			break
+		}
+	}
+	return results
+}
+
+func (r searchResult) print() string {
+	value := lipgloss.StyleRunes(r.field.value, r.matched, matchStyle, lipgloss.NewStyle())
+	var s string
+	switch v := r.item.(type) {
+	case *Cluster:
+		s = "🌐 " + v.returnPath()
+	case *Phone:
+		s = "📱 " + v.path()
+	}
+	if r.field.label == "" {
+		return s + "  " + value
+	}
+	return fmt.Sprintf("%s  %s: %s", s, r.field.label, value)
+}
+
+func (m *model) openSearch() {
+	m.searching = true
+	m.searchInput.SetValue("")
+	m.searchInput.Focus()
+	m.searchResults = nil
+	m.searchIndex = 0
+}
+
+// updateSearch handles keys while searching, searching again as the term
+// changes.
+func (m *model) updateSearch(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "up", "ctrl+p":
+		if m.searchIndex > 0 {
+			m.searchIndex--
+		}
+		return nil
+	case "down", "ctrl+n":
+		if m.searchIndex < len(m.searchResults)-1 {
+			m.searchIndex++
+		}
+		return nil
+	case "enter":
+		if m.searchIndex < len(m.searchResults) {
+			m.searching = false
+			m.reveal(m.searchResults[m.searchIndex].item)
+		}
+		return nil
+	case "esc":
+		m.searching = false
+		return nil
+	}
+	var cmd tea.Cmd
+	m.searchInput, cmd = m.searchInput.Update(msg)
+	m.searchResults = nil
+	if term := strings.TrimSpace(m.searchInput.Value()); term != "" {
+		m.searchResults = searchTree(m.rootCluster, term)
+	}
+	m.searchIndex = 0
+	return cmd
+}
+
+func (m *model) searchView() string {
+	s := "Search the whole tree\n\n" + m.searchInput.View() + "\n\n"
+	if len(m.searchResults) == 0 && m.searchInput.Value() != "" {
+		s += "Nothing matches.\n"
+	}
+	for i, r := range m.searchResults {
+		if i == m.searchIndex {
+			s += "> " + r.print() + "\n"
+		} else {
+			s += "  " + r.print() + "\n"
+		}
+	}
+	return s + "\nup/down: select • enter: go to it • esc: back"
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Simulator is the job backend used when no real hardware is attached.
+// All randomness comes from its own source so a run with a fixed seed
+// produces the same job display every time.
+type Simulator struct {
+	rng               *rand.Rand
+	BaseSpeed         float64 // progress per tick for a 1 GHz phone
+	FailureRate       float64 // chance per tick that a running job fails
+	HealthFailureRate float64 // chance per tick that a phone's health check fails
+	Latency           int     // ticks a started job waits before it makes progress
+}
+
+func newSimulator(seed int64) *Simulator {
+	return &Simulator{
+		rng:       rand.New(rand.NewSource(seed)),
+		BaseSpeed: 0.02,
+	}
+}
+
+// checkSimFlags refuses simulator settings given without -simulate, which
+// would be ignored otherwise.
+func checkSimFlags(fs *flag.FlagSet, simulate bool) error {
+	var set []string
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" || strings.HasPrefix(f.Name, "sim-") {
+			set = append(set, "-"+f.Name)
+		}
+	})
+	if len(set) > 0 && !simulate {
+		return fmt.Errorf("%s only apply with -simulate", strings.Join(set, ", "))
+	}
+	return nil
+}
+
+// latencyTicks converts a latency duration into whole ticks of periodicTicker.
+func latencyTicks(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+var ghzRe = regexp.MustCompile(`[0-9\.]+`)
+
+// phoneSpeed derives how fast a phone chews through a job from its CPUSpeed.
+// Phones without a parseable speed count as 1 GHz.
+func (s *Simulator) phoneSpeed(p *Phone) float64 {
+	ghz, err := strconv.ParseFloat(ghzRe.FindString(p.CPUSpeed), 64)
+	if err != nil || ghz <= 0 {
+		ghz = 1
+	}
+	return s.BaseSpeed * ghz
+}
+
+// clusterSpeed averages the speed of every phone below c.
+func (s *Simulator) clusterSpeed(c *Cluster) float64 {
+	var total float64
+	var phones int
+	var walk func(c *Cluster)
+	walk = func(c *Cluster) {
+		for _, p := range c.ChildrenPhones {
+			total += s.phoneSpeed(p)
+			phones++
+		}
+		for _, child := range c.ChildrenClusters {
+			walk(child)
+		}
+	}
+	walk(c)
+	if phones == 0 {
+		return s.BaseSpeed
+	}
+	return total / float64(phones)
+}
+
+func (s *Simulator) startJob(c *Cluster) {
+	c.JobState = "running"
+	c.jobDelay = s.Latency
+}
+
+// controlJob starts, stops or restarts c's job.
+func (s *Simulator) controlJob(c *Cluster, action string) error {
+	switch action {
+	case "start":
+		s.startJob(c)
+	case "stop":
+		c.JobState = "stopped"
+	case "restart":
+		c.JobPercentage = 0
+		s.startJob(c)
+	default:
+		return fmt.Errorf("unknown action %q, use start, stop or restart", action)
+	}
+	return nil
+}
+
+func (s *Simulator) step(c *Cluster) {
+	if c.JobState == "running" && c.JobPercentage < 1.0 {
+		switch {
+		case c.jobDelay > 0:
+			c.jobDelay--
+		case s.FailureRate > 0 && s.rng.Float64() < s.FailureRate:
+			c.JobState = "failed"
+		default:
+			// jitter between half and one and a half times the nominal speed
+			c.JobPercentage += s.clusterSpeed(c) * (0.5 + s.rng.Float64())
+		}
+		if c.JobPercentage > 1.0 {
+			c.JobPercentage = 1.0
+		}
+	}
+	for _, child := range c.ChildrenClusters {
+		s.step(child)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// simulatedRun drives jobs, health checks and metrics through the simulator
+// for a number of ticks and describes every tick.
+func simulatedRun(t *testing.T, seed int64, ticks int) string {
+	t.Helper()
+	root := &Cluster{ChildrenClusters: []*Cluster{
+		{ID: "fast", Name: "Fast", ChildrenPhones: []*Phone{{ID: "a", Name: "a", CPUSpeed: "2.8 GHz", Address: "a:9101"}}},
+		{ID: "slow", Name: "Slow", ChildrenPhones: []*Phone{{ID: "b", Name: "b", CPUSpeed: "1.2 GHz", Address: "b:9101"}}},
+	}}
+	reconstructClusterFromJSON(root)
+	sim := newSimulator(seed)
+	sim.FailureRate = 0.02
+	sim.HealthFailureRate = 0.2
+	sim.Latency = 2
+	m := &model{rootCluster: root, sim: sim, simulate: true}
+	for _, c := range root.ChildrenClusters {
+		sim.startJob(c)
+	}
+
+	var trace strings.Builder
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for tick := range ticks {
+		now = now.Add(time.Second)
+		root.updateJobPercentages(sim)
+		m.checkHealth(now)
+		m.collectMetrics(now)
+		fmt.Fprintf(&trace, "%d:", tick)
+		for _, c := range root.ChildrenClusters {
+			fmt.Fprintf(&trace, " %s %s %.4f", c.Name, c.JobState, c.JobPercentage)
+		}
+		root.walkPhones(func(p *Phone) {
+			fmt.Fprintf(&trace, " %s %v %.2f %.2f", p.Name, p.Health.LastErr, p.Metrics.CPUUsage, p.Metrics.Thermal[0].TempC)
+		})
+		trace.WriteString("\n")
+	}
+	return trace.String()
+}
+
+func TestSimulatorDeterministic(t *testing.T) {
+	first := simulatedRun(t, 42, 60)
+	if again := simulatedRun(t, 42, 60); again != first {
+		t.Errorf("same seed, different runs:\n%s\nthen:\n%s", first, again)
+	}
+	if other := simulatedRun(t, 43, 60); other == first {
+		t.Error("a different seed gave the same run")
+	}
+	if !strings.Contains(first, "simulated timeout") || !strings.Contains(first, "running") {
+		t.Errorf("run exercised nothing:\n%s", first)
+	}
+}
+
+func TestCheckSimFlags(t *testing.T) {
+	for _, tt := range []struct {
+		args     []string
+		simulate bool
+		err      string
+	}{
+		{args: nil},
+		{args: []string{"-sim-latency", "2s"}, simulate: true},
+		{args: []string{"-seed", "7", "-sim-failure-rate", "0.1"}, err: "-seed, -sim-failure-rate only apply with -simulate"},
+		{args: []string{"-sim-health-failure-rate", "0.5"}, err: "-sim-health-failure-rate only apply"},
+		{args: []string{"-headless"}},
+	} {
+		fs := flag.NewFlagSet("frontend", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.Int64("seed", 1, "")
+		fs.Float64("sim-failure-rate", 0, "")
+		fs.Float64("sim-health-failure-rate", 0, "")
+		fs.Duration("sim-latency", 0, "")
+		fs.Bool("headless", false, "")
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		err := checkSimFlags(fs, tt.simulate)
+		if (err == nil) != (tt.err == "") || err != nil && !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q simulate=%v: %v, want %q", tt.args, tt.simulate, err, tt.err)
+		}
+	}
+}
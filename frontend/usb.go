@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	usbSysfs    = "/sys/bus/usb/devices"
+	usbInterval = 2 // ticks between USB scans
+)
+
+// USB modes a phone can show up in.
+const (
+	usbModeNormal   = "normal" // no debugging interface, USB debugging is off
+	usbModeADB      = "adb"
+	usbModeFastboot = "fastboot"
+	usbModeDownload = "download" // Samsung Odin
+	usbModeEDL      = "edl"      // Qualcomm emergency download
+)
+
+type usbVendor struct {
+	Name   string
+	Phones bool // only list it in normal mode if the vendor makes phones
+}
+
+// usbVendors maps idVendor to a vendor name that findVendor understands.
+var usbVendors = map[string]usbVendor{
+	"18d1": {"Google", true},
+	"2a70": {"OnePlus", true},
+	"04e8": {"Samsung", true},
+	"22b8": {"Motorola", true},
+	"2717": {"Xiaomi", true},
+	"2ae5": {"Fairphone", true},
+	"0bda": {"Realtek", false},
+	"05c6": {"Qualcomm", false},
+}
+
+// usbProductModes are vendor:product pairs that only appear in one mode.
+var usbProductModes = map[string]string{
+	"04e8:685d": usbModeDownload,
+	"05c6:9008": usbModeEDL,
+}
+
+type USBDevice struct {
+	Path         string // sysfs name, e.g. 1-1.2
+	VendorID     string
+	ProductID    string
+	Vendor       string
+	Manufacturer string
+	Product      string
+	Serial       string
+	Mode         string
+}
+
+// key identifies the phone across re-enumeration; the serial survives a
+// reboot into the bootloader, the port path is the fallback.
+func (d USBDevice) key() string {
+	if d.Serial != "" {
+		return d.Serial
+	}
+	return d.Path
+}
+
+func (d USBDevice) name() string {
+	if strings.HasPrefix(d.Product, d.Vendor) {
+		return d.Product
+	}
+	return strings.TrimSpace(d.Vendor + " " + d.Product)
+}
+
+func (d USBDevice) print() string {
+	name := d.name()
+	if d.Serial != "" {
+		name += " (serial " + d.Serial + ")"
+	}
+	return fmt.Sprintf("%s, %s mode, port %s", name, d.Mode, d.Path)
+}
+
+func readSysfs(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// interfaceMode looks for the Android debugging interfaces: class ff,
+// subclass 42, protocol 01 for adb and 03 for fastboot.
+func interfaceMode(devDir string) string {
+	ifaces, _ := filepath.Glob(devDir + ":*")
+	mode := ""
+	for _, iface := range ifaces {
+		if readSysfs(iface, "bInterfaceClass") != "ff" || readSysfs(iface, "bInterfaceSubClass") != "42" {
+			continue
+		}
+		switch readSysfs(iface, "bInterfaceProtocol") {
+		case "01":
+			mode = usbModeADB
+		case "03":
+			return usbModeFastboot
+		}
+	}
+	return mode
+}
+
+// enumerateUSB lists the phones attached under a sysfs devices directory.
+// Hubs, interfaces and unknown devices are skipped.
+func enumerateUSB(root string) ([]USBDevice, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", root, err)
+	}
+	var devices []USBDevice
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ":") {
+			continue
+		}
+		dir := filepath.Join(root, e.Name())
+		d := USBDevice{
+			Path:         e.Name(),
+			VendorID:     strings.ToLower(readSysfs(dir, "idVendor")),
+			ProductID:    strings.ToLower(readSysfs(dir, "idProduct")),
+			Manufacturer: readSysfs(dir, "manufacturer"),
+			Product:      readSysfs(dir, "product"),
+			Serial:       readSysfs(dir, "serial"),
+		}
+		if d.VendorID == "" {
+			continue
+		}
+		vendor, known := usbVendors[d.VendorID]
+		d.Vendor = vendor.Name
+		if d.Vendor == "" {
+			d.Vendor = d.Manufacturer
+		}
+		d.Mode = usbProductModes[d.VendorID+":"+d.ProductID]
+		if d.Mode == "" {
+			d.Mode = interfaceMode(dir)
+		}
+		if d.Mode == "" {
+			if !known || !vendor.Phones {
+				continue
+			}
+			d.Mode = usbModeNormal
+		}
+		devices = append(devices, d)
+	}
+	slices.SortFunc(devices, func(a, b USBDevice) int { return strings.Compare(a.Path, b.Path) })
+	return devices, nil
+}
+
+// usbHints explains phones that are plugged in but that adb and fastboot
+// can't talk to.
+func usbHints(devices []USBDevice) []string {
+	var hints []string
+	for _, d := range devices {
+		switch d.Mode {
+		case usbModeNormal:
+			hints = append(hints, d.print()+": enable USB debugging to provision it")
+		case usbModeDownload, usbModeEDL:
+			hints = append(hints, d.print()+": reboot it normally, this mode can't be unlocked with fastboot")
+		}
+	}
+	return hints
+}
+
+type usbMsg struct {
+	devices []USBDevice
+	err     error
+}
+
+func usbScanCmd(root string) tea.Cmd {
+	return func() tea.Msg {
+		devices, err := enumerateUSB(root)
+		return usbMsg{devices, err}
+	}
+}
+
+func (i *Cluster) phoneBySerial(serial string) *Phone {
+	var found *Phone
+	i.walkPhones(func(p *Phone) {
+		if found == nil && serial != "" && p.Serial == serial {
+			found = p
+		}
+	})
+	return found
+}
+
+// usbHotplug diffs a scan against the last one. Devices that are new or
+// changed mode come back as arrivals; the first scan only sets the baseline.
+func (m *model) usbHotplug(devices []USBDevice) []USBDevice {
+	var arrived []USBDevice
+	seen := map[string]USBDevice{}
+	for _, d := range devices {
+		seen[d.key()] = d
+		if m.usbSeen == nil {
+			continue
+		}
+		if old, ok := m.usbSeen[d.key()]; !ok || old.Mode != d.Mode {
+			arrived = append(arrived, d)
+		}
+	}
+	m.usbSeen = seen
+	return arrived
+}
+
+func (m *model) usbPromptView() string {
+	d := m.usbPending[0]
+	s := "New phone on USB\n\n\t" + d.print() + "\n\n"
+	if len(m.usbPending) > 1 {
+		s += fmt.Sprintf("%d more waiting\n\n", len(m.usbPending)-1)
+	}
+	return s + "Attach to cluster " + m.currentCluster.Name + "? y: attach • n/esc: ignore"
+}
+
+// attachUSBDevice opens the new phone form for a USB device.
+func (m *model) attachUSBDevice(d USBDevice) {
+	m.createNewUI.creatingItem = true
+	m.createNewUI.shouldCreateCluster = false
+	m.createNewUI.serial = d.Serial
+	m.createNewUI.status = "Attach Phone (serial " + d.Serial + "): " + PHONE_MESSAGE
+	m.createNewUI.nameInput.SetValue(d.name())
+	m.createNewUI.descInput.SetValue("attached over USB")
+	m.createNewUI.nameInput.Focus()
+	m.createNewUI.descInput.Blur()
+}
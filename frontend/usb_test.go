@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sysfsDevice writes a device's attributes, and its interfaces' as
+// "<name>:1.<n>" siblings, under a fake /sys/bus/usb/devices.
+func sysfsDevice(t *testing.T, root, name string, attrs map[string]string, ifaces ...[3]string) {
+	t.Helper()
+	write := func(dir string, attrs map[string]string) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range attrs {
+			if err := os.WriteFile(filepath.Join(dir, k), []byte(v+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write(filepath.Join(root, name), attrs)
+	for i, iface := range ifaces {
+		write(filepath.Join(root, name+":1."+string(rune('0'+i))), map[string]string{
+			"bInterfaceClass": iface[0], "bInterfaceSubClass": iface[1], "bInterfaceProtocol": iface[2],
+		})
+	}
+}
+
+var (
+	adbIface      = [3]string{"ff", "42", "01"}
+	fastbootIface = [3]string{"ff", "42", "03"}
+	mtpIface      = [3]string{"06", "01", "01"}
+)
+
+func fakeSysfs(t *testing.T) string {
+	root := t.TempDir()
+	sysfsDevice(t, root, "usb1", map[string]string{"idVendor": "1d6b", "idProduct": "0002", "product": "xHCI Host Controller"}, [3]string{"09", "00", "00"})
+	sysfsDevice(t, root, "1-2", map[string]string{"idVendor": "0BDA", "idProduct": "5411", "product": "4-Port USB 2.0 Hub"}, [3]string{"09", "00", "02"})
+	sysfsDevice(t, root, "1-1", map[string]string{"idVendor": "18d1", "idProduct": "4ee7", "manufacturer": "Google", "product": "Pixel 7", "serial": "A1"}, mtpIface, adbIface)
+	sysfsDevice(t, root, "1-3", map[string]string{"idVendor": "18d1", "idProduct": "4ee0", "product": "Android", "serial": "B1"}, fastbootIface)
+	sysfsDevice(t, root, "1-4", map[string]string{"idVendor": "04e8", "idProduct": "685d", "product": "SAMSUNG USB"})
+	sysfsDevice(t, root, "1-5", map[string]string{"idVendor": "05c6", "idProduct": "9008", "product": "QUSB__BULK"})
+	sysfsDevice(t, root, "1-6", map[string]string{"idVendor": "22b8", "idProduct": "2e82", "product": "moto g", "serial": "M1"}, mtpIface)
+	sysfsDevice(t, root, "1-7", map[string]string{"idVendor": "046d", "idProduct": "c52b", "manufacturer": "Logitech", "product": "USB Receiver"}, [3]string{"03", "01", "01"})
+	sysfsDevice(t, root, "1-8", map[string]string{"idVendor": "1234", "idProduct": "0001", "manufacturer": "Acme", "product": "Phone", "serial": "X1"}, adbIface)
+	return root
+}
+
+func TestEnumerateUSB(t *testing.T) {
+	devices, err := enumerateUSB(fakeSysfs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ path, vendor, mode string }{
+		{"1-1", "Google", usbModeADB},
+		{"1-3", "Google", usbModeFastboot},
+		{"1-4", "Samsung", usbModeDownload},
+		{"1-5", "Qualcomm", usbModeEDL},
+		{"1-6", "Motorola", usbModeNormal},
+		{"1-8", "Acme", usbModeADB},
+	}
+	if len(devices) != len(want) {
+		t.Fatalf("devices = %+v", devices)
+	}
+	for i, w := range want {
+		d := devices[i]
+		if d.Path != w.path || d.Vendor != w.vendor || d.Mode != w.mode {
+			t.Errorf("device %d = %s %s %s, want %s %s %s", i, d.Path, d.Vendor, d.Mode, w.path, w.vendor, w.mode)
+		}
+	}
+	if hints := usbHints(devices); len(hints) != 3 {
+		t.Errorf("hints = %q", hints)
+	}
+
+	if _, err := enumerateUSB(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("no error for a missing sysfs directory")
+	}
+}
+
+func TestUSBHotplug(t *testing.T) {
+	root := fakeSysfs(t)
+	m := &model{}
+	scan := func() []USBDevice {
+		devices, err := enumerateUSB(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.usbHotplug(devices)
+	}
+	if arrived := scan(); len(arrived) != 0 {
+		t.Errorf("first scan reported %+v", arrived)
+	}
+	if arrived := scan(); len(arrived) != 0 {
+		t.Errorf("unchanged scan reported %+v", arrived)
+	}
+
+	// A1 reboots into the bootloader on another port, M1 is unplugged and a
+	// new phone turns up
+	os.RemoveAll(filepath.Join(root, "1-1"))
+	os.RemoveAll(filepath.Join(root, "1-1:1.0"))
+	os.RemoveAll(filepath.Join(root, "1-1:1.1"))
+	os.RemoveAll(filepath.Join(root, "1-6"))
+	sysfsDevice(t, root, "1-9", map[string]string{"idVendor": "18d1", "idProduct": "4ee0", "product": "Android", "serial": "A1"}, fastbootIface)
+	sysfsDevice(t, root, "2-1", map[string]string{"idVendor": "2a70", "idProduct": "4ee7", "product": "OnePlus 9", "serial": "N1"}, adbIface)
+
+	arrived := scan()
+	if len(arrived) != 2 || arrived[0].Serial != "A1" || arrived[0].Mode != usbModeFastboot || arrived[1].Serial != "N1" {
+		t.Errorf("arrived = %+v", arrived)
+	}
+
+	// plugging M1 back in counts as arriving again
+	sysfsDevice(t, root, "1-6", map[string]string{"idVendor": "22b8", "idProduct": "2e82", "product": "moto g", "serial": "M1"}, mtpIface)
+	if arrived := scan(); len(arrived) != 1 || arrived[0].Serial != "M1" || arrived[0].Mode != usbModeNormal {
+		t.Errorf("arrived = %+v", arrived)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Vendor describes how to unlock a manufacturer's bootloader. Keep this
+// table in sync with the phones we actually buy.
+type Vendor struct {
+	Name       string
+	Match      []string // lower-case substrings of ro.product.manufacturer or the USB vendor name
+	UnlockArgs []string // fastboot arguments, nil if fastboot can't unlock it
+	NeedsCode  bool     // fastboot also wants a per-device unlock code from the vendor
+	Note       string
+}
+
+var vendors = []Vendor{
+	{Name: "Google", Match: []string{"google"}, UnlockArgs: []string{"flashing", "unlock"}},
+	{Name: "OnePlus", Match: []string{"oneplus"}, UnlockArgs: []string{"oem", "unlock"}},
+	{Name: "Motorola", Match: []string{"motorola"}, UnlockArgs: []string{"oem", "unlock"}, NeedsCode: true, Note: "get the unlock code from Motorola's bootloader unlock site"},
+	{Name: "Fairphone", Match: []string{"fairphone"}, UnlockArgs: []string{"flashing", "unlock"}},
+	{Name: "Xiaomi", Match: []string{"xiaomi", "redmi", "poco"}, Note: "unlock with Mi Unlock, fastboot is refused"},
+	{Name: "Samsung", Match: []string{"samsung"}, Note: "no fastboot; enable OEM unlock and use download mode"},
+	{Name: "Realtek", Match: []string{"realtek"}, UnlockArgs: []string{"oem", "unlock"}},
+}
+
+// findVendor matches a manufacturer string against the table.
+func findVendor(manufacturer string) (Vendor, bool) {
+	m := strings.ToLower(manufacturer)
+	if m == "" {
+		return Vendor{}, false
+	}
+	for _, v := range vendors {
+		for _, match := range v.Match {
+			if strings.Contains(m, match) {
+				return v, true
+			}
+		}
+	}
+	return Vendor{}, false
+}
+
+func (v Vendor) canUnlock() bool {
+	return v.UnlockArgs != nil
+}
+
+func (v Vendor) unlockArgs(code string) []string {
+	if v.NeedsCode {
+		return append(slices.Clone(v.UnlockArgs), code)
+	}
+	return v.UnlockArgs
+}
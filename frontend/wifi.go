@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WifiProfile is a network phones under a cluster should join. The key is
+// encrypted with the secret key file, see secrets.go.
+type WifiProfile struct {
+	SSID string `json:"ssid"`
+	PSK  string `json:"psk,omitempty"` // empty for open networks
+}
+
+func (i *Cluster) effectiveWifi() []WifiProfile {
+	for current := i; current != nil; current = current.Parent {
+		if len(current.Wifi) > 0 {
+			return current.Wifi
+		}
+	}
+	return nil
+}
+
+// remoteShell runs a shell command line on a phone, feeding it stdin.
+// Secrets go through stdin, the command line shows up in ps.
+type remoteShell interface {
+	run(ctx context.Context, command, stdin string) (string, error)
+}
+
+type adbShell struct {
+	bin    string
+	serial string
+}
+
+func (s adbShell) run(ctx context.Context, command, stdin string) (string, error) {
+	cmd := exec.CommandContext(ctx, s.bin, "-s", s.serial, "shell", command)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("adb -s %s shell: %w", s.serial, err)
+	}
+	return string(out), nil
+}
+
+type sshShell struct {
+	bin    string
+	target string
+}
+
+func (s sshShell) run(ctx context.Context, command, stdin string) (string, error) {
+	cmd := exec.CommandContext(ctx, s.bin, "-T", "-o", "BatchMode=yes", "-o", "ConnectTimeout=10", s.target, command)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("ssh %s: %w", s.target, err)
+	}
+	return string(out), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var (
+	inetRe    = regexp.MustCompile(`inet (\d+\.\d+\.\d+\.\d+)/`)
+	gatewayRe = regexp.MustCompile(`default via (\S+)`)
+)
+
+// onboardWifi joins the phone to the first profile that works and checks it
+// can reach its gateway, returning the SSID and the address it got.
+func onboardWifi(ctx context.Context, sh remoteShell, profiles []WifiProfile, key []byte, step func(string)) (string, string, error) {
+	step("checking wlan0")
+	if _, err := sh.run(ctx, "ip -o link show wlan0", ""); err != nil {
+		return "", "", fmt.Errorf("no wlan0, is the right kernel flashed? %w", err)
+	}
+	sh.run(ctx, "nmcli radio wifi on", "")
+
+	var ssid string
+	var errs []error
+	for _, profile := range profiles {
+		cmd := "nmcli device wifi connect " + shellQuote(profile.SSID) + " ifname wlan0"
+		var stdin string
+		if profile.PSK != "" {
+			psk, err := decryptSecret(key, profile.PSK)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", profile.SSID, err))
+				continue
+			}
+			// nmcli --ask prompts for the password, which keeps it off the
+			// phone's command line
+			cmd = "nmcli --ask device wifi connect " + shellQuote(profile.SSID) + " ifname wlan0"
+			stdin = psk + "\n"
+		}
+		step("joining " + profile.SSID)
+		if out, err := sh.run(ctx, cmd, stdin); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", profile.SSID, strings.TrimSpace(out)))
+			continue
+		}
+		ssid = profile.SSID
+		break
+	}
+	if ssid == "" {
+		return "", "", fmt.Errorf("no Wi-Fi profile worked: %w", errors.Join(errs...))
+	}
+
+	step("waiting for an address")
+	var ip string
+	for range 15 {
+		out, _ := sh.run(ctx, "ip -4 -o addr show dev wlan0", "")
+		if match := inetRe.FindStringSubmatch(out); match != nil {
+			ip = match[1]
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ssid, "", ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	if ip == "" {
+		return ssid, "", errors.New("wlan0 got no IPv4 address")
+	}
+
+	step("checking connectivity")
+	out, err := sh.run(ctx, "ip -4 route show default dev wlan0", "")
+	match := gatewayRe.FindStringSubmatch(out)
+	if err != nil || match == nil {
+		return ssid, ip, errors.New("no default route on wlan0")
+	}
+	if out, err := sh.run(ctx, "ping -c 1 -W 3 "+shellQuote(match[1]), ""); err != nil {
+		return ssid, ip, fmt.Errorf("gateway %s unreachable: %s", match[1], strings.TrimSpace(out))
+	}
+	return ssid, ip, nil
+}
+
+// withHost swaps the host in an address, keeping a user@ prefix or a port.
+func withHost(addr, host string) string {
+	user := ""
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		user, addr = addr[:i+1], addr[i+1:]
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil {
+		return user + net.JoinHostPort(host, port)
+	}
+	return user + host
+}
+
+// readPassword reads a line from the terminal with echo off. Piped input is
+// read as is.
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		stty := func(arg string) error {
+			cmd := exec.Command("stty", arg)
+			cmd.Stdin = os.Stdin
+			return cmd.Run()
+		}
+		if err := stty("-echo"); err != nil {
+			fmt.Fprintln(os.Stderr)
+			return "", fmt.Errorf("turning off echo: %w", err)
+		}
+		// ctrl+c would leave the terminal without echo
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-interrupt:
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+				os.Exit(130)
+			case <-done:
+			}
+		}()
+		defer func() {
+			signal.Stop(interrupt)
+			close(done)
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("reading the password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func runWifiAdd(args []string) int {
+	fs := flag.NewFlagSet("provision wifi-add", flag.ExitOnError)
+	clusterPath := fs.String("cluster", "", "cluster path the profile is for, e.g. Root/Lab (default root)")
+	ssid := fs.String("ssid", "", "network name")
+	keyPath := fs.String("key", defaultSecretKey(), "secret key file, created if missing")
+	fs.Parse(args)
+	if *ssid == "" {
+		fmt.Fprintln(os.Stderr, "-ssid is required")
+		return 2
+	}
+
+	root, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cluster, err := findCluster(root, *clusterPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	key, err := loadSecretKey(*keyPath, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	psk, err := readPassword("Password (empty for an open network): ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	profile := WifiProfile{SSID: *ssid}
+	if psk != "" {
+		if profile.PSK, err = encryptSecret(key, psk); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	err = updateConfig(func(root *Cluster) error {
+		if cluster, err = findCluster(root, *clusterPath); err != nil {
+			return err
+		}
+		cluster.Wifi = slices.DeleteFunc(cluster.Wifi, func(p WifiProfile) bool { return p.SSID == *ssid })
+		cluster.Wifi = append(cluster.Wifi, profile)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Added %s to %s\n", *ssid, cluster.labelPath())
+	return 0
+}
+
+func runWifi(args []string) int {
+	fs := flag.NewFlagSet("provision wifi", flag.ExitOnError)
+	ref := fs.String("phone", "", "phone ID, serial, name or path")
+	via := fs.String("via", "auto", "adb, ssh, or auto (adb when the phone has a serial)")
+	adb := fs.String("adb", "adb", "adb binary")
+	sshBin := fs.String("ssh", "ssh", "ssh binary")
+	keyPath := fs.String("key", defaultSecretKey(), "secret key file")
+	timeout := fs.Duration("timeout", 2*time.Minute, "time allowed for onboarding")
+	fs.Parse(args)
+
+	root, err := loadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	phone, err := findPhone(root, *ref)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	profiles := phone.ParentCluster.effectiveWifi()
+	if len(profiles) == 0 {
+		fmt.Fprintf(os.Stderr, "no Wi-Fi profiles for %s, add one with provision wifi-add\n", phone.labelPath())
+		return 1
+	}
+	key, err := loadSecretKey(*keyPath, false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *via == "auto" {
+		*via = "ssh"
+		if phone.Serial != "" {
+			*via = "adb"
+		}
+	}
+	var sh remoteShell
+	switch *via {
+	case "adb":
+		if phone.Serial == "" {
+			fmt.Fprintf(os.Stderr, "%s has no serial to reach it over adb\n", phone.Name)
+			return 1
+		}
+		sh = adbShell{*adb, phone.Serial}
+	case "ssh":
+		if phone.Address == "" {
+			fmt.Fprintf(os.Stderr, "%s has no address to reach it over ssh\n", phone.Name)
+			return 1
+		}
+		sh = sshShell{*sshBin, phone.Address}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -via %q\n", *via)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	ssid, ip, onboardErr := onboardWifi(ctx, sh, profiles, key, func(step string) {
+		fmt.Printf("%s: %s\n", phone.Name, step)
+	})
+	status := 0
+	if onboardErr != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", phone.Name, onboardErr)
+		status = 1
+	} else {
+		fmt.Printf("%s: joined %s as %s\n", phone.Name, ssid, ip)
+	}
+	err = updateConfig(func(root *Cluster) error {
+		_, p := root.findByID(phone.ID)
+		if p == nil {
+			return fmt.Errorf("%s was removed in the meantime", phone.labelPath())
+		}
+		if onboardErr != nil {
+			p.failStage(StageWifi, onboardErr, time.Now())
+		} else {
+			p.Address = withHost(p.Address, ip)
+			p.advance(StageWifi, time.Now())
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "result not recorded:", err)
+		return 1
+	}
+	return status
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakePhoneShell answers onboarding's commands for a phone that only joins
+// "Lab" with the right password.
+type fakePhoneShell struct {
+	commands []string
+	stdins   []string
+}
+
+func (f *fakePhoneShell) run(ctx context.Context, command, stdin string) (string, error) {
+	f.commands = append(f.commands, command)
+	f.stdins = append(f.stdins, stdin)
+	switch {
+	case strings.Contains(command, "wifi connect 'Lab'"):
+		if stdin != "hunter2\n" {
+			return "Error: Secrets were required, but not provided.", errTest
+		}
+		return "Device 'wlan0' successfully activated", nil
+	case strings.Contains(command, "wifi connect"):
+		return "Error: No network with SSID found.", errTest
+	case strings.HasPrefix(command, "ip -4 -o addr"):
+		return "3: wlan0    inet 192.168.1.40/24 brd 192.168.1.255 scope global wlan0", nil
+	case strings.HasPrefix(command, "ip -4 route"):
+		return "default via 192.168.1.1 proto dhcp", nil
+	}
+	return "", nil
+}
+
+var errTest = errors.New("exit status 1")
+
+func TestOnboardWifiKeepsPasswordOffTheCommandLine(t *testing.T) {
+	key, err := loadSecretKey(filepath.Join(t.TempDir(), "secret.key"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	psk, err := encryptSecret(key, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := &fakePhoneShell{}
+	profiles := []WifiProfile{{SSID: "Guest"}, {SSID: "Lab", PSK: psk}}
+	ssid, ip, err := onboardWifi(context.Background(), sh, profiles, key, func(string) {})
+	if err != nil || ssid != "Lab" || ip != "192.168.1.40" {
+		t.Fatalf("onboardWifi = %q, %q, %v", ssid, ip, err)
+	}
+	for _, command := range sh.commands {
+		if strings.Contains(command, "hunter2") {
+			t.Errorf("password on the command line: %s", command)
+		}
+	}
+	if !strings.Contains(strings.Join(sh.commands, "\n"), "nmcli --ask device wifi connect 'Lab'") {
+		t.Errorf("commands:\n%s", strings.Join(sh.commands, "\n"))
+	}
+}
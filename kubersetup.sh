@@ -7,14 +7,22 @@ if [[ $string == *"does not exist"* ]]; then
   echo "wifi borked exiting"
   exit 1
 fi
+# frontend provision wifi does this from the workstation with stored profiles
 echo "first 10 networks"
 nmcli d wifi | head -n 5
 echo "ssid:"
 read ssid
-nmcli d wifi connect "${ssid}"--ask
+nmcli d wifi connect "${ssid}" --ask
 apk add k3s
 
-sudo wget "https://192.168.0.1:8080/k3s_config" --output-file /etc/conf.d/k3s
-sudo wget "https://192.168.0.1:8080/rancher_config" --output-file /etc/rancher/k3s/config.yaml
+# ca.crt comes from the frontend's -bootstrap-tls-dir, push it with adb first
+# the enrollment token is generated per phone in the frontend (T)
+if [ -z "$TOKEN" ]; then
+  echo "enrollment token:"
+  read TOKEN
+fi
+sudo mkdir -p /etc/rancher/k3s
+sudo wget --ca-certificate=/etc/powercluster-ca.crt "https://192.168.0.1:8080/k3s_config?token=${TOKEN}" -O /etc/conf.d/k3s
+sudo wget --ca-certificate=/etc/powercluster-ca.crt "https://192.168.0.1:8080/rancher_config?token=${TOKEN}" -O /etc/rancher/k3s/config.yaml
 service k3s start
 rc-update add k3s